@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"byted/DB_engine/config"
+	"byted/DB_engine/core/backup"
+	"byted/DB_engine/core/bucket"
+	"byted/DB_engine/core/lock"
+)
+
+// exit codes
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+const usage = `usage:
+  bytedata-backup backup  [server flags] <dir> [incremental <base_dir>]
+  bytedata-backup verify  <dir>
+  bytedata-backup restore [server flags] <dir>
+
+backup can run while the server is serving writes; restore needs it stopped.
+Server flags (-data-dir, -config, ...) locate the data directory as the server does.`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(exitUsage)
+	}
+
+	cfg, err := config.Load(os.Args[2:])
+	if err != nil {
+		fmt.Println("Invalid configuration:", err)
+		os.Exit(exitUsage)
+	}
+
+	switch os.Args[1] {
+	case "backup":
+		err = runBackup(cfg)
+	case "verify":
+		err = runVerify(cfg)
+	case "restore":
+		err = runRestore(cfg)
+	default:
+		fmt.Println(usage)
+		os.Exit(exitUsage)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(exitError)
+	}
+	os.Exit(exitOK)
+}
+
+func runBackup(cfg *config.Config) error {
+	args := cfg.Args
+	if len(args) != 1 && (len(args) != 3 || args[1] != "incremental") {
+		return errors.New(usage)
+	}
+	req := backup.Request{Dest: args[0], AuthFile: cfg.AuthFile}
+	if len(args) == 3 {
+		req.Base = args[2]
+	}
+
+	var err error
+	if req.Catalog, req.Buckets, err = bucket.OfflineBackupSources(cfg.BucketsDir(), cfg.MetaPath()); err != nil {
+		return err
+	}
+	manifest, err := backup.Create(req)
+	if err != nil {
+		return fmt.Errorf("backup failed: %v", err)
+	}
+	for _, b := range manifest.Buckets {
+		fmt.Printf("  %-20s LSN %-8d %d bytes\n", b.Name, b.LSN, b.End-b.Start)
+	}
+	fmt.Printf("Backup of %d bucket(s) written to %s\n", len(manifest.Buckets), args[0])
+	return nil
+}
+
+func runVerify(cfg *config.Config) error {
+	if len(cfg.Args) != 1 {
+		return errors.New(usage)
+	}
+	dirs, _, err := backup.Chain(cfg.Args[0])
+	if err != nil {
+		return err
+	}
+	if _, err := backup.Verify(cfg.Args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Backup is intact (%d backup(s) in the chain)\n", len(dirs))
+	return nil
+}
+
+func runRestore(cfg *config.Config) error {
+	if len(cfg.Args) != 1 {
+		return errors.New(usage)
+	}
+
+	// refuses while the server is running
+	dirLock, err := lock.Acquire(cfg.DataDir)
+	if err != nil {
+		if errors.Is(err, lock.ErrLocked) {
+			return fmt.Errorf("%v, stop the server before restoring", err)
+		}
+		return err
+	}
+	defer dirLock.Release()
+
+	err = backup.Restore(cfg.Args[0], backup.Target{
+		BucketsDir: cfg.BucketsDir(),
+		MetaPath:   cfg.MetaPath(),
+		AuthFile:   cfg.AuthFile,
+	})
+	if err != nil {
+		return fmt.Errorf("restore failed: %v", err)
+	}
+	fmt.Printf("Restored %s into %s, the previous data was kept with a .pre-restore suffix\n", cfg.Args[0], cfg.DataDir)
+	return nil
+}
@@ -9,26 +9,23 @@ import (
 	"os"
 	"strings"
 	"golang.org/x/term"
+
+	"byted/DB_engine/codec"
+	"byted/DB_engine/structs"
 )
 
-// Message matches the JSON protocol used by the server
-type Message struct {
-	Type     string   `json:"type"`
-	Bucket   string   `json:"bucket"`
-	Field    string   `json:"field,omitempty"`
-	Username string   `json:"username,omitempty"`
-	Password string   `json:"password,omitempty"`
-	Command  string   `json:"command,omitempty"`
-	Message  string   `json:"message,omitempty"`
-	Data     []string `json:"data,omitempty"`
-}
+// Message is the JSON protocol message shared with the server
+type Message = structs.Message
+
+// stdin is read by the command loop, or by Pipeline for a script
+var stdin = bufio.NewReader(os.Stdin)
 
 func main() {
 	// CLI flags
-	username, addr := getClientInfo()
+	username, addr, socket, jsonOnly := getClientInfo()
 
 	// Connect to server
-	conn := getConnection(addr)
+	conn := getConnection(addr, socket)
 	defer conn.Close()
 	enc := json.NewEncoder(conn)
 	dec := json.NewDecoder(conn)
@@ -40,24 +37,62 @@ func main() {
 		return
 	}
 
-	// handle command mode
-	CommandLoop(enc, dec, msg)
+	// switch to the binary framing unless JSON was asked for
+	var cenc codec.Encoder = codec.NewJSONEncoder(enc)
+	var cdec codec.Decoder = codec.NewJSONDecoder(dec)
+	if !*jsonOnly {
+		var err error
+		if cenc, cdec, err = negotiate(conn, cenc, cdec); err != nil {
+			fmt.Println("Connection closed by server!")
+			return
+		}
+	}
+
+	// handle command mode; a script or pipe is sent without waiting for each reply
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		CommandLoop(cenc, cdec, msg)
+	} else {
+		Pipeline(cenc, cdec)
+	}
 }
 
-func getClientInfo() (*string, *string) {
+func getClientInfo() (*string, *string, *string, *bool) {
 	uname := flag.String("u", "", "Username for the session")
 	addr := flag.String("addr", "localhost:8080", "Server address")
+	socket := flag.String("socket", "", "Connect to the server's Unix socket instead of -addr")
+	jsonOnly := flag.Bool("json", false, "Keep the JSON protocol instead of binary frames, for debugging")
 	flag.Parse()
 
-	if *uname == "" {
+	// a Unix socket peer may be logged in by its uid
+	if *uname == "" && *socket == "" {
 		fmt.Println("Usage: bytedata -u <username>")
 		os.Exit(1)
 	}
-	return uname, addr
+	return uname, addr, socket, jsonOnly
 }
 
-func getConnection(addr *string) net.Conn {
-	conn, err := net.Dial("tcp", *addr)
+// negotiate asks the server for the binary framing. A server without the
+// handshake answers with an error and the connection stays on JSON.
+func negotiate(conn net.Conn, enc codec.Encoder, dec codec.Decoder) (codec.Encoder, codec.Decoder, error) {
+	if err := enc.Encode(Message{Type: "hello", Version: codec.Version, Capabilities: []string{codec.CapBinary}}); err != nil {
+		return nil, nil, err
+	}
+	var reply Message
+	if err := dec.Decode(&reply); err != nil {
+		return nil, nil, err
+	}
+	if reply.Type != "hello" || !codec.Has(reply.Capabilities, codec.CapBinary) {
+		return enc, dec, nil
+	}
+	return codec.NewBinaryEncoder(conn), codec.NewBinaryDecoder(codec.Continue(dec, conn)), nil
+}
+
+func getConnection(addr, socket *string) net.Conn {
+	network, address := "tcp", *addr
+	if *socket != "" {
+		network, address = "unix", *socket
+	}
+	conn, err := net.Dial(network, address)
 	if err != nil {
 		fmt.Println("Failed to connect:", err)
 		os.Exit(1)
@@ -67,52 +102,83 @@ func getConnection(addr *string) net.Conn {
 }
 
 func handleAuth(username *string, enc *json.Encoder, dec *json.Decoder, msg *Message) bool {
-	if err := dec.Decode(&msg); err != nil {
-		fmt.Println("Connection closed by server!", err)
-		return false
-	}
-	enc.Encode(Message{Type: "auth", Username: *username})
-
-	// Step 3: Read server reply (user exists or error)
-	if err := dec.Decode(&msg); err != nil {
-		fmt.Println("Connection closed by server!")
-		return false
-	}
-	if msg.Type == "error" {
-		fmt.Println(msg.Message)
-		return false
-	}
+	for {
+		if err := dec.Decode(&msg); err != nil {
+			fmt.Println("Connection closed by server!")
+			return false
+		}
 
-	// Step 4: Server asks for password
-	if msg.Type == "info" {
-		fmt.Print(msg.Message)
-		dec.Decode(&msg)
+		switch msg.Type {
+		case "error":
+			fmt.Println(msg.Message)
+			return false
+		case "success":
+			fmt.Println(msg.Message) // Welcome message
+			return true
+		case "loading":
+			// server is up but still replaying buckets
+			fmt.Println("Server is starting up:", msg.Message)
+			return false
+		case "info":
+			fmt.Print(msg.Message)
+		case "request":
+			switch msg.Field {
+			case "username":
+				enc.Encode(Message{Type: "auth", Username: *username})
+			case "password":
+				password, err := readSecret(msg.Message, passwordEnv)
+				if err != nil {
+					fmt.Println(err)
+					return false
+				}
+				enc.Encode(Message{Type: "auth", Username: *username, Password: password})
+			case "token":
+				// first start: server wants the one-time setup token it printed
+				token, err := readSecret(msg.Message, tokenEnv)
+				if err != nil {
+					fmt.Println(err)
+					return false
+				}
+				enc.Encode(Message{Type: "auth", Username: *username, Token: token})
+			}
+		}
 	}
+}
 
-	if msg.Type == "request" && msg.Field == "password" {
-		fmt.Print(msg.Message)
-		passBytes, _ := term.ReadPassword(int(os.Stdin.Fd()))
-		password := strings.TrimSpace(string(passBytes))
-
-		enc.Encode(Message{Type: "auth", Password: password})
+// environment variables a script can pass the secrets in
+const (
+	passwordEnv = "BYTEDATA_PASSWORD"
+	tokenEnv    = "BYTEDATA_SETUP_TOKEN"
+)
 
+// readSecret takes the secret from the environment variable env, or prompts
+// for it on the terminal without echoing. A script piped to stdin is never
+// read for it, so its first line stays a command.
+func readSecret(prompt, env string) (string, error) {
+	if secret, ok := os.LookupEnv(env); ok {
+		return secret, nil
 	}
-	// Step 5: Read authentication result
-	if err := dec.Decode(&msg); err != nil {
-		fmt.Println("Connection closed by server!")
-		return false
+	tty := os.Stdin
+	if !term.IsTerminal(int(tty.Fd())) {
+		var err error
+		if tty, err = os.Open("/dev/tty"); err != nil {
+			return "", fmt.Errorf("no terminal to prompt on, set %s", env)
+		}
+		defer tty.Close()
 	}
-	if msg.Type == "error" {
-		fmt.Println(msg.Message)
-		return false
+	fmt.Print(prompt)
+	secret, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read from the terminal: %v", err)
 	}
-	fmt.Println(msg.Message) // Welcome message
-	return true
+	return strings.TrimSpace(string(secret)), nil
 }
 
-func CommandLoop(enc *json.Encoder, dec *json.Decoder, msg Message) {
-	reader := bufio.NewReader(os.Stdin)
+func CommandLoop(enc codec.Encoder, dec codec.Decoder, msg Message) {
+	reader := stdin
 	active := ""
+	var id uint64
 	for {
 		fmt.Print("ByteData> " + active)
 		line, _ := reader.ReadString('\n')
@@ -120,24 +186,66 @@ func CommandLoop(enc *json.Encoder, dec *json.Decoder, msg Message) {
 		if line == ""{
 			continue
 		}
+		// \export and \import work on local files
+		if strings.HasPrefix(line, "\\") {
+			if err := localCommand(line, enc, dec); err != nil {
+				if err == errConnection {
+					fmt.Println("Exiting...")
+					return
+				}
+				fmt.Println("ByteData> " + active + err.Error())
+			}
+			continue
+		}
 		// Send command
-		enc.Encode(Message{Type: "command", Command: line})
+		id++
+		enc.Encode(Message{Type: "command", Command: line, ID: id})
 
 		// Read server response
-		if err := dec.Decode(&msg); err != nil {
+		if err := readResponse(dec, &msg); err != nil {
 			fmt.Println("Exiting...")
 			return
 		}
 		
-		active = msg.Bucket
-		if active != "" {
-			active = "["+active+"]:"
+		if msg.Type == "shutdown" {
+			fmt.Println("ByteData> " + msg.Message)
+			return
 		}
 
-		if msg.Type == "error" {
-			fmt.Println("ByteData> " + active + msg.Message)
-		} else {
-			fmt.Println("ByteData> " + active + strings.Join(msg.Data, "\n"))
+		active = printReply(msg)
+	}
+}
+
+// printReply prints the reply to a command and returns the prompt prefix of
+// the bucket it left the session in.
+func printReply(msg Message) string {
+	active := msg.Bucket
+	if active != "" {
+		active = "["+active+"]:"
+	}
+
+	if msg.Type == "error" {
+		fmt.Println("ByteData> " + active + msg.Message)
+	} else {
+		fmt.Println("ByteData> " + active + strings.Join(msg.Data, "\n"))
+	}
+	return active
+}
+
+// readResponse reads the reply to a request, printing progress messages the
+// server sends while it works on it.
+func readResponse(dec codec.Decoder, msg *Message) error {
+	for {
+		*msg = Message{}
+		if err := dec.Decode(msg); err != nil {
+			return err
+		}
+		if err := msg.DecodeBinary(); err != nil {
+			return err
+		}
+		if msg.Type != "progress" {
+			return nil
 		}
+		fmt.Println("ByteData> " + msg.Message)
 	}
 }
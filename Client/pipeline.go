@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"byted/DB_engine/codec"
+)
+
+// pipelineWindow is how many commands of a script may await their reply.
+const pipelineWindow = 512
+
+// Pipeline sends the commands of a script or pipe without waiting for each
+// reply. Every command carries an ID the server echoes back, so the replies,
+// which may arrive out of order, are printed in the order of the script.
+// \export and \import lines wait for everything before them.
+func Pipeline(enc codec.Encoder, dec codec.Decoder) {
+	var nextID uint64
+	for {
+		sent := make(chan uint64, pipelineWindow)
+		window := make(chan struct{}, pipelineWindow)
+		local := make(chan string, 1) // the \ line that stopped the sender, "" at the end of input
+
+		go func() {
+			defer close(sent)
+			for {
+				line, err := stdin.ReadString('\n')
+				line = strings.TrimSpace(line)
+				if strings.HasPrefix(line, "\\") {
+					local <- line
+					return
+				}
+				if line != "" {
+					window <- struct{}{}
+					nextID++
+					if enc.Encode(Message{Type: "command", Command: line, ID: nextID}) != nil {
+						local <- ""
+						return
+					}
+					sent <- nextID
+				}
+				if err != nil {
+					local <- ""
+					return
+				}
+			}
+		}()
+
+		if !collectReplies(dec, sent, window) {
+			fmt.Println("Exiting...")
+			return
+		}
+		line := <-local
+		if line == "" {
+			return
+		}
+		if err := localCommand(line, enc, dec); err != nil {
+			if err == errConnection {
+				fmt.Println("Exiting...")
+				return
+			}
+			fmt.Println("ByteData> " + err.Error())
+		}
+	}
+}
+
+// collectReplies prints the reply to every ID read from sent, in that order,
+// and frees a window slot for each. It is false once the connection is gone.
+func collectReplies(dec codec.Decoder, sent <-chan uint64, window <-chan struct{}) bool {
+	early := make(map[uint64]Message) // replies that overtook an earlier command
+	for id := range sent {
+		msg, ok := early[id]
+		delete(early, id)
+		for !ok {
+			if err := readResponse(dec, &msg); err != nil {
+				return false
+			}
+			if msg.Type == "shutdown" {
+				fmt.Println("ByteData> " + msg.Message)
+				return false
+			}
+			if msg.ID == id {
+				ok = true
+			} else {
+				early[msg.ID] = msg
+			}
+		}
+		printReply(msg)
+		<-window
+	}
+	return true
+}
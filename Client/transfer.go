@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"byted/DB_engine/codec"
+	"byted/DB_engine/core/transfer"
+)
+
+// errConnection means the server went away mid-request.
+var errConnection = errors.New("connection closed by server")
+
+const (
+	exportUsage = `usage: \export <bucket_name> <file> [format=jsonl|csv] [range <start> <end>]`
+	importUsage = `usage: \import <bucket_name> <file> [format=jsonl|csv] [batch=<n>]`
+)
+
+// localCommand runs the client-side commands, which read and write files on
+// this machine rather than on the server.
+func localCommand(line string, enc codec.Encoder, dec codec.Decoder) error {
+	parts := strings.Fields(line)
+	switch parts[0] {
+	case `\export`:
+		return exportLocal(parts, enc, dec)
+	case `\import`:
+		return importLocal(parts, enc, dec)
+	}
+	return fmt.Errorf(`unknown client command '%s', available: \export, \import`, parts[0])
+}
+
+// \export <bucket_name> <file> [format=jsonl|csv] [range <start> <end>]
+func exportLocal(parts []string, enc codec.Encoder, dec codec.Decoder) error {
+	if len(parts) < 3 {
+		return errors.New(exportUsage)
+	}
+	format, rangeArgs := "", []string(nil)
+	for i := 3; i < len(parts); i++ {
+		switch {
+		case strings.HasPrefix(parts[i], "format="):
+			format = strings.TrimPrefix(parts[i], "format=")
+		case parts[i] == "range" && i+2 < len(parts):
+			rangeArgs = parts[i+1 : i+3]
+			i += 2
+		default:
+			return errors.New(exportUsage)
+		}
+	}
+	format, err := transfer.ParseFormat(format, parts[2])
+	if err != nil {
+		return err
+	}
+
+	tmp := parts[2] + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	summary, err := receiveExport(f, format, parts[1], rangeArgs, enc, dec)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, parts[2])
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	fmt.Printf("ByteData> Exported %s from '%s' to %s\n", summary, parts[1], parts[2])
+	return nil
+}
+
+func receiveExport(f *os.File, format, bucket string, rangeArgs []string, enc codec.Encoder, dec codec.Decoder) (string, error) {
+	w, err := transfer.NewWriter(f, format)
+	if err != nil {
+		return "", err
+	}
+	if err := enc.Encode(Message{Type: "export", Bucket: bucket, Data: rangeArgs}); err != nil {
+		return "", errConnection
+	}
+
+	received := 0
+	for {
+		var msg Message
+		if err := readResponse(dec, &msg); err != nil {
+			return "", errConnection
+		}
+		switch msg.Type {
+		case "rows":
+			for _, line := range msg.Data {
+				rec, err := transfer.UnmarshalLine(line)
+				if err != nil {
+					return "", fmt.Errorf("invalid row from server: %v", err)
+				}
+				if err := w.Write(rec); err != nil {
+					return "", err
+				}
+			}
+			received += len(msg.Data)
+			fmt.Printf("\rByteData> received %d key(s)", received)
+		case "success":
+			if received > 0 {
+				fmt.Println()
+			}
+			return msg.Message, w.Flush()
+		default:
+			if received > 0 {
+				fmt.Println()
+			}
+			return "", errors.New(msg.Message)
+		}
+	}
+}
+
+// \import <bucket_name> <file> [format=jsonl|csv] [batch=<n>]
+func importLocal(parts []string, enc codec.Encoder, dec codec.Decoder) error {
+	if len(parts) < 3 {
+		return errors.New(importUsage)
+	}
+	format, batchSize := "", transfer.DefaultBatchSize
+	for _, arg := range parts[3:] {
+		switch {
+		case strings.HasPrefix(arg, "format="):
+			format = strings.TrimPrefix(arg, "format=")
+		case strings.HasPrefix(arg, "batch="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "batch="))
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid batch size '%s'", strings.TrimPrefix(arg, "batch="))
+			}
+			batchSize = n
+		default:
+			return errors.New(importUsage)
+		}
+	}
+	format, err := transfer.ParseFormat(format, parts[2])
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(parts[2])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := transfer.NewReader(f, format)
+	if err != nil {
+		return err
+	}
+
+	// every batch goes out as one message and is applied atomically
+	apply := func(records []transfer.Record) (uint64, error) {
+		lines := make([]string, len(records))
+		for i, rec := range records {
+			var err error
+			if lines[i], err = transfer.MarshalLine(rec); err != nil {
+				return 0, err
+			}
+		}
+		if err := enc.Encode(Message{Type: "import", Bucket: parts[1], Data: lines}); err != nil {
+			return 0, errConnection
+		}
+		var msg Message
+		if err := readResponse(dec, &msg); err != nil {
+			return 0, errConnection
+		}
+		if msg.Type != "success" {
+			return 0, errors.New(msg.Message)
+		}
+		var lsn uint64
+		fmt.Sscanf(msg.Message, "LSN %d", &lsn)
+		return lsn, nil
+	}
+	progress := func(p transfer.Progress) {
+		fmt.Printf("\rByteData> imported %d key(s) in %d batch(es)", p.Records, p.Batches)
+	}
+
+	p, err := transfer.Import(r, batchSize, apply, progress)
+	if p.Batches > 0 {
+		fmt.Println()
+	}
+	if err != nil {
+		if errors.Is(err, errConnection) {
+			return errConnection
+		}
+		return fmt.Errorf("import failed after %d key(s): %v", p.Records, err)
+	}
+	line := fmt.Sprintf("ByteData> Imported %d key(s) into '%s', last LSN %d", p.Records, parts[1], p.LSN)
+	if p.Skipped > 0 {
+		line += fmt.Sprintf(", skipped %d expired key(s)", p.Skipped)
+	}
+	fmt.Println(line)
+	return nil
+}
@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"byted/DB_engine/config"
+	"byted/DB_engine/core/audit"
+	"byted/DB_engine/core/backup"
+	"byted/DB_engine/core/bucket"
+	"byted/DB_engine/structs"
+	"errors"
+	"fmt"
+)
+
+// executeAdmin runs the bucket administration, audit and config operations.
+func executeAdmin(session *bucket.Session, req structs.Request) (*structs.Result, error) {
+	switch req.Op {
+	case structs.OpTrash:
+		entries, err := session.ListTrash()
+		if err != nil {
+			return nil, err
+		}
+		return &structs.Result{Trash: trashEntries(session, entries)}, nil
+
+	case structs.OpPurge:
+		purged, err := session.PurgeTrash(req.Bucket, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &structs.Result{Trash: trashEntries(session, purged)}, nil
+
+	case structs.OpCatalog:
+		added, err := session.RebuildCatalog()
+		if err != nil {
+			return nil, err
+		}
+		res := &structs.Result{Buckets: make([]structs.BucketInfo, len(added))}
+		for i, name := range added {
+			res.Buckets[i] = structs.BucketInfo{Name: name}
+		}
+		return res, nil
+
+	case structs.OpBackup:
+		return backupBuckets(session, req)
+
+	case structs.OpAuditTail, structs.OpAuditStatus:
+		logger := audit.Default()
+		if logger == nil {
+			return nil, errors.New("audit log is not enabled on this server")
+		}
+		if req.Op == structs.OpAuditStatus {
+			return &structs.Result{Log: logger.Path(), Off: logger.Off()}, nil
+		}
+		n := req.Limit
+		if n < 0 {
+			return nil, invalid("audit_tail needs a limit of at least 1")
+		}
+		if n == 0 {
+			n = 20
+		}
+		lines, err := logger.Tail(n)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %v", err)
+		}
+		return &structs.Result{Lines: lines}, nil
+
+	case structs.OpConfig:
+		cfg := config.Current()
+		if cfg == nil {
+			return nil, errors.New("no configuration loaded")
+		}
+		names := config.Names()
+		if req.Setting != "" {
+			names = []string{req.Setting}
+		}
+		res := &structs.Result{Settings: make([]structs.Setting, len(names))}
+		for i, name := range names {
+			value, source, err := cfg.Get(name)
+			if err != nil {
+				return nil, invalid("%v", err)
+			}
+			res.Settings[i] = structs.Setting{Name: name, Value: value, Source: source}
+		}
+		return res, nil
+	}
+
+	// the rest work on one bucket
+	if req.Bucket == "" {
+		return nil, invalid("%s needs a bucket", req.Op)
+	}
+	switch req.Op {
+	case structs.OpRename:
+		if req.Target == "" {
+			return nil, invalid("rename needs a target")
+		}
+		return done(session.RenameBucket(req.Bucket, req.Target))
+
+	case structs.OpClone:
+		if req.Target == "" {
+			return nil, invalid("clone needs a target")
+		}
+		lsn, count, err := session.CloneBucket(req.Bucket, req.Target, req.AsOf)
+		if err != nil {
+			return nil, err
+		}
+		return &structs.Result{LSN: lsn, Count: count}, nil
+
+	case structs.OpAlter:
+		if len(req.Options) == 0 {
+			return nil, invalid("alter needs options")
+		}
+		opts, err := session.AlterBucket(req.Bucket, req.Options)
+		if err != nil {
+			return nil, err
+		}
+		return &structs.Result{Options: opts.Pairs()}, nil
+
+	case structs.OpFreeze:
+		return done(session.FreezeBucket(req.Bucket, req.Chmod))
+
+	case structs.OpUnfreeze:
+		return done(session.UnfreezeBucket(req.Bucket))
+
+	case structs.OpPin, structs.OpUnpin:
+		return done(session.PinBucket(req.Bucket, req.Op == structs.OpPin))
+
+	default: // structs.OpUndrop
+		return done(session.Undrop(req.Bucket))
+	}
+}
+
+// done is the empty result of an operation that returns only an error.
+func done(err error) (*structs.Result, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &structs.Result{}, nil
+}
+
+// trashEntries is what a trash or purge result shows of the entries.
+func trashEntries(session *bucket.Session, entries []bucket.TrashEntry) []structs.TrashEntry {
+	out := make([]structs.TrashEntry, len(entries))
+	for i, entry := range entries {
+		out[i] = structs.TrashEntry{Name: entry.Name, DroppedAt: entry.DroppedAt.UnixMilli()}
+		if retention := session.TrashRetention; retention > 0 {
+			out[i].PurgeAt = entry.DroppedAt.Add(retention).UnixMilli()
+		}
+	}
+	return out
+}
+
+// backupBuckets writes a backup to req.Path, incremental on req.Base when set;
+// both are directories inside backup_dir.
+func backupBuckets(session *bucket.Session, req structs.Request) (*structs.Result, error) {
+	if req.Path == "" {
+		return nil, invalid("backup needs a path")
+	}
+	cfg := serverConfig()
+	dest, err := confinedPath(cfg.BackupsDir(), "backup", req.Path)
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	br := backup.Request{Dest: dest}
+	if req.Base != "" {
+		if br.Base, err = confinedPath(cfg.BackupsDir(), "backup", req.Base); err != nil {
+			return nil, invalid("%v", err)
+		}
+	}
+	if config.Current() != nil {
+		br.AuthFile = cfg.AuthFile
+	}
+
+	if br.Catalog, br.Buckets, err = session.BackupSources(); err != nil {
+		return nil, err
+	}
+	manifest, err := backup.Create(br)
+	if err != nil {
+		return nil, fmt.Errorf("backup failed: %v", err)
+	}
+
+	res := &structs.Result{Base: req.Base, Buckets: make([]structs.BucketInfo, len(manifest.Buckets))}
+	for i, b := range manifest.Buckets {
+		res.Buckets[i] = structs.BucketInfo{Name: b.Name, LSN: b.LSN, Bytes: b.End - b.Start}
+	}
+	return res, nil
+}
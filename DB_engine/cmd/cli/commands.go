@@ -2,106 +2,126 @@ package cli
 
 import (
 	"byted/DB_engine/core/bucket"
+	"byted/DB_engine/core/kv"
+	"byted/DB_engine/structs"
 	"errors"
 	"fmt"
 	"strings"
 )
 
-func ExecuteCommand(input string, bucket *bucket.Bucket, bm * bucket.BucketManager) ([]string, error) {
+// ExecuteCommand runs a command against the active bucket. lsn is the LSN of
+// the write the command made, 0 when it made none.
+func ExecuteCommand(input string, bucket *bucket.Bucket, bm *bucket.Session) (data []string, lsn uint64, err error) {
 
 	fmt.Printf("Bytedata: [%s]> ", bucket.Name)
 	input = strings.TrimSpace(input)
 	if input == "" {
-		return nil, nil
+		return nil, 0, nil
 	}
 
-	parts := strings.Fields(input)
+	args, err := tokenize(input)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(args) == 0 {
+		return nil, 0, nil
+	}
+	parts := texts(args)
 	command := parts[0]
 
 	switch command {
 	case "put":
-		return handlePut(parts, bucket)
+		return handlePut(args, bucket, bm)
 	case "get":
-		return handleGet(parts, bucket)
+		data, err = handleGet(args, bucket, bm)
 	case "del", "delete":
-		return handleDelete(parts, bucket)
+		return handleDelete(args, bucket, bm)
 	case "range":
-		return handleRange(parts, bucket)
+		data, err = handleRange(parts, bucket, bm)
+	case "describe":
+		data, err = describeBucket(bucket)
+	case "stats":
+		data = bucketStats(bucket)
 	case "exit", "quit":
-		return handleExitForBucket(bm)
+		data, err = handleExitForBucket(bm)
 	case "help":
-		return handleHelp(1)
+		data, err = handleHelp(1)
 	default:
-		return handleUnknown(command)
+		data, err = handleUnknown(command)
 	}
-	return nil, nil
-
+	return data, 0, err
 }
 
-func handlePut(parts []string, bucket *bucket.Bucket) ([]string, error) {
-	if len(parts) < 3 {
-		return nil, errors.New("usage: put <key> <value>")
-	}
+// The data commands are text over the structured requests of execute.go.
 
-	key := parts[1]
-	value := strings.Join(parts[2:], " ")
+func handlePut(args []arg, bucket *bucket.Bucket, bm *bucket.Session) ([]string, uint64, error) {
+	parts, err := decodeArgs(args)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(parts) != 3 {
+		return nil, 0, errors.New("usage: put <key> <value>, quote a value with spaces: put k \"a  b\"")
+	}
 
-	lsn, err := bucket.KvEngine.Put([]byte(key), []byte(value))
+	res, err := execute(bm, bucket, structs.Request{Op: structs.OpPut, Key: []byte(parts[1]), Value: []byte(parts[2])})
 	if err != nil {
-		return nil, fmt.Errorf("put failed: %v", err)
+		return nil, 0, fmt.Errorf("put failed: %v", err)
 	}
 
-	return []string{fmt.Sprint("Put successful. LSN: %d\n", lsn)}, nil
+	return []string{fmt.Sprintf("Put successful. LSN: %d\n", res.LSN)}, res.LSN, nil
 }
 
-func handleGet(parts []string, bucket *bucket.Bucket) ([]string, error) {
+func handleGet(args []arg, bucket *bucket.Bucket, bm *bucket.Session) ([]string, error) {
+	parts, err := decodeArgs(args)
+	if err != nil {
+		return nil, err
+	}
 	if len(parts) != 2 {
 		return nil, errors.New("usage: get <key>")
 	}
 
-	key := parts[1]
-	value, err := bucket.KvEngine.Get([]byte(key))
+	res, err := execute(bm, bucket, structs.Request{Op: structs.OpGet, Key: []byte(parts[1])})
 	if err != nil {
 		return nil, fmt.Errorf("get failed: %v", err)
 	}
 
-	if value == nil {
-		return nil, fmt.Errorf("Key not found")
-	} 
-
-	return []string{fmt.Sprintf("Value: %s\n", string(value))}, nil
+	return []string{fmt.Sprintf("Value: %s\n", string(res.Value))}, nil
 }
 
-func handleDelete(parts []string, bucket *bucket.Bucket) ([]string, error) {
+func handleDelete(args []arg, bucket *bucket.Bucket, bm *bucket.Session) ([]string, uint64, error) {
+	parts, err := decodeArgs(args)
+	if err != nil {
+		return nil, 0, err
+	}
 	if len(parts) != 2 {
-		return nil, errors.New("usage: del <key>")
+		return nil, 0, errors.New("usage: del <key>")
 	}
 
-	key := parts[1]
-	lsn, err := bucket.KvEngine.Delete([]byte(key))
+	res, err := execute(bm, bucket, structs.Request{Op: structs.OpDelete, Key: []byte(parts[1])})
 	if err != nil {
-		return nil, fmt.Errorf("delete failed: %v", err)
+		return nil, 0, fmt.Errorf("delete failed: %v", err)
 	}
 
-	return []string{ fmt.Sprintf("Delete successful. LSN: %d\n", lsn)}, nil
+	return []string{ fmt.Sprintf("Delete successful. LSN: %d\n", res.LSN)}, res.LSN, nil
 }
 
-func handleRange(parts []string, bucket *bucket.Bucket) ([]string, error) {
+func handleRange(parts []string, bucket *bucket.Bucket, bm *bucket.Session) ([]string, error) {
 	if len(parts) != 3 {
 		return nil, errors.New("usage: range <startKey> <endKey>")
 	}
 
-	startKey := parts[1]
-	endKey := parts[2]
-	results := bucket.KvEngine.Range([]byte(startKey), []byte(endKey))
+	res, err := execute(bm, bucket, structs.Request{Op: structs.OpRange, Key: []byte(parts[1]), End: []byte(parts[2])})
+	if err != nil {
+		return nil, fmt.Errorf("range failed: %v", err)
+	}
 
-	if len(results) == 0 {
+	if len(res.Pairs) == 0 {
 		return nil, errors.New("no keys found in the specified range")
 	}
 
 	// Build a slice of strings to return
-	response := []string{fmt.Sprintf("Found %d key(s):", len(results))}
-	for _, v := range results {
+	response := []string{fmt.Sprintf("Found %d key(s):", len(res.Pairs))}
+	for _, v := range res.Pairs {
 		response = append(response, fmt.Sprintf("  Key: %s, Value: %s", v.Key, v.Value))
 	}
 
@@ -109,6 +129,15 @@ func handleRange(parts []string, bucket *bucket.Bucket) ([]string, error) {
 }
 
 
+// frozenError tells the user how to get write access back.
+func frozenError(err error, bucket *bucket.Bucket) error {
+	if errors.Is(err, kv.ErrReadOnly) {
+		return &OpError{Code: structs.CodeReadOnly,
+			Err: fmt.Errorf("bucket '%s' is frozen, run 'unfreeze %s' to allow writes", bucket.Name, bucket.Name)}
+	}
+	return err
+}
+
 func handleUnknown(command string) ([]string, error) {
 	return nil, fmt.Errorf("unknown command: '%s'. Type 'help' for available commands", command)
 }
@@ -116,15 +145,21 @@ func handleUnknown(command string) ([]string, error) {
 func printHelpBucket() ([]string, error) {
 	help := []string{`Available commands:
   put <key> <value>    - Add or update a key-value pair
+                         "quote" or 'quote' keys and values with spaces,
+                         \n \t \xHH escapes, hex:... and b64:... for binary
   get <key>            - Retrieve the value for a given key
   del <key>            - Delete a key-value pair
   range <start> <end>  - Retrieve all key-value pairs in the specified key range
+  describe             - Show the options of this bucket
+  stats                - Show the size and activity of this bucket
   exit                 - Exit the CLI
   help                 - Show this help message`}
 	return help, nil
 }
 
-func handleExitForBucket(bm * bucket.BucketManager) ([]string, error) {
-	bm.ExitBucket()
+func handleExitForBucket(bm *bucket.Session) ([]string, error) {
+	if _, err := execute(bm, nil, structs.Request{Op: structs.OpExit}); err != nil {
+		return nil, err
+	}
 	return []string{fmt.Sprintf("Exiting.")}, nil
 }
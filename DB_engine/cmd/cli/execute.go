@@ -0,0 +1,289 @@
+package cli
+
+import (
+	"byted/DB_engine/core/btree"
+	"byted/DB_engine/core/bucket"
+	"byted/DB_engine/core/kv"
+	"byted/DB_engine/structs"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// OpError is a failed request with its protocol error code.
+type OpError struct {
+	Code string
+	Err  error
+}
+
+func (e *OpError) Error() string { return e.Err.Error() }
+func (e *OpError) Unwrap() error { return e.Err }
+
+func invalid(format string, args ...any) error {
+	return &OpError{Code: structs.CodeInvalid, Err: fmt.Errorf(format, args...)}
+}
+
+// ErrorCode is the protocol error code for err.
+func ErrorCode(err error) string {
+	var opErr *OpError
+	switch {
+	case errors.As(err, &opErr):
+		return opErr.Code
+	case errors.Is(err, kv.ErrNotFound):
+		return structs.CodeNotFound
+	case errors.Is(err, kv.ErrReadOnly):
+		return structs.CodeReadOnly
+	case errors.Is(err, kv.ErrTooLarge):
+		return structs.CodeTooLarge
+	case errors.Is(err, kv.ErrConditionFailed):
+		return structs.CodeCondition
+	case errors.Is(err, kv.ErrNotNumber):
+		return structs.CodeInvalid
+	case errors.Is(err, bucket.ErrNoSuchBucket):
+		return structs.CodeNoSuchBucket
+	case errors.Is(err, bucket.ErrBucketExists):
+		return structs.CodeExists
+	case errors.Is(err, bucket.ErrUnavailable):
+		return structs.CodeUnavailable
+	case errors.Is(err, bucket.ErrInvalidName):
+		return structs.CodeInvalid
+	}
+	return structs.CodeInternal
+}
+
+// Execute runs a structured request in the session.
+func Execute(session *bucket.Session, req structs.Request) (*structs.Result, error) {
+	return execute(session, nil, req)
+}
+
+// execute runs req; data operations without a bucket go to active, or to the
+// session's active bucket when active is nil.
+func execute(session *bucket.Session, active *bucket.Bucket, req structs.Request) (*structs.Result, error) {
+	switch req.Op {
+	case structs.OpList:
+		return listBuckets(session), nil
+
+	case structs.OpUse:
+		if req.Bucket == "" {
+			return nil, invalid("use needs a bucket")
+		}
+		if _, err := session.UseBucket(req.Bucket); err != nil {
+			return nil, err
+		}
+		return &structs.Result{}, nil
+
+	case structs.OpExit:
+		if err := session.ExitBucket(); err != nil {
+			return nil, err
+		}
+		return &structs.Result{}, nil
+
+	case structs.OpCreate:
+		if req.Bucket == "" {
+			return nil, invalid("create needs a bucket")
+		}
+		opts := session.Defaults
+		if len(req.Options) > 0 {
+			var err error
+			if opts, err = bucket.ParseOptions(opts, req.Options); err != nil {
+				return nil, invalid("%v", err)
+			}
+		}
+		if err := session.CreateBucket(req.Bucket, opts); err != nil {
+			return nil, err
+		}
+		return &structs.Result{}, nil
+
+	case structs.OpDrop:
+		if req.Bucket == "" {
+			return nil, invalid("drop needs a bucket")
+		}
+		if err := session.DropBucket(req.Bucket); err != nil {
+			return nil, err
+		}
+		if _, err := session.PurgeExpiredTrash(); err != nil {
+			fmt.Println("failed to purge the trash:", err)
+		}
+		return &structs.Result{}, nil
+
+	case structs.OpPut, structs.OpGet, structs.OpDelete, structs.OpRange, structs.OpScan,
+		structs.OpIncr, structs.OpTouch:
+		b, err := targetBucket(session, active, req.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		return executeData(b, req)
+
+	case structs.OpRename, structs.OpClone, structs.OpAlter, structs.OpFreeze, structs.OpUnfreeze,
+		structs.OpPin, structs.OpUnpin, structs.OpUndrop, structs.OpTrash, structs.OpPurge,
+		structs.OpCatalog, structs.OpBackup, structs.OpAuditTail, structs.OpAuditStatus, structs.OpConfig:
+		return executeAdmin(session, req)
+	}
+	return nil, &OpError{Code: structs.CodeUnknownOp, Err: fmt.Errorf("unknown op '%s'", req.Op)}
+}
+
+func targetBucket(session *bucket.Session, active *bucket.Bucket, name string) (*bucket.Bucket, error) {
+	if name != "" {
+		return session.GetBucket(name)
+	}
+	if active != nil {
+		return active, nil
+	}
+	b, err := session.GetActiveBucket()
+	if err != nil {
+		return nil, &OpError{Code: structs.CodeNoBucket, Err: err}
+	}
+	return b, nil
+}
+
+func executeData(b *bucket.Bucket, req structs.Request) (*structs.Result, error) {
+	if len(req.Key) == 0 && req.Op != structs.OpScan {
+		return nil, invalid("%s needs a key", req.Op)
+	}
+	cond, err := condition(req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Flags != 0 && req.Op != structs.OpPut {
+		return nil, invalid("%s takes no flags", req.Op)
+	}
+
+	switch req.Op {
+	case structs.OpPut:
+		var lsn uint64
+		var err error
+		if cond != (kv.Cond{}) || req.Flags != 0 {
+			lsn, err = b.KvEngine.PutIf(req.Key, req.Value, time.Duration(req.TTL)*time.Millisecond, req.Flags, cond)
+		} else if req.TTL > 0 {
+			lsn, err = b.KvEngine.PutWithTTL(req.Key, req.Value, time.Duration(req.TTL)*time.Millisecond)
+		} else {
+			lsn, err = b.KvEngine.Put(req.Key, req.Value)
+		}
+		if err != nil {
+			return nil, frozenError(err, b)
+		}
+		return &structs.Result{LSN: lsn}, nil
+
+	case structs.OpGet:
+		item, err := b.KvEngine.GetItem(req.Key)
+		if err != nil {
+			return nil, err
+		}
+		return &structs.Result{Value: item.Value, LSN: item.LSN, Flags: item.Flags}, nil
+
+	case structs.OpDelete:
+		var lsn uint64
+		var err error
+		if cond != (kv.Cond{}) {
+			lsn, err = b.KvEngine.DeleteIf(req.Key, cond)
+		} else {
+			lsn, err = b.KvEngine.Delete(req.Key)
+		}
+		if err != nil {
+			return nil, frozenError(err, b)
+		}
+		return &structs.Result{LSN: lsn}, nil
+
+	case structs.OpIncr:
+		value, lsn, err := b.KvEngine.Incr(req.Key, req.Delta, req.Decr)
+		if err != nil {
+			return nil, frozenError(err, b)
+		}
+		return &structs.Result{Value: value, LSN: lsn}, nil
+
+	case structs.OpTouch:
+		if req.TTL < 0 {
+			return nil, invalid("touch needs a TTL of at least 0")
+		}
+		lsn, err := b.KvEngine.Touch(req.Key, time.Duration(req.TTL)*time.Millisecond)
+		if err != nil {
+			return nil, frozenError(err, b)
+		}
+		return &structs.Result{LSN: lsn}, nil
+
+	case structs.OpScan:
+		if req.Limit < 1 {
+			return nil, invalid("scan needs a limit of at least 1")
+		}
+		pairs, next, err := b.KvEngine.Scan(req.Key, req.Limit)
+		if err != nil {
+			return nil, err
+		}
+		res := &structs.Result{Pairs: resultPairs(pairs), Next: next}
+		return res, nil
+
+	default: // structs.OpRange
+		if len(req.End) == 0 {
+			return nil, invalid("range needs an end key")
+		}
+		pairs, err := b.KvEngine.Range(req.Key, req.End)
+		if err != nil {
+			return nil, err
+		}
+		return &structs.Result{Pairs: resultPairs(pairs)}, nil
+	}
+}
+
+// condition is the kv.Cond of a put or delete.
+func condition(req structs.Request) (kv.Cond, error) {
+	var cond kv.Cond
+	if req.Cond == "" && req.IfLSN == 0 {
+		return cond, nil
+	}
+	if req.Op != structs.OpPut && req.Op != structs.OpDelete {
+		return cond, invalid("%s takes no condition", req.Op)
+	}
+	switch req.Cond {
+	case "":
+	case structs.CondExists:
+		cond.Exists = true
+	case structs.CondAbsent:
+		if req.Op != structs.OpPut || req.IfLSN != 0 {
+			return cond, invalid("condition '%s' only applies to put without if_lsn", req.Cond)
+		}
+		cond.Absent = true
+	default:
+		return cond, invalid("unknown condition '%s'", req.Cond)
+	}
+	cond.LSN = req.IfLSN
+	return cond, nil
+}
+
+func resultPairs(pairs []btree.KVPair) []structs.KVPair {
+	out := make([]structs.KVPair, len(pairs))
+	for i, p := range pairs {
+		value, _ := p.Value.([]byte)
+		out[i] = structs.KVPair{Key: []byte(p.Key), Value: value}
+	}
+	return out
+}
+
+func listBuckets(session *bucket.Session) *structs.Result {
+	names := session.ListBuckets("")
+	sort.Strings(names)
+
+	res := &structs.Result{Buckets: make([]structs.BucketInfo, 0, len(names))}
+	for _, name := range names {
+		b, err := session.GetBucket(name)
+		if err != nil {
+			continue // dropped meanwhile
+		}
+		res.Buckets = append(res.Buckets, structs.BucketInfo{
+			Name:     name,
+			Loaded:   b.Loaded(),
+			Pinned:   b.Options.Pinned,
+			ReadOnly: b.Options.ReadOnly,
+		})
+	}
+	failed := session.Unavailable()
+	names = names[:0]
+	for name := range failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		res.Buckets = append(res.Buckets, structs.BucketInfo{Name: name, Unavailable: failed[name]})
+	}
+	return res
+}
@@ -1,22 +1,33 @@
 package cli
 
 import (
-	"byted/DB_engine/constants"
+	"byted/DB_engine/config"
+	"byted/DB_engine/core/audit"
 	"byted/DB_engine/core/bucket"
+	"byted/DB_engine/structs"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
+	"time"
 )
 
 
-func ExecuteGlobalCommmand(input string, bucketManager *bucket.BucketManager, conn net.Conn) ([]string, error) {
+func ExecuteGlobalCommmand(input string, bucketManager *bucket.Session, conn net.Conn) ([]string, error) {
 	// Parse the command line input
 
 	input = strings.TrimSpace(input)
 	if input == "" {
 		return nil, nil
 	}
-	parts := strings.Fields(input)
+	parts, err := Tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
 	command := parts[0]
 
 	switch command {
@@ -27,7 +38,13 @@ func ExecuteGlobalCommmand(input string, bucketManager *bucket.BucketManager, co
 		return handleHelp(0)
 
 	case "list":
-		return handleListBuckets("", bucketManager)
+		if len(parts) == 2 && parts[1] == "-v" {
+			return bucketSummaries(bucketManager, false), nil
+		}
+		return handleListBuckets(bucketManager)
+
+	case "stats":
+		return handleStats(parts, bucketManager)
 
 	case "use":
 		return handleUseBucket(parts, bucketManager)
@@ -38,7 +55,51 @@ func ExecuteGlobalCommmand(input string, bucketManager *bucket.BucketManager, co
 	case "drop":
 		return handleDropBucket(parts, bucketManager)
 
-	
+	case "alter":
+		return handleAlterBucket(parts, bucketManager)
+
+	case "describe":
+		return handleDescribeBucket(parts, bucketManager)
+
+	case "catalog":
+		return handleCatalog(parts, bucketManager)
+
+	case "undrop":
+		return handleUndropBucket(parts, bucketManager)
+
+	case "trash":
+		return handleTrash(parts, bucketManager)
+
+	case "rename":
+		return handleRenameBucket(parts, bucketManager)
+
+	case "clone", "copy":
+		return handleCloneBucket(parts, bucketManager)
+
+	case "pin", "unpin":
+		return handlePinBucket(parts, bucketManager)
+
+	case "freeze":
+		return handleFreezeBucket(parts, bucketManager)
+
+	case "unfreeze":
+		return handleUnfreezeBucket(parts, bucketManager)
+
+	case "backup":
+		return handleBackup(parts, bucketManager)
+
+	case "export":
+		return handleExport(parts, bucketManager)
+
+	case "import":
+		return handleImport(parts, bucketManager)
+
+	case "audit":
+		return handleAudit(parts, bucketManager)
+
+	case "config":
+		return handleConfig(parts, bucketManager)
+
 
 	default:
 		return handleHelp(0)
@@ -46,46 +107,372 @@ func ExecuteGlobalCommmand(input string, bucketManager *bucket.BucketManager, co
 	}
 }
 
-func handleListBuckets(input string, bucketManager *bucket.BucketManager) ([]string, error) {
-	return bucketManager.ListBuckets(input), nil
+// list shows every bucket and whether it is loaded; unloaded buckets load on first use
+func handleListBuckets(bucketManager *bucket.Session) ([]string, error) {
+	res, err := execute(bucketManager, nil, structs.Request{Op: structs.OpList})
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]string, 0, len(res.Buckets))
+	for _, b := range res.Buckets {
+		state := "unloaded"
+		if b.Loaded {
+			state = "loaded"
+		}
+		if b.Pinned {
+			state += ", pinned"
+		}
+		if b.Unavailable != "" {
+			state = "unavailable: " + b.Unavailable
+		}
+		lines = append(lines, fmt.Sprintf("%-20s (%s)", b.Name, state))
+	}
+	return lines, nil
 }
 
-func handleUseBucket(parts []string, bucketManager *bucket.BucketManager) ([]string, error) {
+func handleUseBucket(parts []string, bucketManager *bucket.Session) ([]string, error) {
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("usage: use <bucket_name>")
 	}
 
-	if _, err := bucketManager.UseBucket(parts[1]); err != nil {
+	if _, err := execute(bucketManager, nil, structs.Request{Op: structs.OpUse, Bucket: parts[1]}); err != nil {
 		return nil, err
 	}
 
 	return []string{fmt.Sprintf("Switched to bucket '%s'", parts[1])}, nil
 }
 
-func handleCreateBucket(parts []string, bucketManager *bucket.BucketManager) ([]string, error) {
-	// enc := comm.Enc
-	if len(parts) != 2 {
-		// enc.Encode(structs.Message{Type: "error", Message:"usage: create <bucket_name>"})
-		return nil, fmt.Errorf("usage: create <bucket_name>")
+// create <bucket_name> [with key=value ...]
+func handleCreateBucket(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) < 2 || (len(parts) > 2 && (parts[2] != "with" || len(parts) == 3)) {
+		return nil, fmt.Errorf("usage: create <bucket_name> [with key=value ...]")
+	}
+
+	req := structs.Request{Op: structs.OpCreate, Bucket: parts[1]}
+	if len(parts) > 3 {
+		req.Options = parts[3:]
 	}
-	if err := bucketManager.CreateBucket(parts[1], constants.DEFAULTREEORDER); err != nil {
+	if _, err := execute(bucketManager, nil, req); err != nil {
 		return nil, err
 	}
 	return []string{"Bucket created successfully."}, nil
 }
 
-func handleDropBucket(parts []string, bucketManager *bucket.BucketManager) ([]string, error) {
+// alter bucket <bucket_name> [set] key=value ...
+func handleAlterBucket(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) >= 4 && parts[3] == "set" {
+		parts = append(parts[:3:3], parts[4:]...)
+	}
+	if len(parts) < 4 || parts[1] != "bucket" {
+		return nil, fmt.Errorf("usage: alter bucket <bucket_name> [set] key=value ...")
+	}
+
+	res, err := execute(bucketManager, nil, structs.Request{Op: structs.OpAlter, Bucket: parts[2], Options: parts[3:]})
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{fmt.Sprintf("Bucket '%s' altered:", parts[2])}
+	for _, pair := range res.Options {
+		lines = append(lines, "  "+pair)
+	}
+	return lines, nil
+}
+
+// describe <bucket_name>
+func handleDescribeBucket(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("usage: describe <bucket_name>")
+	}
+	b, err := bucketManager.GetBucket(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return describeBucket(b)
+}
+
+func describeBucket(b *bucket.Bucket) ([]string, error) {
+	lsn, err := b.KvEngine.LastLSN()
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{fmt.Sprintf("Bucket '%s' (last LSN %d)", b.Name, lsn)}
+	return append(lines, b.Options.Describe()...), nil
+}
+
+func handleDropBucket(parts []string, bucketManager *bucket.Session) ([]string, error) {
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("usage: drop <bucket_name>")
 	}
-	if err := bucketManager.DropBucket(parts[1]); err != nil {
+	if _, err := execute(bucketManager, nil, structs.Request{Op: structs.OpDrop, Bucket: parts[1]}); err != nil {
+		return nil, err
+	}
+
+	return []string{fmt.Sprintf("Bucket '%s' moved to the trash, 'undrop %s' restores it.", parts[1], parts[1])}, nil
+}
+
+// undrop <bucket_name>
+func handleUndropBucket(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("usage: undrop <bucket_name>")
+	}
+	if _, err := execute(bucketManager, nil, structs.Request{Op: structs.OpUndrop, Bucket: parts[1]}); err != nil {
+		return nil, err
+	}
+	return []string{fmt.Sprintf("Bucket '%s' restored from the trash.", parts[1])}, nil
+}
+
+// trash list | trash purge [bucket_name]
+func handleTrash(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	switch {
+	case len(parts) == 2 && parts[1] == "list":
+		res, err := execute(bucketManager, nil, structs.Request{Op: structs.OpTrash})
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Trash) == 0 {
+			return []string{"Trash is empty."}, nil
+		}
+		lines := make([]string, 0, len(res.Trash))
+		for _, entry := range res.Trash {
+			line := fmt.Sprintf("%-20s dropped %s", entry.Name, localTime(entry.DroppedAt))
+			if entry.PurgeAt != 0 {
+				line += fmt.Sprintf(", purged after %s", localTime(entry.PurgeAt))
+			}
+			lines = append(lines, line)
+		}
+		return lines, nil
+
+	case (len(parts) == 2 || len(parts) == 3) && parts[1] == "purge":
+		name := ""
+		if len(parts) == 3 {
+			name = parts[2]
+		}
+		res, err := execute(bucketManager, nil, structs.Request{Op: structs.OpPurge, Bucket: name})
+		if err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("Purged %d bucket(s) from the trash.", len(res.Trash))}, nil
+	}
+	return nil, errors.New("usage: trash list | trash purge [bucket_name]")
+}
+
+func localTime(unixMilli int64) string {
+	return time.UnixMilli(unixMilli).Local().Format("2006-01-02 15:04:05")
+}
+
+// rename <old_name> <new_name>
+func handleRenameBucket(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("usage: rename <old_name> <new_name>")
+	}
+	if _, err := execute(bucketManager, nil, structs.Request{Op: structs.OpRename, Bucket: parts[1], Target: parts[2]}); err != nil {
+		return nil, err
+	}
+	return []string{fmt.Sprintf("Bucket '%s' renamed to '%s'.", parts[1], parts[2])}, nil
+}
+
+// clone <source> <target> [at <lsn>]
+func handleCloneBucket(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) != 3 && (len(parts) != 5 || parts[3] != "at") {
+		return nil, fmt.Errorf("usage: clone <source> <target> [at <lsn>]")
+	}
+	var asOf uint64
+	if len(parts) == 5 {
+		var err error
+		if asOf, err = strconv.ParseUint(parts[4], 10, 64); err != nil || asOf == 0 {
+			return nil, fmt.Errorf("invalid LSN '%s'", parts[4])
+		}
+	}
+
+	res, err := execute(bucketManager, nil, structs.Request{Op: structs.OpClone, Bucket: parts[1], Target: parts[2], AsOf: asOf})
+	if err != nil {
 		return nil, err
 	}
+	return []string{fmt.Sprintf("Cloned %d key(s) from '%s' into '%s' as of LSN %d.", res.Count, parts[1], parts[2], res.LSN)}, nil
+}
 
-	return []string{fmt.Sprintf("Bucket '%s' dropped successfully.\n", parts[1])}, nil
+// pin <bucket_name> | unpin <bucket_name>
+func handlePinBucket(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("usage: %s <bucket_name>", parts[0])
+	}
+	pinned := parts[0] == "pin"
+	op := structs.OpUnpin
+	if pinned {
+		op = structs.OpPin
+	}
+	if _, err := execute(bucketManager, nil, structs.Request{Op: op, Bucket: parts[1]}); err != nil {
+		return nil, err
+	}
+	if pinned {
+		return []string{fmt.Sprintf("Bucket '%s' pinned, it stays loaded.", parts[1])}, nil
+	}
+	return []string{fmt.Sprintf("Bucket '%s' unpinned, it is unloaded when idle.", parts[1])}, nil
+}
+
+// freeze <bucket_name> [--chmod]
+func handleFreezeBucket(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "--chmod") {
+		return nil, fmt.Errorf("usage: freeze <bucket_name> [--chmod]")
+	}
+	onDisk := len(parts) == 3
+	if _, err := execute(bucketManager, nil, structs.Request{Op: structs.OpFreeze, Bucket: parts[1], Chmod: onDisk}); err != nil {
+		return nil, err
+	}
+	if onDisk {
+		return []string{fmt.Sprintf("Bucket '%s' frozen, its files are now write-protected.", parts[1])}, nil
+	}
+	return []string{fmt.Sprintf("Bucket '%s' frozen.", parts[1])}, nil
+}
+
+// unfreeze <bucket_name>
+func handleUnfreezeBucket(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("usage: unfreeze <bucket_name>")
+	}
+	if _, err := execute(bucketManager, nil, structs.Request{Op: structs.OpUnfreeze, Bucket: parts[1]}); err != nil {
+		return nil, err
+	}
+	return []string{fmt.Sprintf("Bucket '%s' unfrozen.", parts[1])}, nil
+}
+
+// catalog rebuild - re-register bucket directories missing from the metadata
+func handleCatalog(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) != 2 || parts[1] != "rebuild" {
+		return nil, errors.New("usage: catalog rebuild")
+	}
+	res, err := execute(bucketManager, nil, structs.Request{Op: structs.OpCatalog})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Buckets) == 0 {
+		return []string{"Catalog is complete, nothing to rebuild."}, nil
+	}
+	added := make([]string, len(res.Buckets))
+	for i, b := range res.Buckets {
+		added[i] = b.Name
+	}
+	return []string{fmt.Sprintf("Recovered %d bucket(s): %s", len(added), strings.Join(added, ", "))}, nil
+}
+
+// backup <dir> [incremental <base_dir>]
+// Both directories are on the server, in backup_dir.
+func handleBackup(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) != 2 && (len(parts) != 4 || parts[2] != "incremental") {
+		return nil, errors.New("usage: backup <dir> [incremental <base_dir>]")
+	}
+	req := structs.Request{Op: structs.OpBackup, Path: parts[1]}
+	if len(parts) == 4 {
+		req.Base = parts[3]
+	}
+	res, err := execute(bucketManager, nil, req)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := "Full backup"
+	if res.Base != "" {
+		kind = "Incremental backup on " + res.Base
+	}
+	lines := []string{fmt.Sprintf("%s written to %s:", kind, parts[1])}
+	for _, b := range res.Buckets {
+		lines = append(lines, fmt.Sprintf("  %-20s LSN %-8d %d bytes", b.Name, b.LSN, b.Bytes))
+	}
+	return lines, nil
+}
+
+// handleAudit serves the audit admin commands:
+//
+//	audit tail [n] - last n audit records (default 20)
+//	audit status   - what is currently audited
+//
+// What is audited is set by audit_off in the server config, no session can
+// switch it.
+func handleAudit(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) < 2 {
+		return nil, errors.New("usage: audit tail [n] | audit status")
+	}
+
+	switch parts[1] {
+	case "tail":
+		req := structs.Request{Op: structs.OpAuditTail}
+		if len(parts) == 3 {
+			var err error
+			if req.Limit, err = strconv.Atoi(parts[2]); err != nil || req.Limit <= 0 {
+				return nil, fmt.Errorf("invalid line count '%s'", parts[2])
+			}
+		}
+		res, err := execute(bucketManager, nil, req)
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Lines) == 0 {
+			return []string{"Audit log is empty."}, nil
+		}
+		return res.Lines, nil
+
+	case "status":
+		res, err := execute(bucketManager, nil, structs.Request{Op: structs.OpAuditStatus})
+		if err != nil {
+			return nil, err
+		}
+		off := make(map[string]bool, len(res.Off))
+		var buckets []string
+		for _, entry := range res.Off {
+			off[entry] = true
+			if name, ok := strings.CutPrefix(entry, "bucket:"); ok {
+				buckets = append(buckets, name)
+			}
+		}
+		status := []string{fmt.Sprintf("Audit log: %s", res.Log)}
+		for _, class := range []string{audit.ClassRead, audit.ClassWrite, audit.ClassAdmin} {
+			state := "on"
+			if off[class] {
+				state = "off"
+			}
+			status = append(status, fmt.Sprintf("  %-6s %s", class, state))
+		}
+		if len(buckets) > 0 {
+			status = append(status, "  off for buckets: "+strings.Join(buckets, ", "))
+		}
+		return status, nil
+
+	case "on", "off":
+		return nil, errors.New("auditing is switched with the audit_off setting of the server config")
+	}
+	return nil, fmt.Errorf("unknown audit command '%s'", parts[1])
+}
+
+// handleConfig shows the effective server configuration: config get [setting]
+func handleConfig(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "get" {
+		return nil, errors.New("usage: config get [setting]")
+	}
+	req := structs.Request{Op: structs.OpConfig}
+	if len(parts) == 3 {
+		req.Setting = parts[2]
+	}
+	res, err := execute(bucketManager, nil, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parts) == 3 {
+		s := res.Settings[0]
+		return []string{fmt.Sprintf("%s = %s (%s)", s.Name, s.Value, s.Source)}, nil
+	}
+	lines := make([]string, 0, len(res.Settings)+1)
+	if file := config.Current().File; file != "" {
+		lines = append(lines, "config file: "+file)
+	}
+	for _, s := range res.Settings {
+		lines = append(lines, fmt.Sprintf("  %-16s = %-28s (%s)", s.Name, s.Value, s.Source))
+	}
+	return lines, nil
 }
 
-// func showActiveBucket(bucketManager *bucket.BucketManager) ([]string, error) {
+// func showActiveBucket(bucketManager *bucket.Session) ([]string, error) {
 // 	activeBucket, err := bucketManager.GetActiveBucket()
 // 	if err != nil {
 // 		return nil, err
@@ -109,10 +496,45 @@ func handleHelp(typeHelp int) ([]string, error) {
 
 func printHelpGlobal() ([]string, error) {
 	help := []string{`Available commands:
-  create <bucket_name>         - Create a new bucket
-  list                         - List all buckets
+  create <bucket_name> [with k=v ...]
+                               - Create a new bucket, options: order, durability,
+                                 ttl, max_value_size, compression, read_only,
+                                 protected
+  alter bucket <name> set k=v  - Change a bucket's options
+  describe <bucket_name>       - Show a bucket's options
+  rename <old_name> <new_name>  - Rename a bucket
+  clone <source> <target> [at <lsn>]
+                               - Copy a bucket, optionally as it was at an LSN
+  freeze <bucket_name> [--chmod]
+                               - Reject writes to a bucket, --chmod also
+                                 write-protects its files
+  unfreeze <bucket_name>       - Allow writes again
+  catalog rebuild              - Re-register bucket directories missing from the metadata
+  list [-v]                    - List all buckets and whether they are loaded,
+                                 -v adds key count, WAL size and garbage
+  stats [bucket_name]          - Show size, garbage, B+ tree shape and operation
+                                 counts of a bucket, or a line per bucket
+  pin <bucket_name>            - Keep a bucket loaded even when idle
+  unpin <bucket_name>          - Let an idle bucket be unloaded again
   use <bucket_name>            - Switch to the specified bucket
-  drop <bucket_name>           - Delete the specified bucket
+  drop <bucket_name>           - Move the specified bucket to the trash
+  undrop <bucket_name>         - Restore the most recently dropped bucket of that name
+  trash list                   - List dropped buckets
+  trash purge [bucket_name]    - Permanently delete dropped buckets
+  export <bucket_name> <file> [format=jsonl|csv] [range <start> <end>]
+                               - Write a bucket's keys to a file in the server's
+                                 export_dir
+  import <bucket_name> <file> [format=jsonl|csv] [batch=<n>]
+                               - Load keys from a file on the server in atomic
+                                 batches; the client's \export and \import
+                                 use local files instead
+  backup <dir> [incremental <base_dir>]
+                               - Write a consistent backup to the server's
+                                 backup_dir while serving writes, restore it
+                                 offline with bytedata-backup restore
+  audit tail [n]               - Show the last n audit log records
+  audit status                 - Show which commands are audited (set by audit_off)
+  config get [setting]         - Show the effective server configuration
   pwb                          - Print the active bucket
   exit / quit                  - Exit the CLI
   help                         - Show this help message`}
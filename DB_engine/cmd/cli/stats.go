@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"byted/DB_engine/core/bucket"
+	"byted/DB_engine/core/kv"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// stats [bucket_name] - one bucket in detail, or a line per bucket
+func handleStats(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	switch len(parts) {
+	case 1:
+		return bucketSummaries(bucketManager, true), nil
+	case 2:
+		b, err := bucketManager.GetBucket(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		// asked for by name, so worth loading
+		if err := b.KvEngine.Load(); err != nil {
+			return nil, fmt.Errorf("failed to load bucket %s: %v", b.Name, err)
+		}
+		return bucketStats(b), nil
+	}
+	return nil, fmt.Errorf("usage: stats [bucket_name]")
+}
+
+// bucketSummaries describes every bucket on one line each without loading
+// any; with total set a line adding them up follows.
+func bucketSummaries(bucketManager *bucket.Session, total bool) []string {
+	names := bucketManager.ListBuckets("")
+	sort.Strings(names)
+
+	var sum kv.Stats
+	lines := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		b, err := bucketManager.GetBucket(name)
+		if err != nil {
+			continue // dropped meanwhile
+		}
+		s := b.KvEngine.Stats()
+		lines = append(lines, fmt.Sprintf("%-20s %s", name, summaryLine(s, b.Options.Pinned)))
+		sum.Keys += s.Keys
+		sum.WALBytes += s.WALBytes
+	}
+	if total {
+		lines = append(lines, fmt.Sprintf("%d bucket(s), %d key(s) in loaded buckets, %s of WAL", len(lines), sum.Keys, formatBytes(sum.WALBytes)))
+	}
+	return lines
+}
+
+func summaryLine(s kv.Stats, pinned bool) string {
+	state := "unloaded"
+	if s.Loaded {
+		state = "loaded"
+	}
+	if pinned {
+		state += ", pinned"
+	}
+	if !s.Loaded {
+		return fmt.Sprintf("(%s) WAL %s", state, formatBytes(s.WALBytes))
+	}
+	return fmt.Sprintf("(%s) %d key(s), WAL %s, %.0f%% garbage, LSN %d",
+		state, s.Keys, formatBytes(s.WALBytes), 100*s.GarbageRatio(), s.LastLSN)
+}
+
+// bucketStats describes one bucket in detail.
+func bucketStats(b *bucket.Bucket) []string {
+	s := b.KvEngine.Stats()
+	if !s.Loaded {
+		return []string{fmt.Sprintf("Bucket '%s' is not loaded, WAL %s", b.Name, formatBytes(s.WALBytes))}
+	}
+	garbage := s.GarbageRatio()
+	return []string{
+		fmt.Sprintf("Bucket '%s', loaded since %s (%s ago)", b.Name,
+			s.LoadedAt.Local().Format("2006-01-02 15:04:05"), time.Since(s.LoadedAt).Round(time.Second)),
+		fmt.Sprintf("  keys            %d", s.Keys),
+		fmt.Sprintf("  key bytes       %s", formatBytes(s.KeyBytes)),
+		fmt.Sprintf("  value bytes     %s", formatBytes(s.ValueBytes)),
+		fmt.Sprintf("  WAL size        %s", formatBytes(s.WALBytes)),
+		fmt.Sprintf("  live / garbage  %.1f%% / %.1f%%", 100*(1-garbage), 100*garbage),
+		fmt.Sprintf("  last LSN        %d", s.LastLSN),
+		fmt.Sprintf("  B+ tree         height %d, %d node(s) of which %d leaves, %.1f%% full",
+			s.Tree.Height, s.Tree.Nodes, s.Tree.Leaves, 100*s.Tree.FillFactor),
+		fmt.Sprintf("  operations      %d get, %d put, %d delete, %d range", s.Gets, s.Puts, s.Deletes, s.Ranges),
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Tokenize splits a command line into arguments:
+//
+//	put key value              whitespace separates arguments
+//	put "my key" 'two  spaces' quotes keep whitespace; '...' is taken literally
+//	put k "line\nbreak"        backslash escapes outside single quotes:
+//	                           \n \r \t \0 \\ \" \' \<space> \xHH
+//	put k hex:00ff             keys and values of put, get and delete may be
+//	put k b64:AP8=             hex:... or b64:..., decoded unless quoted
+//
+// Arguments are returned as strings holding the raw bytes; literals are left
+// as written, see decodeArgs.
+func Tokenize(input string) ([]string, error) {
+	args, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	return texts(args), nil
+}
+
+// arg is one argument of a command line.
+type arg struct {
+	text   string
+	quoted bool // some of it was quoted or escaped, so it is not a literal
+}
+
+func texts(args []arg) []string {
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = a.text
+	}
+	return parts
+}
+
+func tokenize(input string) ([]arg, error) {
+	var tokens []arg
+	var current strings.Builder
+	inToken := false // an argument has started, possibly an empty quoted one
+	quoted := false  // some of the argument was quoted
+
+	finish := func() error {
+		if !inToken {
+			return nil
+		}
+		tokens = append(tokens, arg{text: current.String(), quoted: quoted})
+		current.Reset()
+		inToken, quoted = false, false
+		return nil
+	}
+
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if err := finish(); err != nil {
+				return nil, err
+			}
+
+		case c == '\'':
+			end := strings.IndexByte(input[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ' quote at position %d", i+1)
+			}
+			current.WriteString(input[i+1 : i+1+end])
+			i += end + 1
+			inToken, quoted = true, true
+
+		case c == '"':
+			inToken, quoted = true, true
+			closed := false
+			for i++; i < len(input); i++ {
+				if input[i] == '"' {
+					closed = true
+					break
+				}
+				if input[i] == '\\' {
+					n, err := unescape(input, i, &current)
+					if err != nil {
+						return nil, err
+					}
+					i += n
+					continue
+				}
+				current.WriteByte(input[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated \" quote")
+			}
+
+		case c == '\\':
+			n, err := unescape(input, i, &current)
+			if err != nil {
+				return nil, err
+			}
+			i += n
+			inToken = true
+			quoted = true // an escaped prefix is no longer a literal
+
+		default:
+			current.WriteByte(c)
+			inToken = true
+		}
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// unescape writes the escape sequence starting at input[i] (a backslash) to
+// out and returns how many bytes past i it used.
+func unescape(input string, i int, out *strings.Builder) (int, error) {
+	if i+1 >= len(input) {
+		return 0, fmt.Errorf("trailing backslash")
+	}
+	switch c := input[i+1]; c {
+	case 'n':
+		out.WriteByte('\n')
+	case 'r':
+		out.WriteByte('\r')
+	case 't':
+		out.WriteByte('\t')
+	case '0':
+		out.WriteByte(0)
+	case '\\', '"', '\'', ' ':
+		out.WriteByte(c)
+	case 'x':
+		if i+3 >= len(input) {
+			return 0, fmt.Errorf("incomplete \\x escape at position %d", i+1)
+		}
+		b, err := strconv.ParseUint(input[i+2:i+4], 16, 8)
+		if err != nil {
+			return 0, fmt.Errorf("invalid \\x escape '%s' at position %d", input[i:i+4], i+1)
+		}
+		out.WriteByte(byte(b))
+		return 3, nil
+	default:
+		return 0, fmt.Errorf("unknown escape '\\%c' at position %d", c, i+1)
+	}
+	return 1, nil
+}
+
+// decodeArgs returns the arguments of a put, get or delete with the unquoted
+// hex:... and b64:... literals decoded, its keys and values being where binary
+// data goes. Other commands take their arguments as written.
+func decodeArgs(args []arg) ([]string, error) {
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = a.text
+		if i == 0 || a.quoted {
+			continue
+		}
+		var err error
+		if parts[i], err = decodeLiteral(a.text); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+// decodeLiteral decodes hex:... and b64:... arguments.
+func decodeLiteral(token string) (string, error) {
+	switch {
+	case strings.HasPrefix(token, "hex:"):
+		b, err := hex.DecodeString(token[len("hex:"):])
+		if err != nil {
+			return "", fmt.Errorf("invalid hex literal '%s': %v", token, err)
+		}
+		return string(b), nil
+	case strings.HasPrefix(token, "b64:"):
+		b, err := base64.StdEncoding.DecodeString(token[len("b64:"):])
+		if err != nil {
+			return "", fmt.Errorf("invalid base64 literal '%s': %v", token, err)
+		}
+		return string(b), nil
+	}
+	return token, nil
+}
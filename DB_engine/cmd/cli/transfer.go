@@ -0,0 +1,231 @@
+package cli
+
+import (
+	"byted/DB_engine/config"
+	"byted/DB_engine/constants"
+	"byted/DB_engine/core/bucket"
+	"byted/DB_engine/core/transfer"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ExportChunkSize is the number of keys per message when a client exports.
+const ExportChunkSize = 500
+
+const (
+	exportUsage = "usage: export <bucket_name> <file> [format=jsonl|csv] [range <start> <end>]"
+	importUsage = "usage: import <bucket_name> <file> [format=jsonl|csv] [batch=<n>]"
+)
+
+// transferArgs are the options shared by export and import.
+type transferArgs struct {
+	format     string
+	batch      int
+	start, end []byte
+}
+
+func parseTransferArgs(args []string, file string) (transferArgs, error) {
+	var ta transferArgs
+	var format string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case strings.HasPrefix(args[i], "format="):
+			format = strings.TrimPrefix(args[i], "format=")
+		case strings.HasPrefix(args[i], "batch="):
+			n, err := strconv.Atoi(strings.TrimPrefix(args[i], "batch="))
+			if err != nil || n <= 0 {
+				return ta, fmt.Errorf("invalid batch size '%s'", strings.TrimPrefix(args[i], "batch="))
+			}
+			ta.batch = n
+		case args[i] == "range" && i+2 < len(args):
+			ta.start, ta.end = []byte(args[i+1]), []byte(args[i+2])
+			i += 2
+		default:
+			return ta, fmt.Errorf("unexpected argument '%s'", args[i])
+		}
+	}
+	var err error
+	ta.format, err = transfer.ParseFormat(format, file)
+	return ta, err
+}
+
+// transferPath resolves the file of a server-side export or import inside
+// export_dir.
+func transferPath(file string) (string, error) {
+	return confinedPath(serverConfig().ExportsDir(), "export", file)
+}
+
+// confinedPath resolves file inside dir, the export or backup directory, so a
+// client cannot reach other files on the server.
+func confinedPath(dir, kind, file string) (string, error) {
+	if !filepath.IsLocal(file) {
+		return "", fmt.Errorf("'%s' must be a relative path inside the %s directory", file, kind)
+	}
+	if err := os.MkdirAll(dir, constants.OWNERPERMISSION); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, file), nil
+}
+
+// serverConfig is the running server's configuration, or the defaults.
+func serverConfig() *config.Config {
+	if cfg := config.Current(); cfg != nil {
+		return cfg
+	}
+	return config.Default()
+}
+
+// export <bucket_name> <file> [format=jsonl|csv] [range <start> <end>]
+// The file is written on the server, in export_dir.
+func handleExport(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) < 3 {
+		return nil, errors.New(exportUsage)
+	}
+	args, err := parseTransferArgs(parts[3:], parts[2])
+	if err != nil || args.batch != 0 {
+		return nil, errors.New(exportUsage)
+	}
+	path, err := transferPath(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	b, err := bucketManager.GetBucket(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	// written next to the target and renamed, so a failed export leaves no partial file
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("export failed: %v", err)
+	}
+	count, lsn, err := writeExport(f, b, args)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("export failed: %v", err)
+	}
+	return []string{fmt.Sprintf("Exported %d key(s) from '%s' to %s as of LSN %d.", count, b.Name, parts[2], lsn)}, nil
+}
+
+func writeExport(f *os.File, b *bucket.Bucket, args transferArgs) (int, uint64, error) {
+	w, err := transfer.NewWriter(f, args.format)
+	if err != nil {
+		return 0, 0, err
+	}
+	count, lsn, err := b.Export(args.start, args.end, w.Write)
+	if err != nil {
+		return count, lsn, err
+	}
+	return count, lsn, w.Flush()
+}
+
+// import <bucket_name> <file> [format=jsonl|csv] [batch=<n>]
+// The file is read on the server, from export_dir.
+func handleImport(parts []string, bucketManager *bucket.Session) ([]string, error) {
+	if len(parts) < 3 {
+		return nil, errors.New(importUsage)
+	}
+	args, err := parseTransferArgs(parts[3:], parts[2])
+	if err != nil || args.start != nil {
+		return nil, errors.New(importUsage)
+	}
+	if args.batch == 0 {
+		args.batch = transfer.DefaultBatchSize
+		if cfg := config.Current(); cfg != nil {
+			args.batch = cfg.ImportBatchSize
+		}
+	}
+	b, err := bucketManager.GetBucket(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := transferPath(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("import failed: %v", err)
+	}
+	defer f.Close()
+	r, err := transfer.NewReader(f, args.format)
+	if err != nil {
+		return nil, err
+	}
+
+	apply := func(records []transfer.Record) (uint64, error) {
+		lsn, err := b.ImportBatch(records)
+		return lsn, frozenError(err, b)
+	}
+	progress := func(p transfer.Progress) {
+		if bucketManager.Notify != nil {
+			bucketManager.Notify(fmt.Sprintf("imported %d key(s) in %d batch(es)", p.Records, p.Batches))
+		}
+	}
+	p, err := transfer.Import(r, args.batch, apply, progress)
+	if err != nil {
+		return nil, fmt.Errorf("import failed after %d key(s) in %d batch(es): %v", p.Records, p.Batches, err)
+	}
+	return []string{importSummary(p, b.Name)}, nil
+}
+
+func importSummary(p transfer.Progress, name string) string {
+	line := fmt.Sprintf("Imported %d key(s) into '%s' in %d batch(es), last LSN %d.", p.Records, name, p.Batches, p.LSN)
+	if p.Skipped > 0 {
+		line += fmt.Sprintf(" Skipped %d expired key(s).", p.Skipped)
+	}
+	return line
+}
+
+// ExportRows streams a bucket to the client as JSON lines, in chunks of
+// ExportChunkSize passed to send; the client writes them out in its format.
+func ExportRows(bucketManager *bucket.Session, name string, start, end []byte, send func([]string) error) (int, uint64, error) {
+	b, err := bucketManager.GetBucket(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	chunk := make([]string, 0, ExportChunkSize)
+	count, lsn, err := b.Export(start, end, func(rec transfer.Record) error {
+		line, err := transfer.MarshalLine(rec)
+		if err != nil {
+			return err
+		}
+		if chunk = append(chunk, line); len(chunk) == ExportChunkSize {
+			err = send(chunk)
+			chunk = chunk[:0]
+		}
+		return err
+	})
+	if err == nil && len(chunk) > 0 {
+		err = send(chunk)
+	}
+	return count, lsn, err
+}
+
+// ImportRows writes JSON lines sent by a client as one atomic batch.
+func ImportRows(bucketManager *bucket.Session, name string, lines []string) (uint64, error) {
+	b, err := bucketManager.GetBucket(name)
+	if err != nil {
+		return 0, err
+	}
+	records := make([]transfer.Record, len(lines))
+	for i, line := range lines {
+		if records[i], err = transfer.UnmarshalLine(line); err != nil {
+			return 0, fmt.Errorf("row %d: %v", i+1, err)
+		}
+	}
+	lsn, err := b.ImportBatch(records)
+	return lsn, frozenError(err, b)
+}
@@ -0,0 +1,604 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"byted/DB_engine/structs"
+)
+
+// A binary frame is a message prefixed with its length:
+//
+//	| uint32 length (big endian) | fields |
+//
+// Each field is a tag byte, field number << 3 | wire type, followed by an
+// unsigned varint (wireVarint) or a varint length and that many bytes
+// (wireBytes). Fields holding their zero value are left out, repeated fields
+// repeat their tag, and nested structures are the bytes of their own fields.
+// Unknown fields are skipped, so fields can be added without a new version.
+//
+// Unlike JSON, keys, values and text need no escaping or base64.
+
+// MaxFrameSize bounds a frame, so a corrupt length can't make the reader
+// allocate arbitrary amounts of memory.
+const MaxFrameSize = 256 << 20
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// Message fields
+const (
+	fieldType = iota + 1
+	fieldID
+	fieldBucket
+	fieldField
+	fieldUsername
+	fieldPassword
+	fieldToken
+	fieldCommand
+	fieldMessage
+	fieldData
+	fieldRequest
+	fieldResult
+	fieldCode
+	fieldVersion
+	fieldCapabilities
+	fieldEvent
+)
+
+// Request fields
+const (
+	reqOp = iota + 1
+	reqBucket
+	reqKey
+	reqValue
+	reqEnd
+	reqTTL
+	reqOptions
+	reqCond
+	reqLimit
+	reqIfLSN
+	reqDelta
+	reqDecr
+	reqFlags
+	reqTarget
+	reqAsOf
+	reqChmod
+	reqPath
+	reqBase
+	reqSetting
+)
+
+// Result fields
+const (
+	resLSN = iota + 1
+	resValue
+	resPairs
+	resBuckets
+	resNext
+	resFlags
+	resCount
+	resOptions
+	resTrash
+	resBase
+	resLines
+	resLog
+	resOff
+	resSettings
+)
+
+// Event fields
+const (
+	eventOp = iota + 1
+	eventKey
+	eventValue
+	eventLSN
+)
+
+// KVPair, BucketInfo, TrashEntry and Setting fields
+const (
+	pairKey = iota + 1
+	pairValue
+)
+
+const (
+	infoName = iota + 1
+	infoLoaded
+	infoPinned
+	infoReadOnly
+	infoUnavailable
+	infoLSN
+	infoBytes
+)
+
+const (
+	trashName = iota + 1
+	trashDroppedAt
+	trashPurgeAt
+)
+
+const (
+	settingName = iota + 1
+	settingValue
+	settingSource
+)
+
+// BinaryEncoder writes binary frames.
+type BinaryEncoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+func NewBinaryEncoder(w io.Writer) *BinaryEncoder {
+	return &BinaryEncoder{w: w}
+}
+
+// Encode writes msg as one frame, in a single Write.
+func (e *BinaryEncoder) Encode(msg structs.Message) error {
+	if msg.Encoding != "" {
+		if err := msg.DecodeBinary(); err != nil {
+			return err
+		}
+	}
+	e.buf = AppendMessage(append(e.buf[:0], 0, 0, 0, 0), &msg)
+	size := len(e.buf) - 4
+	if size > MaxFrameSize {
+		return fmt.Errorf("message of %d bytes is over the frame limit of %d", size, MaxFrameSize)
+	}
+	binary.BigEndian.PutUint32(e.buf, uint32(size))
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// BinaryDecoder reads binary frames.
+type BinaryDecoder struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func NewBinaryDecoder(r io.Reader) *BinaryDecoder {
+	return &BinaryDecoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next frame into msg.
+func (d *BinaryDecoder) Decode(msg *structs.Message) error {
+	var header [4]byte
+	if _, err := io.ReadFull(d.r, header[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes is over the limit of %d", size, MaxFrameSize)
+	}
+	if cap(d.buf) < int(size) {
+		d.buf = make([]byte, size)
+	}
+	d.buf = d.buf[:size]
+	if _, err := io.ReadFull(d.r, d.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	*msg = structs.Message{}
+	return ParseMessage(d.buf, msg)
+}
+
+// AppendMessage appends the fields of msg to buf.
+func AppendMessage(buf []byte, msg *structs.Message) []byte {
+	buf = appendString(buf, fieldType, msg.Type)
+	buf = appendVarint(buf, fieldID, msg.ID)
+	buf = appendString(buf, fieldBucket, msg.Bucket)
+	buf = appendString(buf, fieldField, msg.Field)
+	buf = appendString(buf, fieldUsername, msg.Username)
+	buf = appendString(buf, fieldPassword, msg.Password)
+	buf = appendString(buf, fieldToken, msg.Token)
+	buf = appendString(buf, fieldCommand, msg.Command)
+	buf = appendString(buf, fieldMessage, msg.Message)
+	for _, d := range msg.Data {
+		buf = appendField(buf, fieldData, []byte(d))
+	}
+	if msg.Request != nil {
+		buf = appendNested(buf, fieldRequest, func(b []byte) []byte { return appendRequest(b, msg.Request) })
+	}
+	if msg.Result != nil {
+		buf = appendNested(buf, fieldResult, func(b []byte) []byte { return appendResult(b, msg.Result) })
+	}
+	buf = appendString(buf, fieldCode, msg.Code)
+	buf = appendVarint(buf, fieldVersion, uint64(msg.Version))
+	for _, c := range msg.Capabilities {
+		buf = appendField(buf, fieldCapabilities, []byte(c))
+	}
+	if msg.Event != nil {
+		buf = appendNested(buf, fieldEvent, func(b []byte) []byte {
+			b = appendString(b, eventOp, msg.Event.Op)
+			b = appendBytes(b, eventKey, msg.Event.Key)
+			b = appendBytes(b, eventValue, msg.Event.Value)
+			return appendVarint(b, eventLSN, msg.Event.LSN)
+		})
+	}
+	return buf
+}
+
+func appendRequest(buf []byte, req *structs.Request) []byte {
+	buf = appendString(buf, reqOp, req.Op)
+	buf = appendString(buf, reqBucket, req.Bucket)
+	buf = appendBytes(buf, reqKey, req.Key)
+	buf = appendBytes(buf, reqValue, req.Value)
+	buf = appendBytes(buf, reqEnd, req.End)
+	buf = appendVarint(buf, reqTTL, uint64(req.TTL))
+	for _, o := range req.Options {
+		buf = appendField(buf, reqOptions, []byte(o))
+	}
+	buf = appendString(buf, reqCond, req.Cond)
+	buf = appendVarint(buf, reqLimit, uint64(req.Limit))
+	buf = appendVarint(buf, reqIfLSN, req.IfLSN)
+	buf = appendVarint(buf, reqDelta, req.Delta)
+	buf = appendBool(buf, reqDecr, req.Decr)
+	buf = appendVarint(buf, reqFlags, uint64(req.Flags))
+	buf = appendString(buf, reqTarget, req.Target)
+	buf = appendVarint(buf, reqAsOf, req.AsOf)
+	buf = appendBool(buf, reqChmod, req.Chmod)
+	buf = appendString(buf, reqPath, req.Path)
+	buf = appendString(buf, reqBase, req.Base)
+	buf = appendString(buf, reqSetting, req.Setting)
+	return buf
+}
+
+func appendResult(buf []byte, res *structs.Result) []byte {
+	buf = appendVarint(buf, resLSN, res.LSN)
+	buf = appendBytes(buf, resValue, res.Value)
+	for _, p := range res.Pairs {
+		buf = appendNested(buf, resPairs, func(b []byte) []byte {
+			b = appendBytes(b, pairKey, p.Key)
+			return appendBytes(b, pairValue, p.Value)
+		})
+	}
+	for _, info := range res.Buckets {
+		buf = appendNested(buf, resBuckets, func(b []byte) []byte {
+			b = appendString(b, infoName, info.Name)
+			b = appendBool(b, infoLoaded, info.Loaded)
+			b = appendBool(b, infoPinned, info.Pinned)
+			b = appendBool(b, infoReadOnly, info.ReadOnly)
+			b = appendString(b, infoUnavailable, info.Unavailable)
+			b = appendVarint(b, infoLSN, info.LSN)
+			return appendVarint(b, infoBytes, uint64(info.Bytes))
+		})
+	}
+	buf = appendBytes(buf, resNext, res.Next)
+	buf = appendVarint(buf, resFlags, uint64(res.Flags))
+	buf = appendVarint(buf, resCount, uint64(res.Count))
+	for _, o := range res.Options {
+		buf = appendField(buf, resOptions, []byte(o))
+	}
+	for _, entry := range res.Trash {
+		buf = appendNested(buf, resTrash, func(b []byte) []byte {
+			b = appendString(b, trashName, entry.Name)
+			b = appendVarint(b, trashDroppedAt, uint64(entry.DroppedAt))
+			return appendVarint(b, trashPurgeAt, uint64(entry.PurgeAt))
+		})
+	}
+	buf = appendString(buf, resBase, res.Base)
+	for _, line := range res.Lines {
+		buf = appendField(buf, resLines, []byte(line))
+	}
+	buf = appendString(buf, resLog, res.Log)
+	for _, entry := range res.Off {
+		buf = appendField(buf, resOff, []byte(entry))
+	}
+	for _, setting := range res.Settings {
+		buf = appendNested(buf, resSettings, func(b []byte) []byte {
+			b = appendString(b, settingName, setting.Name)
+			b = appendString(b, settingValue, setting.Value)
+			return appendString(b, settingSource, setting.Source)
+		})
+	}
+	return buf
+}
+
+func appendVarint(buf []byte, field byte, v uint64) []byte {
+	if v == 0 {
+		return buf
+	}
+	buf = append(buf, field<<3|wireVarint)
+	return binary.AppendUvarint(buf, v)
+}
+
+func appendBool(buf []byte, field byte, v bool) []byte {
+	if !v {
+		return buf
+	}
+	return appendVarint(buf, field, 1)
+}
+
+func appendString(buf []byte, field byte, s string) []byte {
+	if s == "" {
+		return buf
+	}
+	return appendField(buf, field, []byte(s))
+}
+
+func appendBytes(buf []byte, field byte, b []byte) []byte {
+	if len(b) == 0 {
+		return buf
+	}
+	return appendField(buf, field, b)
+}
+
+// appendField appends a length-delimited field even when it is empty, as
+// repeated fields must.
+func appendField(buf []byte, field byte, b []byte) []byte {
+	buf = append(buf, field<<3|wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// appendNested appends the fields written by fill as one length-delimited
+// field. The length is written once the size is known.
+func appendNested(buf []byte, field byte, fill func([]byte) []byte) []byte {
+	buf = append(buf, field<<3|wireBytes)
+	start := len(buf)
+	buf = fill(buf)
+	size := len(buf) - start
+
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(size))
+	buf = append(buf, prefix[:n]...)
+	copy(buf[start+n:], buf[start:start+size])
+	copy(buf[start:], prefix[:n])
+	return buf
+}
+
+var errTruncated = errors.New("truncated binary message")
+
+// parseFields calls fn for every field in buf; v is the value of varint
+// fields and b that of length-delimited ones.
+func parseFields(buf []byte, fn func(field byte, v uint64, b []byte) error) error {
+	for len(buf) > 0 {
+		tag := buf[0]
+		buf = buf[1:]
+		field, wire := tag>>3, tag&7
+
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errTruncated
+		}
+		buf = buf[n:]
+
+		var b []byte
+		switch wire {
+		case wireVarint:
+		case wireBytes:
+			if v > uint64(len(buf)) {
+				return errTruncated
+			}
+			b, buf = buf[:v], buf[v:]
+			v = 0
+		default:
+			return fmt.Errorf("unknown wire type %d in field %d", wire, field)
+		}
+		if err := fn(field, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseMessage parses the fields of a frame into msg. Keys and values are
+// copied out of buf.
+func ParseMessage(buf []byte, msg *structs.Message) error {
+	return parseFields(buf, func(field byte, v uint64, b []byte) error {
+		switch field {
+		case fieldType:
+			msg.Type = string(b)
+		case fieldID:
+			msg.ID = v
+		case fieldBucket:
+			msg.Bucket = string(b)
+		case fieldField:
+			msg.Field = string(b)
+		case fieldUsername:
+			msg.Username = string(b)
+		case fieldPassword:
+			msg.Password = string(b)
+		case fieldToken:
+			msg.Token = string(b)
+		case fieldCommand:
+			msg.Command = string(b)
+		case fieldMessage:
+			msg.Message = string(b)
+		case fieldData:
+			msg.Data = append(msg.Data, string(b))
+		case fieldRequest:
+			msg.Request = &structs.Request{}
+			return parseRequest(b, msg.Request)
+		case fieldResult:
+			msg.Result = &structs.Result{}
+			return parseResult(b, msg.Result)
+		case fieldCode:
+			msg.Code = string(b)
+		case fieldVersion:
+			msg.Version = int(v)
+		case fieldCapabilities:
+			msg.Capabilities = append(msg.Capabilities, string(b))
+		case fieldEvent:
+			msg.Event = &structs.Event{}
+			return parseEvent(b, msg.Event)
+		}
+		return nil
+	})
+}
+
+func parseRequest(buf []byte, req *structs.Request) error {
+	return parseFields(buf, func(field byte, v uint64, b []byte) error {
+		switch field {
+		case reqOp:
+			req.Op = string(b)
+		case reqBucket:
+			req.Bucket = string(b)
+		case reqKey:
+			req.Key = clone(b)
+		case reqValue:
+			req.Value = clone(b)
+		case reqEnd:
+			req.End = clone(b)
+		case reqTTL:
+			req.TTL = int64(v)
+		case reqOptions:
+			req.Options = append(req.Options, string(b))
+		case reqCond:
+			req.Cond = string(b)
+		case reqLimit:
+			req.Limit = int(v)
+		case reqIfLSN:
+			req.IfLSN = v
+		case reqDelta:
+			req.Delta = v
+		case reqDecr:
+			req.Decr = v != 0
+		case reqFlags:
+			req.Flags = uint32(v)
+		case reqTarget:
+			req.Target = string(b)
+		case reqAsOf:
+			req.AsOf = v
+		case reqChmod:
+			req.Chmod = v != 0
+		case reqPath:
+			req.Path = string(b)
+		case reqBase:
+			req.Base = string(b)
+		case reqSetting:
+			req.Setting = string(b)
+		}
+		return nil
+	})
+}
+
+func parseEvent(buf []byte, ev *structs.Event) error {
+	return parseFields(buf, func(field byte, v uint64, b []byte) error {
+		switch field {
+		case eventOp:
+			ev.Op = string(b)
+		case eventKey:
+			ev.Key = clone(b)
+		case eventValue:
+			ev.Value = clone(b)
+		case eventLSN:
+			ev.LSN = v
+		}
+		return nil
+	})
+}
+
+func parseResult(buf []byte, res *structs.Result) error {
+	return parseFields(buf, func(field byte, v uint64, b []byte) error {
+		switch field {
+		case resLSN:
+			res.LSN = v
+		case resValue:
+			res.Value = clone(b)
+		case resPairs:
+			var p structs.KVPair
+			err := parseFields(b, func(field byte, v uint64, b []byte) error {
+				switch field {
+				case pairKey:
+					p.Key = clone(b)
+				case pairValue:
+					p.Value = clone(b)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			res.Pairs = append(res.Pairs, p)
+		case resBuckets:
+			var info structs.BucketInfo
+			err := parseFields(b, func(field byte, v uint64, b []byte) error {
+				switch field {
+				case infoName:
+					info.Name = string(b)
+				case infoLoaded:
+					info.Loaded = v != 0
+				case infoPinned:
+					info.Pinned = v != 0
+				case infoReadOnly:
+					info.ReadOnly = v != 0
+				case infoUnavailable:
+					info.Unavailable = string(b)
+				case infoLSN:
+					info.LSN = v
+				case infoBytes:
+					info.Bytes = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			res.Buckets = append(res.Buckets, info)
+		case resNext:
+			res.Next = clone(b)
+		case resFlags:
+			res.Flags = uint32(v)
+		case resCount:
+			res.Count = int(v)
+		case resOptions:
+			res.Options = append(res.Options, string(b))
+		case resTrash:
+			var entry structs.TrashEntry
+			err := parseFields(b, func(field byte, v uint64, b []byte) error {
+				switch field {
+				case trashName:
+					entry.Name = string(b)
+				case trashDroppedAt:
+					entry.DroppedAt = int64(v)
+				case trashPurgeAt:
+					entry.PurgeAt = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			res.Trash = append(res.Trash, entry)
+		case resBase:
+			res.Base = string(b)
+		case resLines:
+			res.Lines = append(res.Lines, string(b))
+		case resLog:
+			res.Log = string(b)
+		case resOff:
+			res.Off = append(res.Off, string(b))
+		case resSettings:
+			var setting structs.Setting
+			err := parseFields(b, func(field byte, v uint64, b []byte) error {
+				switch field {
+				case settingName:
+					setting.Name = string(b)
+				case settingValue:
+					setting.Value = string(b)
+				case settingSource:
+					setting.Source = string(b)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			res.Settings = append(res.Settings, setting)
+		}
+		return nil
+	})
+}
+
+// clone copies b out of the frame buffer, which is reused for the next frame.
+func clone(b []byte) []byte {
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,134 @@
+// Package codec reads and writes protocol messages. A connection starts out
+// in JSON, which stays available for debugging, and may switch to the compact
+// binary framing once both ends agree in the hello handshake:
+//
+//	client: {"type": "hello", "version": 1, "capabilities": ["binary"]}
+//	server: {"type": "hello", "version": 1, "capabilities": ["binary"]}
+//
+// The server answers with the capabilities it accepted. Everything after its
+// hello is sent in binary frames by both ends when "binary" is among them.
+// A server without the handshake answers with an error, and the client keeps
+// using JSON.
+package codec
+
+import (
+	"encoding/json"
+	"io"
+
+	"byted/DB_engine/structs"
+)
+
+// Version is the protocol version sent in the handshake.
+const Version = 1
+
+// CapBinary asks for the binary framing.
+const CapBinary = "binary"
+
+// Capabilities are what this build of the server supports.
+var Capabilities = []string{CapBinary}
+
+// Encoder writes messages.
+type Encoder interface {
+	Encode(msg structs.Message) error
+}
+
+// Decoder reads messages.
+type Decoder interface {
+	Decode(msg *structs.Message) error
+}
+
+// Accept is the part of the client's capabilities the server supports.
+func Accept(requested []string) []string {
+	var accepted []string
+	for _, c := range requested {
+		for _, supported := range Capabilities {
+			if c == supported {
+				accepted = append(accepted, c)
+				break
+			}
+		}
+	}
+	return accepted
+}
+
+// Has reports whether capability is among caps.
+func Has(caps []string, capability string) bool {
+	for _, c := range caps {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// JSONEncoder writes one JSON object per message.
+type JSONEncoder struct {
+	enc *json.Encoder
+}
+
+// NewJSONEncoder encodes through enc, so it can share it with the
+// authentication that ran before.
+func NewJSONEncoder(enc *json.Encoder) *JSONEncoder {
+	return &JSONEncoder{enc: enc}
+}
+
+// Encode base64 encodes the message first if it holds bytes JSON strings
+// cannot carry.
+func (e *JSONEncoder) Encode(msg structs.Message) error {
+	msg.EncodeBinary()
+	return e.enc.Encode(msg)
+}
+
+// JSONDecoder reads JSON objects.
+type JSONDecoder struct {
+	dec *json.Decoder
+}
+
+// NewJSONDecoder decodes through dec.
+func NewJSONDecoder(dec *json.Decoder) *JSONDecoder {
+	return &JSONDecoder{dec: dec}
+}
+
+// Decode reads the next message. Base64 encoded messages are left for the
+// caller to decode, so it can answer a bad one without dropping the
+// connection.
+func (d *JSONDecoder) Decode(msg *structs.Message) error {
+	return d.dec.Decode(msg)
+}
+
+// Buffered is what the decoder has read past the last message; a connection
+// switching codecs has to continue from there.
+func (d *JSONDecoder) Buffered() io.Reader {
+	return d.dec.Buffered()
+}
+
+// Continue is the rest of the stream after what dec has decoded so far.
+func Continue(dec Decoder, r io.Reader) io.Reader {
+	switch d := dec.(type) {
+	case *JSONDecoder:
+		return &afterJSON{r: io.MultiReader(d.Buffered(), r)}
+	case *BinaryDecoder:
+		return d.r
+	}
+	return r
+}
+
+// afterJSON drops the newline json.Encoder writes after every message, which
+// the JSON decoder leaves unread.
+type afterJSON struct {
+	r       io.Reader
+	skipped bool
+}
+
+func (a *afterJSON) Read(p []byte) (int, error) {
+	if !a.skipped && len(p) > 0 {
+		if _, err := io.ReadFull(a.r, p[:1]); err != nil {
+			return 0, err
+		}
+		a.skipped = true
+		if p[0] != '\n' {
+			return 1, nil
+		}
+	}
+	return a.r.Read(p)
+}
@@ -0,0 +1,556 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"byted/DB_engine/constants"
+)
+
+// Precedence, lowest to highest:
+//
+//	defaults < config file < BYTEDATA_* environment variables < command line flags
+//
+// Every setting has one name used everywhere: "data_dir" is the key in the
+// config file, BYTEDATA_DATA_DIR in the environment and -data-dir on the
+// command line.
+
+const EnvPrefix = "BYTEDATA_"
+
+// durability modes
+const (
+	DurabilitySync = "sync" // fsync after every WAL append
+	DurabilityNone = "none" // leave flushing to the OS, fsync on close
+)
+
+// value sources, reported by `config get`
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Config holds the effective server configuration.
+type Config struct {
+	ListenAddr      string   `json:"listen_addr"`
+	DataDir         string   `json:"data_dir"`
+	BTreeOrder      int      `json:"btree_order"`
+	Durability      string   `json:"durability"`
+	AuthFile        string   `json:"auth_file"`
+	AdminUser       string   `json:"admin_user"`
+	AdminPassword   string   `json:"admin_password"`
+	AuthTimeout     Duration `json:"auth_timeout"`
+	IdleTimeout     Duration `json:"idle_timeout"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	MaxConnections  int      `json:"max_connections"`
+	MaxConnsPerIP   int      `json:"max_connections_per_ip"`
+	MaxMessageSize  int      `json:"max_message_size"`
+	MaxValueSize    int      `json:"max_value_size"`
+	AuditMaxSize    int64    `json:"audit_max_size"`
+	AuditMaxFiles   int      `json:"audit_max_files"`
+	AuditOff        string   `json:"audit_off"`
+	TrashRetention  Duration `json:"trash_retention"`
+	BucketIdle      Duration `json:"bucket_idle_timeout"`
+	PreloadBuckets  bool     `json:"preload_buckets"`
+	RecoveryWorkers int      `json:"recovery_workers"`
+	ImportBatchSize int      `json:"import_batch_size"`
+	ExportDir       string   `json:"export_dir"`
+	BackupDir       string   `json:"backup_dir"`
+	RESPAddr        string   `json:"resp_addr"`
+	HTTPAddr        string   `json:"http_addr"`
+	Memcached       string   `json:"memcached_listeners"`
+	UnixSocket      string   `json:"unix_socket"`
+	UnixSocketMode  string   `json:"unix_socket_mode"`
+	UnixPeerUsers   string   `json:"unix_peer_users"`
+
+	// where the config file was read from, "" when none was used
+	File string `json:"-"`
+	// command line arguments left after the flags, for tools sharing the server's flags
+	Args []string `json:"-"`
+	// source of each setting, keyed by setting name
+	sources map[string]string
+}
+
+// Duration is a time.Duration written as "30s", "5m" in the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// setting binds a named value to its field in Config.
+type setting struct {
+	name   string
+	usage  string
+	secret bool
+	get    func(c *Config) string
+	set    func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{name: "listen_addr", usage: "TCP address to listen on",
+		get: func(c *Config) string { return c.ListenAddr },
+		set: func(c *Config, v string) error { c.ListenAddr = v; return nil }},
+	{name: "data_dir", usage: "Directory holding buckets, metadata and the auth store",
+		get: func(c *Config) string { return c.DataDir },
+		set: func(c *Config, v string) error { c.DataDir = v; return nil }},
+	{name: "btree_order", usage: "Default B+ tree order for new buckets",
+		get: func(c *Config) string { return strconv.Itoa(c.BTreeOrder) },
+		set: func(c *Config, v string) error { return setInt(&c.BTreeOrder, v) }},
+	{name: "durability", usage: "WAL durability mode: sync or none",
+		get: func(c *Config) string { return c.Durability },
+		set: func(c *Config, v string) error { c.Durability = v; return nil }},
+	{name: "auth_file", usage: "Path of the auth store (default <data_dir>/auth.json)",
+		get: func(c *Config) string { return c.AuthFile },
+		set: func(c *Config, v string) error { c.AuthFile = v; return nil }},
+	{name: "admin_user", usage: "Admin username created on first start",
+		get: func(c *Config) string { return c.AdminUser },
+		set: func(c *Config, v string) error { c.AdminUser = v; return nil }},
+	{name: "admin_password", usage: "Admin password created on first start", secret: true,
+		get: func(c *Config) string { return c.AdminPassword },
+		set: func(c *Config, v string) error { c.AdminPassword = v; return nil }},
+	{name: "auth_timeout", usage: "Time a client gets to finish logging in (0 = no limit)",
+		get: func(c *Config) string { return c.AuthTimeout.String() },
+		set: func(c *Config, v string) error { return setDuration(&c.AuthTimeout, v) }},
+	{name: "idle_timeout", usage: "Close sessions idle for this long (0 = never)",
+		get: func(c *Config) string { return c.IdleTimeout.String() },
+		set: func(c *Config, v string) error { return setDuration(&c.IdleTimeout, v) }},
+	{name: "shutdown_timeout", usage: "How long startup recovery and in-flight commands get to finish on shutdown (0 = no limit)",
+		get: func(c *Config) string { return c.ShutdownTimeout.String() },
+		set: func(c *Config, v string) error { return setDuration(&c.ShutdownTimeout, v) }},
+	{name: "max_connections", usage: "Maximum concurrent client connections (0 = unlimited)",
+		get: func(c *Config) string { return strconv.Itoa(c.MaxConnections) },
+		set: func(c *Config, v string) error { return setInt(&c.MaxConnections, v) }},
+	{name: "max_connections_per_ip", usage: "Maximum concurrent connections from one IP address (0 = unlimited)",
+		get: func(c *Config) string { return strconv.Itoa(c.MaxConnsPerIP) },
+		set: func(c *Config, v string) error { return setInt(&c.MaxConnsPerIP, v) }},
+	{name: "max_message_size", usage: "Largest message or request body a client may send, in bytes (0 = unlimited)",
+		get: func(c *Config) string { return strconv.Itoa(c.MaxMessageSize) },
+		set: func(c *Config, v string) error { return setInt(&c.MaxMessageSize, v) }},
+	{name: "max_value_size", usage: "Largest value accepted by put, in bytes (0 = unlimited)",
+		get: func(c *Config) string { return strconv.Itoa(c.MaxValueSize) },
+		set: func(c *Config, v string) error { return setInt(&c.MaxValueSize, v) }},
+	{name: "audit_max_size", usage: "Rotate the audit log once it reaches this many bytes",
+		get: func(c *Config) string { return strconv.FormatInt(c.AuditMaxSize, 10) },
+		set: func(c *Config, v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid number '%s'", v)
+			}
+			c.AuditMaxSize = n
+			return nil
+		}},
+	{name: "audit_max_files", usage: "Number of audit log files kept, including the current one",
+		get: func(c *Config) string { return strconv.Itoa(c.AuditMaxFiles) },
+		set: func(c *Config, v string) error { return setInt(&c.AuditMaxFiles, v) }},
+	{name: "audit_off", usage: "Command classes (read, write, admin) and bucket:<name> entries left out of the audit log, comma separated",
+		get: func(c *Config) string { return c.AuditOff },
+		set: func(c *Config, v string) error { c.AuditOff = v; return nil }},
+	{name: "trash_retention", usage: "How long dropped buckets stay recoverable (0 = until purged)",
+		get: func(c *Config) string { return c.TrashRetention.String() },
+		set: func(c *Config, v string) error { return setDuration(&c.TrashRetention, v) }},
+	{name: "bucket_idle_timeout", usage: "Unload buckets unused for this long, unless pinned (0 = never)",
+		get: func(c *Config) string { return c.BucketIdle.String() },
+		set: func(c *Config, v string) error { return setDuration(&c.BucketIdle, v) }},
+	{name: "preload_buckets", usage: "Replay every bucket at startup instead of only the pinned ones",
+		get: func(c *Config) string { return strconv.FormatBool(c.PreloadBuckets) },
+		set: func(c *Config, v string) error { return setBool(&c.PreloadBuckets, v) }},
+	{name: "recovery_workers", usage: "Buckets replayed in parallel at startup (0 = one per CPU)",
+		get: func(c *Config) string { return strconv.Itoa(c.RecoveryWorkers) },
+		set: func(c *Config, v string) error { return setInt(&c.RecoveryWorkers, v) }},
+	{name: "import_batch_size", usage: "Keys written per atomic batch by import",
+		get: func(c *Config) string { return strconv.Itoa(c.ImportBatchSize) },
+		set: func(c *Config, v string) error { return setInt(&c.ImportBatchSize, v) }},
+	{name: "export_dir", usage: "Directory the server-side export and import files are in (default <data_dir>/exports)",
+		get: func(c *Config) string { return c.ExportsDir() },
+		set: func(c *Config, v string) error { c.ExportDir = v; return nil }},
+	{name: "backup_dir", usage: "Directory the server-side backups are written to and read from (default <data_dir>/backups)",
+		get: func(c *Config) string { return c.BackupsDir() },
+		set: func(c *Config, v string) error { c.BackupDir = v; return nil }},
+	{name: "resp_addr", usage: "TCP address of the Redis protocol listener (empty = off)",
+		get: func(c *Config) string { return c.RESPAddr },
+		set: func(c *Config, v string) error { c.RESPAddr = v; return nil }},
+	{name: "http_addr", usage: "TCP address of the HTTP API (empty = off)",
+		get: func(c *Config) string { return c.HTTPAddr },
+		set: func(c *Config, v string) error { c.HTTPAddr = v; return nil }},
+	{name: "memcached_listeners", usage: "Memcached protocol listeners as addr=bucket,... (empty = off)",
+		get: func(c *Config) string { return c.Memcached },
+		set: func(c *Config, v string) error { c.Memcached = v; return nil }},
+	{name: "unix_socket", usage: "Path of a Unix socket to listen on as well (empty = off)",
+		get: func(c *Config) string { return c.UnixSocket },
+		set: func(c *Config, v string) error { c.UnixSocket = v; return nil }},
+	{name: "unix_socket_mode", usage: "Permissions of the Unix socket, in octal",
+		get: func(c *Config) string { return c.UnixSocketMode },
+		set: func(c *Config, v string) error { c.UnixSocketMode = v; return nil }},
+	{name: "unix_peer_users", usage: "Unix socket peers logged in without a password, as uid=user,...",
+		get: func(c *Config) string { return c.UnixPeerUsers },
+		set: func(c *Config, v string) error { c.UnixPeerUsers = v; return nil }},
+}
+
+func setInt(dst *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid number '%s'", v)
+	}
+	*dst = n
+	return nil
+}
+
+func setBool(dst *bool, v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid boolean '%s'", v)
+	}
+	*dst = b
+	return nil
+}
+
+func setDuration(dst *Duration, v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid duration '%s'", v)
+	}
+	dst.Duration = d
+	return nil
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	c := &Config{
+		ListenAddr:      ":" + constants.DEFAULTPORT,
+		DataDir:         constants.DEFAULTDATADIR,
+		BTreeOrder:      constants.DEFAULTREEORDER,
+		Durability:      DurabilitySync,
+		AuthTimeout:     Duration{30 * time.Second},
+		IdleTimeout:     Duration{0},
+		ShutdownTimeout: Duration{10 * time.Second},
+		MaxConnections:  0,
+		MaxMessageSize:  64 << 20,
+		MaxValueSize:    0,
+		AuditMaxSize:    10 << 20,
+		AuditMaxFiles:   5,
+		TrashRetention:  Duration{constants.DEFAULTTRASHRETENTION},
+		BucketIdle:      Duration{15 * time.Minute},
+		ImportBatchSize: 1000,
+		UnixSocketMode:  "0660",
+		sources:         make(map[string]string),
+	}
+	for _, s := range settings {
+		c.sources[s.name] = SourceDefault
+	}
+	return c
+}
+
+// Load builds the effective configuration from the config file, the
+// environment and the given command line arguments (without the program name).
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("bytedata-server", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Path of the JSON config file (default "+constants.CONFIGFILEPATH+")")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.name] = fs.String(flagName(s.name), "", s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// config file: -config beats BYTEDATA_CONFIG; the default file is optional
+	path, explicit := *configPath, true
+	if path == "" {
+		path = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if path == "" {
+		path, explicit = constants.CONFIGFILEPATH, false
+	}
+	if err := cfg.loadFile(path, explicit); err != nil {
+		return nil, err
+	}
+
+	// environment
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(envName(s.name)); ok {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("%s: %v", envName(s.name), err)
+			}
+			cfg.sources[s.name] = SourceEnv
+		}
+	}
+
+	// flags, only those given explicitly
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if flagName(s.name) == f.Name && flagErr == nil {
+				if err := s.set(cfg, *values[s.name]); err != nil {
+					flagErr = fmt.Errorf("-%s: %v", f.Name, err)
+				}
+				cfg.sources[s.name] = SourceFlag
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if cfg.AuthFile == "" {
+		cfg.AuthFile = filepath.Join(cfg.DataDir, constants.AUTHFILENAME)
+	}
+	cfg.Args = fs.Args()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string, explicit bool) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	// find out which keys the file sets, so `config get` can report them
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	for key := range keys {
+		c.sources[key] = SourceFile
+	}
+	c.File = path
+	return nil
+}
+
+// Validate rejects settings the server cannot run with.
+func (c *Config) Validate() error {
+	if c.ListenAddr == "" {
+		return errors.New("listen_addr must not be empty")
+	}
+	if c.DataDir == "" {
+		return errors.New("data_dir must not be empty")
+	}
+	if c.BTreeOrder < 3 {
+		return fmt.Errorf("btree_order must be at least 3, got %d", c.BTreeOrder)
+	}
+	if c.Durability != DurabilitySync && c.Durability != DurabilityNone {
+		return fmt.Errorf("durability must be '%s' or '%s', got '%s'", DurabilitySync, DurabilityNone, c.Durability)
+	}
+	if c.AuthTimeout.Duration < 0 || c.IdleTimeout.Duration < 0 || c.ShutdownTimeout.Duration < 0 ||
+		c.TrashRetention.Duration < 0 || c.BucketIdle.Duration < 0 {
+		return errors.New("timeouts must not be negative")
+	}
+	if c.MaxConnections < 0 || c.MaxConnsPerIP < 0 || c.MaxMessageSize < 0 || c.MaxValueSize < 0 || c.RecoveryWorkers < 0 {
+		return errors.New("limits must not be negative")
+	}
+	if c.ImportBatchSize < 1 {
+		return fmt.Errorf("import_batch_size must be at least 1, got %d", c.ImportBatchSize)
+	}
+	if _, err := c.MemcachedListeners(); err != nil {
+		return err
+	}
+	if _, err := c.SocketMode(); err != nil {
+		return err
+	}
+	if _, err := c.PeerUsers(); err != nil {
+		return err
+	}
+	if _, _, err := c.AuditExclusions(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// BucketListener is a listener serving a single bucket.
+type BucketListener struct {
+	Addr   string
+	Bucket string
+}
+
+// MemcachedListeners parses memcached_listeners, addr=bucket pairs separated
+// by commas.
+func (c *Config) MemcachedListeners() ([]BucketListener, error) {
+	var listeners []BucketListener
+	for _, pair := range strings.Split(c.Memcached, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		addr, bucket, ok := strings.Cut(pair, "=")
+		if !ok || addr == "" || bucket == "" {
+			return nil, fmt.Errorf("memcached_listeners: expected addr=bucket, got '%s'", pair)
+		}
+		listeners = append(listeners, BucketListener{Addr: addr, Bucket: bucket})
+	}
+	return listeners, nil
+}
+
+// BucketsDir is where bucket directories live.
+func (c *Config) BucketsDir() string {
+	return filepath.Join(c.DataDir, constants.BUCKETDIR)
+}
+
+// ExportsDir is where server-side export and import files are kept.
+func (c *Config) ExportsDir() string {
+	if c.ExportDir != "" {
+		return c.ExportDir
+	}
+	return filepath.Join(c.DataDir, constants.EXPORTDIR)
+}
+
+// BackupsDir is where the backup command writes backups and finds their bases.
+func (c *Config) BackupsDir() string {
+	if c.BackupDir != "" {
+		return c.BackupDir
+	}
+	return filepath.Join(c.DataDir, constants.BACKUPDIR)
+}
+
+// MetaPath is the path of the bucket catalog.
+func (c *Config) MetaPath() string {
+	return filepath.Join(c.DataDir, constants.METABUCKETFILE)
+}
+
+// AuditFile is the path of the audit log.
+func (c *Config) AuditFile() string {
+	return filepath.Join(c.DataDir, constants.AUDITFILENAME)
+}
+
+// Get returns the effective value of a setting and where it came from.
+// Secrets are masked.
+func (c *Config) Get(name string) (string, string, error) {
+	for _, s := range settings {
+		if s.name == name {
+			return display(c, s), c.source(s.name), nil
+		}
+	}
+	return "", "", fmt.Errorf("unknown setting '%s'", name)
+}
+
+// Names lists the settings in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(settings))
+	for _, s := range settings {
+		names = append(names, s.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Config) source(name string) string {
+	if src, ok := c.sources[name]; ok {
+		return src
+	}
+	return SourceDefault
+}
+
+func display(c *Config, s setting) string {
+	v := s.get(c)
+	if s.secret && v != "" {
+		return "********"
+	}
+	if v == "" {
+		return `""`
+	}
+	return v
+}
+
+func flagName(name string) string {
+	return strings.ReplaceAll(name, "_", "-")
+}
+
+func envName(name string) string {
+	return EnvPrefix + strings.ToUpper(name)
+}
+
+var current *Config
+
+// SetCurrent publishes the configuration the server is running with.
+func SetCurrent(c *Config) {
+	current = c
+}
+
+// Current returns the configuration set by SetCurrent, or nil.
+func Current() *Config {
+	return current
+}
+
+// SocketMode parses unix_socket_mode.
+func (c *Config) SocketMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(c.UnixSocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("unix_socket_mode: expected octal permissions like 0660, got '%s'", c.UnixSocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// PeerUsers parses unix_peer_users, uid=user pairs separated by commas, into
+// the ByteData user of each uid.
+func (c *Config) PeerUsers() (map[uint32]string, error) {
+	users := make(map[uint32]string)
+	for _, pair := range strings.Split(c.UnixPeerUsers, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		uid, user, ok := strings.Cut(pair, "=")
+		n, err := strconv.ParseUint(uid, 10, 32)
+		if !ok || err != nil || user == "" {
+			return nil, fmt.Errorf("unix_peer_users: expected uid=user, got '%s'", pair)
+		}
+		users[uint32(n)] = user
+	}
+	return users, nil
+}
+
+// AuditExclusions parses audit_off into the command classes and the buckets
+// that are not audited.
+func (c *Config) AuditExclusions() (classes, buckets []string, err error) {
+	for _, entry := range strings.Split(c.AuditOff, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+		case entry == "read" || entry == "write" || entry == "admin":
+			classes = append(classes, entry)
+		case strings.HasPrefix(entry, "bucket:") && len(entry) > len("bucket:"):
+			buckets = append(buckets, strings.TrimPrefix(entry, "bucket:"))
+		default:
+			return nil, nil, fmt.Errorf("audit_off: expected read, write, admin or bucket:<name>, got '%s'", entry)
+		}
+	}
+	return classes, buckets, nil
+}
@@ -3,6 +3,7 @@ package constants
 import (
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // directories
@@ -19,6 +20,13 @@ const (
 	WALFILENAME    = "wal.log"
 	BUCKETDIR      = "buckets"
 	METABUCKETFILE = "buckets_meta.json"
+	AUDITFILENAME  = "audit.log"
+	LOCKFILENAME   = "LOCK"
+	PIDFILENAME    = "bytedata.pid"
+	TRASHDIR       = "trash"
+	TRASHINFOFILE  = "dropped.json"
+	EXPORTDIR      = "exports"
+	BACKUPDIR      = "backups"
 )
 
 // filepaths
@@ -28,6 +36,7 @@ var (
 	DEFAULTWALPATH = filepath.Join(DEFAULTDATADIR, WALFILENAME)
 	DBBUCKETSPATH = filepath.Join(DEFAULTDATADIR, BUCKETDIR)
 	GLOBALMETAPATH = filepath.Join(DEFAULTDATADIR, METABUCKETFILE)
+	AUDITFILEPATH  = filepath.Join(DEFAULTDATADIR, AUDITFILENAME)
 )
 
 // configs
@@ -35,6 +44,7 @@ const (
 	DEFAULTPORT = "8080"
 	DEFAULTHOST = "localhost"
 	DEFAULTREEORDER = 4
+	DEFAULTTRASHRETENTION = 7 * 24 * time.Hour
 )
 
 
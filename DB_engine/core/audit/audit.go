@@ -0,0 +1,307 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"byted/DB_engine/constants"
+)
+
+// command classes, used to switch auditing on/off per kind of command
+const (
+	ClassRead  = "read"
+	ClassWrite = "write"
+	ClassAdmin = "admin"
+)
+
+// default rotation limits
+const (
+	DefaultMaxSize  = 10 << 20 // 10 MiB per file
+	DefaultMaxFiles = 5        // audit.log + 4 rotated files
+)
+
+// Record is one line of the audit log.
+type Record struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Remote  string    `json:"remote"`
+	Bucket  string    `json:"bucket,omitempty"`
+	Command string    `json:"command"`
+	Outcome string    `json:"outcome"` // "ok" or the error returned to the client
+	LSN     uint64    `json:"lsn,omitempty"`
+}
+
+// Logger is an append-only, size rotated audit log.
+type Logger struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mutex           sync.Mutex
+	f               *os.File
+	size            int64
+	disabledClasses map[string]bool
+	disabledBuckets map[string]bool
+}
+
+var std *Logger
+
+// Init opens the audit log used by the package level helpers.
+func Init(path string, maxSize int64, maxFiles int) error {
+	l, err := New(path, maxSize, maxFiles)
+	if err != nil {
+		return err
+	}
+	std = l
+	return nil
+}
+
+// New opens (or creates) the audit log at path.
+func New(path string, maxSize int64, maxFiles int) (*Logger, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultMaxFiles
+	}
+	if err := os.MkdirAll(filepath.Dir(path), constants.OWNERPERMISSION); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %v", err)
+	}
+
+	l := &Logger{
+		path:            path,
+		maxSize:         maxSize,
+		maxFiles:        maxFiles,
+		disabledClasses: make(map[string]bool),
+		disabledBuckets: make(map[string]bool),
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = info.Size()
+	return nil
+}
+
+// Close closes the underlying file.
+func (l *Logger) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.f == nil {
+		return errors.New("audit log is not open")
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// Classify maps a command name to its class.
+func Classify(command string) string {
+	switch command {
+	case "put", "del", "delete", "incr", "touch", "import":
+		return ClassWrite
+	case "get", "range", "scan", "list", "use", "help", "exit", "quit", "export", "stats":
+		return ClassRead
+	default:
+		return ClassAdmin
+	}
+}
+
+// Log appends rec unless its class or bucket has been switched off.
+func (l *Logger) Log(rec Record) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.disabledClasses[Classify(rec.Command)] || (rec.Bucket != "" && l.disabledBuckets[rec.Bucket]) {
+		return nil
+	}
+	return l.write(rec)
+}
+
+// write appends rec, the caller holds the mutex.
+func (l *Logger) write(rec Record) error {
+	if l.f == nil {
+		return errors.New("audit log is not open")
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate shifts audit.log -> audit.log.1 -> audit.log.2 ... dropping the oldest.
+func (l *Logger) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	l.f = nil
+
+	os.Remove(rotatedName(l.path, l.maxFiles-1))
+	for i := l.maxFiles - 2; i >= 1; i-- {
+		os.Rename(rotatedName(l.path, i), rotatedName(l.path, i+1))
+	}
+	if l.maxFiles > 1 {
+		if err := os.Rename(l.path, rotatedName(l.path, 1)); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %v", err)
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %v", err)
+	}
+	return l.open()
+}
+
+func rotatedName(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// SetClass switches auditing of a command class on or off. Switches come from
+// the server config, and each one is recorded whatever it switches off.
+func (l *Logger) SetClass(class string, on bool) error {
+	switch class {
+	case ClassRead, ClassWrite, ClassAdmin:
+	default:
+		return fmt.Errorf("unknown command class '%s' (read, write or admin)", class)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.disabledClasses[class] = !on
+	return l.write(switchRecord(on, class, ""))
+}
+
+// SetBucket switches auditing of commands run against a bucket on or off,
+// recording the switch like SetClass.
+func (l *Logger) SetBucket(bucket string, on bool) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if on {
+		delete(l.disabledBuckets, bucket)
+	} else {
+		l.disabledBuckets[bucket] = true
+	}
+	return l.write(switchRecord(on, "bucket", bucket))
+}
+
+// switchRecord records that auditing of what was switched on or off.
+func switchRecord(on bool, what, bucket string) Record {
+	state := "off"
+	if on {
+		state = "on"
+	}
+	return Record{User: "config", Bucket: bucket, Command: fmt.Sprintf("audit %s %s", state, what), Outcome: "ok"}
+}
+
+// Path is the audit log file.
+func (l *Logger) Path() string {
+	return l.path
+}
+
+// Off lists what is not audited the way audit_off does: the command classes
+// switched off, then a bucket:<name> entry per excluded bucket.
+func (l *Logger) Off() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var off []string
+	for _, class := range []string{ClassRead, ClassWrite, ClassAdmin} {
+		if l.disabledClasses[class] {
+			off = append(off, class)
+		}
+	}
+	buckets := make([]string, 0, len(l.disabledBuckets))
+	for name := range l.disabledBuckets {
+		buckets = append(buckets, "bucket:"+name)
+	}
+	sort.Strings(buckets)
+	return append(off, buckets...)
+}
+
+// Tail returns the last n lines, reaching into the rotated file when needed.
+func (l *Logger) Tail(n int) ([]string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var lines []string
+	for i := 0; i < l.maxFiles && len(lines) < n; i++ {
+		path := l.path
+		if i > 0 {
+			path = rotatedName(l.path, i)
+		}
+		fileLines, err := readLines(path)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(fileLines, lines...)
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// Log writes rec to the log opened by Init; a no-op when auditing is not set up.
+func Log(rec Record) error {
+	if std == nil {
+		return nil
+	}
+	return std.Log(rec)
+}
+
+// Default returns the logger opened by Init, or nil.
+func Default() *Logger {
+	return std
+}
@@ -5,48 +5,91 @@ import (
 	"fmt"
 )
 
-func HandleAuthenticatedConnection(comm *structs.Communicators) bool {
+// HandleAuthenticatedConnection runs the login handshake and returns the
+// authenticated username; any failure ends the session.
+func HandleAuthenticatedConnection(comm *structs.Communicators) (string, bool) {
 	dec := comm.Dec
 	enc := comm.Enc
 	enc.Encode(structs.Message{Type: "request", Field: "username"})
 
 	var msg structs.Message
-	
-	dec.Decode(&msg)
 
-	if !AuthExists() {
-		enc.Encode(structs.Message{Type: "info", Message: fmt.Sprintf("Hey %s, it looks like your first login, let's setup account", msg.Username)})
-
-		enc.Encode(structs.Message{Type: "request", Field: "password", Message: fmt.Sprintf("\nEnter %s's password: ", msg.Username)})
-
-		dec.Decode(&msg)
-		if msg.Username== "" || msg.Password == ""{
-			enc.Encode(structs.Message{Type: "error", Message: "\nUsername & password must not be empty!"})
-		}
+	if err := dec.Decode(&msg); err != nil {
+		return "", false
+	}
+	username := msg.Username
+	if username == "" {
+		enc.Encode(structs.Message{Type: "error", Message: "\nUsername must not be empty!"})
+		return "", false
+	}
 
-		if CreateUser(msg.Username, msg.Password) != nil {
-			enc.Encode(structs.Message{Type: "error", Message: "\nNew user creation failure!"})
-		}
-		enc.Encode(structs.Message{Type: "success", Message: fmt.Sprintf("\nWelcome %s", msg.Username)});
-		return true
+	if SetupPending() {
+		return username, handleFirstTimeSetup(comm, username)
 	}
 
-	if !UserExists(msg.Username) {
+	if !UserExists(username) {
 		enc.Encode(structs.Message{Type: "error", Message: "\nUser not found"})
-		return false
+		return "", false
 	}
-	enc.Encode(structs.Message{Type: "request", Field: "password", Message: fmt.Sprintf("Enter %s's password: ", msg.Username)})
+	enc.Encode(structs.Message{Type: "request", Field: "password", Message: fmt.Sprintf("Enter %s's password: ", username)})
 
 	if err := dec.Decode(&msg); err != nil || msg.Password == "" {
 		enc.Encode(structs.Message{Type: "error", Message: "\nInvalid password"})
-		return true
+		return "", false
 	}
 
-	if err := ValidateUser(msg.Username, msg.Password); err != nil {
+	if err := ValidateUser(username, msg.Password); err != nil {
 		enc.Encode(structs.Message{Type: "error", Message: "\nAuthentication failed"})
-		return false
+		return "", false
 	}
 
 	enc.Encode(structs.Message{Type: "success", Message: "\nLogging in...."})
+	return username, true
+}
+
+// HandlePeerConnection logs in a client the server already identified by the
+// credentials of its socket, skipping the username and password exchange.
+func HandlePeerConnection(comm *structs.Communicators, username string) (string, bool) {
+	if !UserExists(username) {
+		comm.Enc.Encode(structs.Message{Type: "error", Message: fmt.Sprintf("\nUser %s of this peer not found", username)})
+		return "", false
+	}
+	comm.Enc.Encode(structs.Message{Type: "success", Message: fmt.Sprintf("\nLogging in as %s....", username)})
+	return username, true
+}
+
+// handleFirstTimeSetup lets a remote client create the first account, but only
+// if it can present the one-time setup token printed by the server at startup.
+func handleFirstTimeSetup(comm *structs.Communicators, username string) bool {
+	dec := comm.Dec
+	enc := comm.Enc
+
+	enc.Encode(structs.Message{Type: "request", Field: "token", Message: "Server is not set up yet. Enter the setup token printed at server startup: "})
+
+	var msg structs.Message
+	if err := dec.Decode(&msg); err != nil || !validSetupToken(msg.Token) {
+		enc.Encode(structs.Message{Type: "error", Message: "\nInvalid setup token, remote sessions are refused until the server is set up"})
+		return false
+	}
+
+	enc.Encode(structs.Message{Type: "info", Message: fmt.Sprintf("\nHey %s, let's setup the admin account", username)})
+	enc.Encode(structs.Message{Type: "request", Field: "password", Message: fmt.Sprintf("\nEnter %s's password: ", username)})
+
+	token := msg.Token
+	if err := dec.Decode(&msg); err != nil || msg.Password == "" {
+		enc.Encode(structs.Message{Type: "error", Message: "\nUsername & password must not be empty!"})
+		return false
+	}
+
+	// another session may have finished the setup while we were waiting
+	if !consumeSetupToken(token) {
+		enc.Encode(structs.Message{Type: "error", Message: "\nSetup token already used"})
+		return false
+	}
+	if err := CreateUser(username, msg.Password); err != nil {
+		enc.Encode(structs.Message{Type: "error", Message: "\nNew user creation failure!"})
+		return false
+	}
+	enc.Encode(structs.Message{Type: "success", Message: fmt.Sprintf("\nWelcome %s", username)})
 	return true
 }
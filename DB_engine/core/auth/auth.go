@@ -1,15 +1,12 @@
 package auth
 
 import (
-	"bufio"
 	"byted/DB_engine/constants"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -21,6 +18,11 @@ type User struct {
 
 var authFile = constants.AUTHFILEPATH
 
+// SetAuthFile points the auth store at a different file, e.g. from the server config.
+func SetAuthFile(path string) {
+	authFile = path
+}
+
 func InitAuthFile() error {
 	dir := filepath.Dir(authFile)
 
@@ -100,25 +102,3 @@ func UserExists(username string) bool {
 	}
 	return user.Username == username
 }
-
-func FirstTimeSetup(conn net.Conn, reader *bufio.Reader) (string, string, error) {
-
-	fmt.Fprint(conn, "Welcome! Please set up your username and password.\n")
-	fmt.Fprint(conn, "Enter new username:")
-	newUser, _ := reader.ReadString('\n')
-	fmt.Fprint(conn, "Enter new password:")
-	newPass, _ := reader.ReadString('\n')
-	newUser = strings.TrimSpace(newUser)
-	newPass = strings.TrimSpace(newPass)
-
-	if newUser == "" || newPass == "" {
-		return "", "", errors.New("username and password cannot be empty")
-	}
-
-	if err := CreateUser(newUser, newPass); err != nil {
-		return "", "", err
-	}
-
-	fmt.Println("User created successfully!")
-	return newUser, newPass, nil
-}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
@@ -12,17 +13,9 @@ import (
 func AuthTCP(conn net.Conn) (string, error) {
 	reader := bufio.NewReader(conn)
 
-	if !AuthExists() {
-		username, password, err := FirstTimeSetup( conn, reader) 
-		
-		if err != nil {
-			return "", err
-		}
-
-		if err := CreateUser(username, password); err != nil {
-			return "", err
-		}
-		return username, nil
+	// accounts are only created through Bootstrap, never by whoever connects first
+	if SetupPending() {
+		return "", errors.New("server is not set up yet, connection refused")
 	}
 
 	// Ask for username/password over TCP
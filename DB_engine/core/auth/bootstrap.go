@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// setupToken is the one-time token that lets a remote client create the first
+// account. It is only set while no auth store exists and is cleared once used.
+var (
+	setupToken string
+	setupMutex sync.Mutex
+)
+
+// Bootstrap prepares the auth store when the server starts.
+//
+// If an account already exists nothing happens. If username and password are
+// given (server flag or environment) the admin account is created right away.
+// Otherwise a one-time setup token is generated and returned so the operator can
+// print it; until it is used, every remote session is refused.
+func Bootstrap(username, password string) (string, error) {
+	if AuthExists() {
+		return "", nil
+	}
+
+	if username != "" || password != "" {
+		if username == "" || password == "" {
+			return "", errors.New("admin username & password must both be set")
+		}
+		if err := CreateUser(username, password); err != nil {
+			return "", fmt.Errorf("failed to create admin user: %v", err)
+		}
+		return "", nil
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate setup token: %v", err)
+	}
+
+	setupMutex.Lock()
+	setupToken = hex.EncodeToString(buf)
+	setupMutex.Unlock()
+	return setupToken, nil
+}
+
+// SetupPending reports whether the server is still waiting for its first account.
+func SetupPending() bool {
+	return !AuthExists()
+}
+
+// validSetupToken reports whether token matches the current setup token.
+func validSetupToken(token string) bool {
+	setupMutex.Lock()
+	defer setupMutex.Unlock()
+	return matchSetupToken(token)
+}
+
+// consumeSetupToken checks the token and invalidates it on success, so only one
+// session can ever complete the setup.
+func consumeSetupToken(token string) bool {
+	setupMutex.Lock()
+	defer setupMutex.Unlock()
+
+	if !matchSetupToken(token) {
+		return false
+	}
+	setupToken = ""
+	return true
+}
+
+func matchSetupToken(token string) bool {
+	if setupToken == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(setupToken), []byte(token)) == 1
+}
@@ -0,0 +1,286 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"byted/DB_engine/constants"
+	"byted/DB_engine/core/fsutil"
+	"byted/DB_engine/core/wal"
+)
+
+// A backup directory holds:
+//
+//	manifest.json            what is in the backup, with checksums
+//	buckets_meta.json        the bucket catalog
+//	auth.json                the auth store
+//	wal/<bucket>.wal         WAL bytes [start, end) of each bucket
+//
+// A full backup has every WAL from offset 0. An incremental backup names the
+// backup it builds on and only holds what was appended since; restoring it
+// replays the whole chain down to the full backup.
+
+// ManifestFile is the name of the manifest in a backup directory.
+const ManifestFile = "manifest.json"
+
+// ManifestVersion is the manifest format written by this build.
+const ManifestVersion = 1
+
+const walDir = "wal"
+
+type Manifest struct {
+	Version   int           `json:"version"`
+	CreatedAt time.Time     `json:"created_at"`
+	Base      string        `json:"base,omitempty"` // backup this one builds on, "" for a full backup
+	Buckets   []BucketEntry `json:"buckets"`
+	Files     []FileEntry   `json:"files"`
+}
+
+// BucketEntry is one bucket's WAL segment and its LSN watermark.
+type BucketEntry struct {
+	Name       string `json:"name"`
+	LSN        uint64 `json:"lsn"`         // last LSN in the WAL up to End
+	Start      int64  `json:"start"`       // WAL offset the segment starts at, 0 = the whole WAL
+	End        int64  `json:"end"`         // WAL offset it ends at
+	TailOffset int64  `json:"tail_offset"` // where the last record starts, checked by the next incremental backup
+	File       string `json:"file"`        // segment, relative to the backup directory
+	SHA256     string `json:"sha256"`
+}
+
+// FileEntry is a file copied as is.
+type FileEntry struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// Source is one bucket to back up.
+type Source struct {
+	Name    string
+	WALPath string
+	Size    int64 // bytes of the WAL known to be complete, -1 = whatever is there now
+}
+
+// Request describes a backup to take.
+type Request struct {
+	Dest     string   // new, empty directory
+	Base     string   // previous backup for an incremental one, "" = full
+	Buckets  []Source // every bucket in Catalog
+	Catalog  []byte   // contents of the bucket metadata file
+	AuthFile string   // copied if it exists
+}
+
+// Create writes a backup as described by req. The WALs are append-only, so
+// copying a prefix of each one gives a consistent bucket as of its last LSN
+// while the server keeps writing past it.
+func Create(req Request) (*Manifest, error) {
+	var base *Manifest
+	if req.Base != "" {
+		abs, err := filepath.Abs(req.Base)
+		if err != nil {
+			return nil, err
+		}
+		if base, err = ReadManifest(abs); err != nil {
+			return nil, fmt.Errorf("failed to read base backup: %v", err)
+		}
+		req.Base = abs
+	}
+
+	if entries, err := os.ReadDir(req.Dest); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("backup directory %s is not empty", req.Dest)
+	}
+	if err := os.MkdirAll(filepath.Join(req.Dest, walDir), constants.OWNERPERMISSION); err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{Version: ManifestVersion, CreatedAt: time.Now().UTC(), Base: req.Base}
+
+	for _, src := range req.Buckets {
+		var previous *BucketEntry
+		if base != nil {
+			previous = base.bucket(src.Name)
+		}
+		entry, err := copyBucket(req.Dest, src, previous)
+		if err != nil {
+			return nil, fmt.Errorf("bucket %s: %v", src.Name, err)
+		}
+		manifest.Buckets = append(manifest.Buckets, *entry)
+	}
+
+	sum, err := writeFile(filepath.Join(req.Dest, constants.METABUCKETFILE), req.Catalog)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Files = append(manifest.Files, FileEntry{Path: constants.METABUCKETFILE, SHA256: sum})
+
+	if data, err := os.ReadFile(req.AuthFile); err == nil {
+		sum, err := writeFile(filepath.Join(req.Dest, constants.AUTHFILENAME), data)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, FileEntry{Path: constants.AUTHFILENAME, SHA256: sum})
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read auth store: %v", err)
+	}
+
+	// written last: a backup without a manifest is incomplete
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := fsutil.WriteFileAtomic(filepath.Join(req.Dest, ManifestFile), data, 0644); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// copyBucket copies the part of a WAL not already in previous, or all of it.
+func copyBucket(dest string, src Source, previous *BucketEntry) (*BucketEntry, error) {
+	f, err := os.Open(src.WALPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entry := &BucketEntry{Name: src.Name, File: filepath.Join(walDir, src.Name+".wal")}
+	if previous != nil {
+		if ok, err := continues(f, previous); err != nil {
+			return nil, err
+		} else if ok {
+			entry.Start = previous.End
+			entry.LSN = previous.LSN
+			entry.TailOffset = previous.TailOffset
+		}
+	}
+
+	size := src.Size
+	if size < 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		size = info.Size()
+	}
+	if size < entry.Start {
+		return nil, fmt.Errorf("WAL is shorter than in the base backup")
+	}
+
+	segPath := filepath.Join(dest, entry.File)
+	out, err := os.Create(segPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(entry.Start, io.SeekStart); err != nil {
+		out.Close()
+		return nil, err
+	}
+	if _, err := io.Copy(out, io.LimitReader(f, size-entry.Start)); err != nil {
+		out.Close()
+		return nil, err
+	}
+
+	// without the engine's lock the copy may end in a half-written record
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		out.Close()
+		return nil, err
+	}
+	complete, err := wal.ScanRecords(out, func(lsn uint64, offset int64) {
+		entry.LSN = lsn
+		entry.TailOffset = entry.Start + offset
+	})
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err := out.Truncate(complete); err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	entry.End = entry.Start + complete
+
+	if entry.SHA256, err = fileSum(segPath); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// continues reports whether the WAL still starts with what previous backed up:
+// the record previous ended with must be where it was, with the same LSN. A
+// bucket dropped and re-created under the same name fails this and is copied
+// in full.
+func continues(f *os.File, previous *BucketEntry) (bool, error) {
+	if previous.End == 0 {
+		return true, nil
+	}
+	header := make([]byte, 4+8)
+	if _, err := f.ReadAt(header, previous.TailOffset); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	length := int64(binary.LittleEndian.Uint32(header))
+	lsn := binary.LittleEndian.Uint64(header[4:])
+	return lsn == previous.LSN && previous.TailOffset+4+length == previous.End, nil
+}
+
+func (m *Manifest) bucket(name string) *BucketEntry {
+	for i := range m.Buckets {
+		if m.Buckets[i].Name == name {
+			return &m.Buckets[i]
+		}
+	}
+	return nil
+}
+
+// ReadManifest reads the manifest of the backup in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest in %s: %v", dir, err)
+	}
+	if m.Version > ManifestVersion {
+		return nil, fmt.Errorf("backup %s has manifest version %d, newer than this build supports (%d)", dir, m.Version, ManifestVersion)
+	}
+	return &m, nil
+}
+
+func writeFile(path string, data []byte) (string, error) {
+	if err := fsutil.WriteFileAtomic(path, data, 0600); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func fileSum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
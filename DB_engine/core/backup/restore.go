@@ -0,0 +1,244 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"byted/DB_engine/constants"
+	"byted/DB_engine/core/fsutil"
+	"byted/DB_engine/core/wal"
+)
+
+// Target is where Restore puts the data back.
+type Target struct {
+	BucketsDir string
+	MetaPath   string
+	AuthFile   string
+}
+
+// Chain returns the backup in dir and every backup it builds on, oldest (the
+// full backup) first.
+func Chain(dir string) ([]string, []*Manifest, error) {
+	var dirs []string
+	var manifests []*Manifest
+	seen := make(map[string]bool)
+
+	for dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		if seen[abs] {
+			return nil, nil, fmt.Errorf("backup %s builds on itself", abs)
+		}
+		seen[abs] = true
+
+		m, err := ReadManifest(abs)
+		if err != nil {
+			return nil, nil, err
+		}
+		dirs = append([]string{abs}, dirs...)
+		manifests = append([]*Manifest{m}, manifests...)
+		dir = m.Base
+	}
+	return dirs, manifests, nil
+}
+
+// Verify checks every checksum in the backup in dir and in the backups it
+// builds on, and that each incremental segment picks up where the previous
+// one ended. It returns the manifest of dir.
+func Verify(dir string) (*Manifest, error) {
+	dirs, manifests, err := Chain(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range manifests {
+		for _, f := range m.Files {
+			if err := checkSum(dirs[i], f.Path, f.SHA256); err != nil {
+				return nil, err
+			}
+		}
+		for _, b := range m.Buckets {
+			if err := checkSum(dirs[i], b.File, b.SHA256); err != nil {
+				return nil, err
+			}
+			if b.Start == 0 {
+				continue
+			}
+			var previous *BucketEntry
+			if i > 0 {
+				previous = manifests[i-1].bucket(b.Name)
+			}
+			if previous == nil || previous.End != b.Start {
+				return nil, fmt.Errorf("backup %s: segment of bucket %s starts at %d but the base backup does not end there", dirs[i], b.Name, b.Start)
+			}
+		}
+	}
+	return manifests[len(manifests)-1], nil
+}
+
+func checkSum(dir, file, want string) error {
+	got, err := fileSum(filepath.Join(dir, file))
+	if err != nil {
+		return fmt.Errorf("backup %s: %v", dir, err)
+	}
+	if got != want {
+		return fmt.Errorf("backup %s: checksum mismatch for %s", dir, file)
+	}
+	return nil
+}
+
+// Restore verifies the backup in dir and puts it in place of target's data.
+// The buckets are rebuilt in a staging directory first and only swapped in
+// once every WAL replays to its recorded LSN; the data that was there is kept
+// next to it with a .pre-restore-<time> suffix. The server must not be running.
+func Restore(dir string, target Target) error {
+	manifest, err := Verify(dir)
+	if err != nil {
+		return err
+	}
+	dirs, manifests, err := Chain(dir)
+	if err != nil {
+		return err
+	}
+
+	suffix := fmt.Sprintf("%d", time.Now().Unix())
+	staging := target.BucketsDir + ".restore-" + suffix
+	if err := os.MkdirAll(staging, constants.OWNERPERMISSION); err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		os.RemoveAll(staging)
+		return err
+	}
+
+	for _, b := range manifest.Buckets {
+		bucketDir := filepath.Join(staging, b.Name)
+		if err := os.MkdirAll(bucketDir, constants.OWNERPERMISSION); err != nil {
+			return fail(err)
+		}
+		walPath := filepath.Join(bucketDir, b.Name+constants.WALFILENAME)
+		if err := assembleWAL(walPath, b.Name, dirs, manifests); err != nil {
+			return fail(fmt.Errorf("bucket %s: %v", b.Name, err))
+		}
+		if err := checkWAL(walPath, b); err != nil {
+			return fail(fmt.Errorf("bucket %s: %v", b.Name, err))
+		}
+	}
+
+	last := dirs[len(dirs)-1]
+	catalog, err := os.ReadFile(filepath.Join(last, constants.METABUCKETFILE))
+	if err != nil {
+		return fail(err)
+	}
+	auth, authErr := os.ReadFile(filepath.Join(last, constants.AUTHFILENAME))
+
+	// swap in: keep what was there, then move the new data into place
+	aside := target.BucketsDir + ".pre-restore-" + suffix
+	movedAside := false
+	if _, err := os.Stat(target.BucketsDir); err == nil {
+		if err := os.Rename(target.BucketsDir, aside); err != nil {
+			return fail(fmt.Errorf("failed to move current buckets aside: %v", err))
+		}
+		movedAside = true
+	}
+	// putBack returns the data moved aside to its place when the restored
+	// buckets could not be swapped in
+	putBack := func(err error) error {
+		if !movedAside {
+			return err
+		}
+		if rerr := os.Rename(aside, target.BucketsDir); rerr != nil {
+			return fmt.Errorf("%v (and failed to move the previous buckets back from %s: %v)", err, aside, rerr)
+		}
+		return err
+	}
+	if err := os.Rename(staging, target.BucketsDir); err != nil {
+		return putBack(fail(fmt.Errorf("failed to move restored buckets into place: %v", err)))
+	}
+	if err := replaceFile(target.MetaPath, catalog, 0644, suffix); err != nil {
+		// the old catalog is still in place, so are its buckets again
+		os.RemoveAll(target.BucketsDir)
+		return putBack(err)
+	}
+	if authErr == nil {
+		if err := replaceFile(target.AuthFile, auth, 0600, suffix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assembleWAL concatenates a bucket's segments from the full backup onwards.
+func assembleWAL(walPath, name string, dirs []string, manifests []*Manifest) error {
+	// walk back from the newest backup to the segment starting at 0
+	var segments []string
+	for i := len(manifests) - 1; i >= 0; i-- {
+		b := manifests[i].bucket(name)
+		if b == nil {
+			return fmt.Errorf("missing from backup %s", dirs[i])
+		}
+		segments = append([]string{filepath.Join(dirs[i], b.File)}, segments...)
+		if b.Start == 0 {
+			break
+		}
+	}
+
+	out, err := os.OpenFile(walPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		in, err := os.Open(seg)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// checkWAL makes sure a restored WAL is complete and ends at the watermark.
+func checkWAL(walPath string, b BucketEntry) error {
+	f, err := os.Open(walPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var lastLSN uint64
+	complete, err := wal.ScanRecords(f, func(lsn uint64, offset int64) { lastLSN = lsn })
+	if err != nil {
+		return err
+	}
+	if complete != b.End || lastLSN != b.LSN {
+		return fmt.Errorf("restored WAL ends at offset %d, LSN %d; expected offset %d, LSN %d", complete, lastLSN, b.End, b.LSN)
+	}
+	return nil
+}
+
+func replaceFile(path string, data []byte, perm os.FileMode, suffix string) error {
+	if old, err := os.ReadFile(path); err == nil {
+		if err := os.WriteFile(path+".pre-restore-"+suffix, old, perm); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), constants.OWNERPERMISSION); err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(path, data, perm)
+}
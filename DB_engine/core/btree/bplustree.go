@@ -290,3 +290,51 @@ func (t *BPlusTree) RangeQuery(start, end string) []KVPair {
 
 	return results
 }
+
+// Ascend calls fn for every key from start on, in order, until fn returns false.
+func (t *BPlusTree) Ascend(start string, fn func(key string, value any) bool) {
+	for leaf := t.findLeaf(start); leaf != nil; leaf = leaf.next {
+		for i, key := range leaf.keys {
+			if key >= start && !fn(key, leaf.values[i]) {
+				return
+			}
+		}
+	}
+}
+
+// Stats describes the shape of the tree.
+type Stats struct {
+	Height     int     // levels, 1 for a lone leaf
+	Nodes      int     // internal and leaf nodes
+	Leaves     int     // leaf nodes
+	Keys       int     // keys in the leaves
+	FillFactor float64 // average keys per node relative to the order
+}
+
+// Stats walks the whole tree, level by level.
+func (t *BPlusTree) Stats() Stats {
+	var s Stats
+	if t.root == nil {
+		return s
+	}
+
+	totalKeys := 0
+	currentLevel := []*Node{t.root}
+	for len(currentLevel) > 0 {
+		s.Height++
+		nextLevel := []*Node{}
+		for _, n := range currentLevel {
+			s.Nodes++
+			totalKeys += len(n.keys)
+			if n.isLeaf {
+				s.Leaves++
+				s.Keys += len(n.keys)
+			} else {
+				nextLevel = append(nextLevel, n.children...)
+			}
+		}
+		currentLevel = nextLevel
+	}
+	s.FillFactor = float64(totalKeys) / float64(s.Nodes*t.order)
+	return s
+}
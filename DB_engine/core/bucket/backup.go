@@ -0,0 +1,56 @@
+package bucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"byted/DB_engine/constants"
+	"byted/DB_engine/core/backup"
+)
+
+// BackupSources returns the catalog and, for every bucket in it, how much of
+// its WAL is complete at this moment. Taken under the manager's lock, so the
+// catalog and the bucket list agree; writes carry on as soon as it returns.
+func (bm *BucketManager) BackupSources() ([]byte, []backup.Source, error) {
+	bm.mutex.RLock()
+	defer bm.mutex.RUnlock()
+
+	catalog, err := bm.catalogJSON()
+	if err != nil {
+		return nil, nil, err
+	}
+	sources := make([]backup.Source, 0, len(bm.Buckets))
+	for name, bucket := range bm.Buckets {
+		size, err := bucket.KvEngine.WALSize()
+		if err != nil {
+			return nil, nil, fmt.Errorf("bucket %s: %v", name, err)
+		}
+		sources = append(sources, backup.Source{Name: name, WALPath: bucket.KvEngine.WALPath(), Size: size})
+	}
+	return catalog, sources, nil
+}
+
+// OfflineBackupSources is BackupSources for tools that read the data
+// directory directly, possibly while a server is writing to it. WAL sizes are
+// left open; the backup trims any record still being written.
+func OfflineBackupSources(bucketsDir, metaPath string) ([]byte, []backup.Source, error) {
+	meta, err := ReadCatalog(metaPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read bucket metadata: %v", err)
+	}
+
+	sources := make([]backup.Source, 0, len(meta.Buckets))
+	for _, entry := range meta.Buckets {
+		if entry.RenamedFrom != "" {
+			return nil, nil, fmt.Errorf("bucket %s is being renamed, try again once the server has finished", entry.Name)
+		}
+		walPath := filepath.Join(bucketsDir, entry.Name, entry.Name+constants.WALFILENAME)
+		sources = append(sources, backup.Source{Name: entry.Name, WALPath: walPath, Size: -1})
+	}
+	catalog, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return catalog, sources, nil
+}
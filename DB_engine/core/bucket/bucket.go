@@ -7,77 +7,131 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type Bucket struct {
 	Name     string
 	KvEngine *kv.KVEngine
-}
+	Options  Options
 
-func NewBucket(name, baseDir string, btreeOrder int) (*Bucket, error) {
+	renamedFrom string // old name while a rename is moving the files
+}
 
-	walPath := filepath.Join(baseDir, name+constants.WALFILENAME)
-	kvEngine, err := kv.NewKVEngine(walPath, btreeOrder)
+// NewBucket opens a bucket and loads it right away.
+func NewBucket(name, baseDir string, opts Options) (*Bucket, error) {
+	bucket, err := newLazyBucket(name, baseDir, opts)
 	if err != nil {
 		return nil, err
 	}
+	if err := bucket.KvEngine.Load(); err != nil {
+		return nil, err
+	}
+	return bucket, nil
+}
+
+// newLazyBucket sets up a bucket whose WAL is only opened and replayed when
+// it is first used.
+func newLazyBucket(name, baseDir string, opts Options) (*Bucket, error) {
+	walPath := filepath.Join(baseDir, name+constants.WALFILENAME)
 	bucket := &Bucket{
 		Name:     name,
-		KvEngine: kvEngine,
+		KvEngine: kv.NewLazyKVEngine(walPath, opts.Order, opts.ReadOnlyOnDisk),
+		Options:  opts,
+	}
+	bucket.KvEngine.SetLoadHook(func(st kv.LoadStats) {
+		loaded := "loaded"
+		if st.Reload {
+			loaded = "reloaded after being idle"
+		}
+		fmt.Printf("Bucket %s %s: %d records, %d bytes in %s\n", name, loaded, st.Records, st.Bytes, st.Elapsed.Round(time.Microsecond))
+	})
+	if err := bucket.applyOptions(); err != nil {
+		return nil, err
 	}
 	return bucket, nil
 }
 
+// Loaded reports whether the bucket's data is in memory.
+func (b *Bucket) Loaded() bool {
+	return b.KvEngine.Loaded()
+}
+
 // Close closes the WAL file associated with the bucket.
 func (b *Bucket) Close() error {
 	return b.KvEngine.Close()
 }
 
-func (bm *BucketManager) CreateBucket(name string, btreeOrder int) error {
+// ValidateName checks that name can be used as a bucket's directory: it must
+// stay inside BaseDir, and names starting with a dot are kept for the files
+// the manager itself puts there, like clones being written.
+func ValidateName(name string) error {
+	switch {
+	case name == "":
+		return &kindError{kind: ErrInvalidName, msg: "bucket name is empty"}
+	case strings.ContainsAny(name, "/\\"):
+		return &kindError{kind: ErrInvalidName, msg: fmt.Sprintf("bucket name %q contains a path separator", name)}
+	case strings.HasPrefix(name, "."):
+		return &kindError{kind: ErrInvalidName, msg: fmt.Sprintf("bucket name %q starts with a dot", name)}
+	}
+	return nil
+}
+
+func (bm *BucketManager) CreateBucket(name string, opts Options) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
 	bm.mutex.Lock()
 	defer bm.mutex.Unlock()
 
-	if _, exists := bm.Buckets[name]; exists {
-		return fmt.Errorf("bucket %s already exists", name)
+	if bm.nameInUse(name) {
+		return bucketExists(name)
+	}
+	if err := opts.Validate(); err != nil {
+		return err
 	}
+
+	// a failed create removes the directory again, unless it was already there
 	bucketDir := filepath.Join(bm.BaseDir, name)
+	_, statErr := os.Stat(bucketDir)
+	created := os.IsNotExist(statErr)
 	if err := os.MkdirAll(bucketDir, constants.OWNERPERMISSION); err != nil {
 		return err
 	}
-
-	bucket, err := NewBucket(name, bucketDir, btreeOrder)
+	bucket, err := NewBucket(name, bucketDir, opts)
 	if err != nil {
+		if created {
+			os.RemoveAll(bucketDir)
+		}
 		return err
 	}
 
 	bm.Buckets[name] = bucket
-	bm.SaveMetaData()
+	if err := bm.SaveMetaData(); err != nil {
+		// a bucket missing from the catalog would vanish on restart, undo it
+		delete(bm.Buckets, name)
+		bucket.Close()
+		if created {
+			os.RemoveAll(bucketDir)
+		}
+		return fmt.Errorf("failed to save bucket metadata: %v", err)
+	}
 	return nil
 }
 
-func (bm *BucketManager) UseBucket(name string) (*Bucket, error) {
+// GetBucket returns an open bucket by name.
+func (bm *BucketManager) GetBucket(name string) (*Bucket, error) {
 	bm.mutex.RLock()
 	defer bm.mutex.RUnlock()
 
 	bucket, exists := bm.Buckets[name]
 	if !exists {
-		return nil, fmt.Errorf("bucket %s does not exist", name)
+		return nil, bm.missing(name)
 	}
-	bm.isActive = bucket
-	fmt.Printf("Switched to bucket: %s\n", name)
 	return bucket, nil
 }
 
-func (bm *BucketManager) GetActiveBucket() (*Bucket, error) {
-	bm.mutex.RLock()
-	defer bm.mutex.RUnlock()
-
-	if bm.isActive == nil {
-		return nil, fmt.Errorf("no active bucket selected")
-	}
-	return bm.isActive, nil
-}
-
 func (bm *BucketManager) ListBuckets(input string) []string {
 	bm.mutex.RLock()
 	defer bm.mutex.RUnlock()
@@ -91,36 +145,38 @@ func (bm *BucketManager) ListBuckets(input string) []string {
 	return bucketNames
 }
 
-func (bm *BucketManager) ExitBucket() error {
-	bm.mutex.Lock()
-	defer bm.mutex.Unlock()
-
-	if bm.isActive == nil {
-		return fmt.Errorf("no active bucket to exit")
-	}
-	bm.isActive = nil
-	return nil
-}
-
+// DropBucket moves a bucket to the trash, from where Undrop can bring it back
+// until the trash retention runs out.
 func (bm *BucketManager) DropBucket(name string) error {
 	bm.mutex.Lock()
 	defer bm.mutex.Unlock()
 
 	bucket, exists := bm.Buckets[name]
 	if !exists {
-		return fmt.Errorf("bucket %s does not exist", name)
+		return bm.missing(name)
+	}
+	if bucket.Options.ReadOnly {
+		return fmt.Errorf("bucket %s is frozen, unfreeze it before dropping", name)
+	}
+	if bucket.Options.Protected {
+		return fmt.Errorf("bucket %s is protected, clear it with 'alter bucket %s set protected=false' before dropping", name, name)
 	}
 
 	if err := bucket.Close(); err != nil {
 		return err
 	}
 
-	bucketDir := filepath.Join(bm.BaseDir, name)
-	if err := os.RemoveAll(bucketDir); err != nil {
+	if err := bm.moveToTrash(bucket); err != nil {
+		// still on disk where it was, keep serving it
+		if reopened, rerr := NewBucket(name, filepath.Join(bm.BaseDir, name), bucket.Options); rerr == nil {
+			bm.Buckets[name] = reopened
+		}
 		return err
 	}
 
 	delete(bm.Buckets, name)
-	bm.SaveMetaData()
+	if err := bm.SaveMetaData(); err != nil {
+		return fmt.Errorf("bucket dropped but metadata not saved: %v", err)
+	}
 	return nil
 }
@@ -1,103 +1,177 @@
 package bucket
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"byted/DB_engine/constants"
-	"byted/DB_engine/core/kv"
 )
 
 type BucketManager struct {
 	BaseDir  string
 	Buckets  map[string]*Bucket
-	mutex    sync.RWMutex
-	isActive *Bucket
+	Defaults Options // options for buckets created or loaded without their own
+
+	TrashRetention time.Duration // dropped buckets older than this are purged, 0 = never
+
+	metaPath     string
+	mutex        sync.RWMutex
+	stopUnloader chan struct{} // closed by Close to stop StartIdleUnloader
+
+	// catalog entries whose bucket failed to open, written back as they were
+	unavailable map[string]unavailableBucket
 }
 
-type MetaData struct {
-	Buckets      []string `json:"buckets"`
-	ActiveBucket string   `json:"active_bucket"`
+type unavailableBucket struct {
+	meta   BucketMeta
+	reason string
 }
 
-func (bm *BucketManager) LoadMetaData() error {
+// NewBucketManager registers every bucket under baseDir; the catalog lives next to
+// baseDir, in its parent data directory.
+func NewBucketManager(baseDir string, defaults Options) (*BucketManager, error) {
 
-	metaPath := constants.GLOBALMETAPATH
-	if _, err := os.Stat(metaPath); os.IsNotExist(err) {
-		// No metadata file exists, nothing to load
-		return nil
+	if err := os.MkdirAll(baseDir, constants.OWNERPERMISSION); err != nil {
+		return nil, err
 	}
 
-	data, err := os.ReadFile(metaPath)
-	if err != nil {
-		return nil
+	bm := &BucketManager{
+		BaseDir:  baseDir,
+		Buckets:  make(map[string]*Bucket),
+		Defaults: defaults.withDefaults(DefaultOptions()),
+		metaPath: filepath.Join(filepath.Dir(baseDir), constants.METABUCKETFILE),
+
+		TrashRetention: constants.DEFAULTTRASHRETENTION,
+		unavailable:    make(map[string]unavailableBucket),
 	}
 
-	var meta MetaData
-	if err := json.Unmarshal(data, &meta); err != nil {
-		return err
+	if err := bm.LoadMetaData(); err != nil {
+		return nil, err
 	}
+	return bm, nil
+}
+
+// Close flushes and closes every open bucket and writes the metadata one last
+// time. The manager must not be used afterwards.
+func (bm *BucketManager) Close() error {
 	bm.mutex.Lock()
-	for _, bucketName := range meta.Buckets {
-		bucketDir := filepath.Join(bm.BaseDir, bucketName)
-		walPath := filepath.Join(bucketDir, bucketName+constants.WALFILENAME)
+	defer bm.mutex.Unlock()
 
-		kvEngine, err := kv.NewKVEngine(walPath, constants.DEFAULTREEORDER)
-		if err != nil {
-			fmt.Printf("failed to load bucket %s: %v\n", bucketName, err)
-			continue
+	if bm.stopUnloader != nil {
+		close(bm.stopUnloader)
+		bm.stopUnloader = nil
+	}
+	var firstErr error
+	for name, bucket := range bm.Buckets {
+		if err := bucket.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to close bucket %s: %v", name, err)
 		}
+	}
+	if err := bm.SaveMetaData(); err != nil && firstErr == nil {
+		firstErr = fmt.Errorf("failed to write metadata: %v", err)
+	}
+	return firstErr
+}
 
-		kvEngine.ReplayWAL()
+// StartIdleUnloader unloads buckets nobody has used for idle, checking every
+// idle/2, until the manager is closed. Calling it again while it runs does
+// nothing.
+func (bm *BucketManager) StartIdleUnloader(idle time.Duration) {
+	stop := make(chan struct{})
+	bm.mutex.Lock()
+	if bm.stopUnloader != nil {
+		// already running
+		bm.mutex.Unlock()
+		return
+	}
+	bm.stopUnloader = stop
+	bm.mutex.Unlock()
 
-		bucket := &Bucket{
-			Name:     bucketName,
-			KvEngine: kvEngine,
+	go func() {
+		ticker := time.NewTicker(idle / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				bm.UnloadIdle(idle)
+			}
 		}
-		bm.Buckets[bucketName] = bucket
+	}()
+}
 
+// UnloadIdle unloads every loaded, unpinned bucket that has not been used for
+// idle and returns their names. An unloaded bucket loads again on next use.
+func (bm *BucketManager) UnloadIdle(idle time.Duration) []string {
+	bm.mutex.RLock()
+	defer bm.mutex.RUnlock()
+
+	var unloaded []string
+	cutoff := time.Now().Add(-idle)
+	for name, bucket := range bm.Buckets {
+		if bucket.Options.Pinned || !bucket.Loaded() || bucket.KvEngine.IdleSince().After(cutoff) {
+			continue
+		}
+		if err := bucket.KvEngine.Unload(); err != nil {
+			fmt.Printf("failed to unload bucket %s: %v\n", name, err)
+			continue
+		}
+		unloaded = append(unloaded, name)
 	}
-	bm.mutex.Unlock()
-	return nil
+	return unloaded
 }
 
-func (bm *BucketManager) SaveMetaData() error {
-	meta := MetaData{
-		Buckets:      make([]string, 0, len(bm.Buckets)),
-		ActiveBucket: "",
-	}
+// PinBucket keeps a bucket loaded for good, or lets it be unloaded again.
+func (bm *BucketManager) PinBucket(name string, pinned bool) error {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
 
-	for bucketName := range bm.Buckets {
-		meta.Buckets = append(meta.Buckets, bucketName)
+	bucket, exists := bm.Buckets[name]
+	if !exists {
+		return bm.missing(name)
 	}
-
-	if bm.isActive != nil {
-		meta.ActiveBucket = bm.isActive.Name
+	if pinned {
+		if err := bucket.KvEngine.Load(); err != nil {
+			return err
+		}
 	}
-
-	data, err := json.MarshalIndent(meta, "", "  ")
-	if err != nil {
-		return err
+	bucket.Options.Pinned = pinned
+	if err := bm.SaveMetaData(); err != nil {
+		return fmt.Errorf("pin changed but metadata not saved: %v", err)
 	}
-
-	metaPath := constants.GLOBALMETAPATH
-	return os.WriteFile(metaPath, data, constants.OWNERPERMISSION)
+	return nil
 }
 
-func NewBucketManager(baseDir string) (*BucketManager, error) {
+// Unavailable returns the buckets of the catalog that failed to open, with
+// the reason.
+func (bm *BucketManager) Unavailable() map[string]string {
+	bm.mutex.RLock()
+	defer bm.mutex.RUnlock()
 
-	if err := os.MkdirAll(baseDir, constants.OWNERPERMISSION); err != nil {
-		return nil, err
+	reasons := make(map[string]string, len(bm.unavailable))
+	for name, u := range bm.unavailable {
+		reasons[name] = u.reason
 	}
+	return reasons
+}
 
-	bm := &BucketManager{
-		BaseDir: baseDir,
-		Buckets: make(map[string]*Bucket),
+// missing is the error for a name that is not an open bucket. Callers hold
+// bm.mutex.
+func (bm *BucketManager) missing(name string) error {
+	if u, ok := bm.unavailable[name]; ok {
+		return unavailable(name, u.reason)
 	}
+	return noSuchBucket(name)
+}
 
-	bm.LoadMetaData()
-	return bm, nil
+// nameInUse reports whether name belongs to a bucket of the catalog, open or
+// not. Callers hold bm.mutex.
+func (bm *BucketManager) nameInUse(name string) bool {
+	_, open := bm.Buckets[name]
+	_, failed := bm.unavailable[name]
+	return open || failed
 }
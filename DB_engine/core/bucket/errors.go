@@ -0,0 +1,36 @@
+package bucket
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errors.Is matches these against the errors returned for a missing or
+// already existing bucket, or one in the catalog that failed to open.
+var (
+	ErrNoSuchBucket = errors.New("bucket does not exist")
+	ErrBucketExists = errors.New("bucket already exists")
+	ErrUnavailable  = errors.New("bucket is unavailable")
+	ErrInvalidName  = errors.New("invalid bucket name")
+)
+
+// kindError is an error with its own message that still matches its kind.
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+func noSuchBucket(name string) error {
+	return &kindError{kind: ErrNoSuchBucket, msg: fmt.Sprintf("bucket %s does not exist", name)}
+}
+
+func bucketExists(name string) error {
+	return &kindError{kind: ErrBucketExists, msg: fmt.Sprintf("bucket %s already exists", name)}
+}
+
+func unavailable(name, reason string) error {
+	return &kindError{kind: ErrUnavailable, msg: fmt.Sprintf("bucket %s is unavailable: %s", name, reason)}
+}
@@ -0,0 +1,95 @@
+package bucket
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"byted/DB_engine/constants"
+)
+
+// FreezeBucket makes a bucket read-only and records it in the metadata. With
+// onDisk the bucket directory and WAL also lose their write permission, so
+// offline tools cannot modify it either.
+func (bm *BucketManager) FreezeBucket(name string, onDisk bool) error {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	bucket, exists := bm.Buckets[name]
+	if !exists {
+		return bm.missing(name)
+	}
+
+	previous := bucket.Options
+	bucket.Options.ReadOnly = true
+	bucket.KvEngine.SetReadOnly(true)
+
+	if onDisk && !previous.ReadOnlyOnDisk {
+		if err := bm.setDiskPermissions(name, true); err != nil {
+			bm.setDiskPermissions(name, false)
+			bucket.Options = previous
+			bucket.KvEngine.SetReadOnly(previous.ReadOnly)
+			return err
+		}
+		// an unloaded bucket must not try to open the WAL for writing when it loads
+		if err := bucket.KvEngine.ReopenWAL(true); err != nil {
+			return fmt.Errorf("failed to reopen WAL read-only: %v", err)
+		}
+		bucket.Options.ReadOnlyOnDisk = true
+	}
+
+	if err := bm.SaveMetaData(); err != nil {
+		return fmt.Errorf("bucket frozen but metadata not saved: %v", err)
+	}
+	return nil
+}
+
+// UnfreezeBucket restores write access, on disk too if it was removed.
+func (bm *BucketManager) UnfreezeBucket(name string) error {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	bucket, exists := bm.Buckets[name]
+	if !exists {
+		return bm.missing(name)
+	}
+	if !bucket.Options.ReadOnly {
+		return fmt.Errorf("bucket %s is not frozen", name)
+	}
+
+	if bucket.Options.ReadOnlyOnDisk {
+		if err := bm.setDiskPermissions(name, false); err != nil {
+			return err
+		}
+		// the WAL may have been opened without write access at startup
+		if err := bucket.KvEngine.ReopenWAL(false); err != nil {
+			return fmt.Errorf("failed to reopen WAL for writing: %v", err)
+		}
+		bucket.Options.ReadOnlyOnDisk = false
+	}
+	bucket.Options.ReadOnly = false
+	bucket.KvEngine.SetReadOnly(false)
+
+	if err := bm.SaveMetaData(); err != nil {
+		return fmt.Errorf("bucket unfrozen but metadata not saved: %v", err)
+	}
+	return nil
+}
+
+// setDiskPermissions removes or restores write permission on a bucket's files.
+func (bm *BucketManager) setDiskPermissions(name string, readOnly bool) error {
+	bucketDir := filepath.Join(bm.BaseDir, name)
+	walPath := filepath.Join(bucketDir, name+constants.WALFILENAME)
+
+	dirMode, fileMode := os.FileMode(constants.OWNERPERMISSION), os.FileMode(0644)
+	if readOnly {
+		dirMode, fileMode = constants.REMOVEWRITEPERMISSION, 0444
+	}
+	if err := os.Chmod(walPath, fileMode); err != nil {
+		return fmt.Errorf("failed to change WAL permissions: %v", err)
+	}
+	if err := os.Chmod(bucketDir, dirMode); err != nil {
+		return fmt.Errorf("failed to change bucket directory permissions: %v", err)
+	}
+	return nil
+}
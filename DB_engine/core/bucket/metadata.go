@@ -0,0 +1,280 @@
+package bucket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"byted/DB_engine/constants"
+	"byted/DB_engine/core/fsutil"
+)
+
+// MetaVersion is the catalog format written by this build.
+//
+//	0: {"buckets": ["name", ...], "options": {"name": {...}}} (no version field)
+//	1: {"version": 1, "buckets": [{"name": "...", "options": {...}}, ...]}
+const MetaVersion = 1
+
+// MetaData is the on-disk bucket catalog.
+type MetaData struct {
+	Version int          `json:"version"`
+	Buckets []BucketMeta `json:"buckets"`
+}
+
+// BucketMeta is one bucket's entry in the catalog.
+type BucketMeta struct {
+	Name    string  `json:"name"`
+	Options Options `json:"options"`
+
+	// set while a rename has not finished moving the files from the old name
+	RenamedFrom string `json:"renamed_from,omitempty"`
+}
+
+// metaDataV0 is the catalog before versioning.
+type metaDataV0 struct {
+	Buckets []string           `json:"buckets"`
+	Options map[string]Options `json:"options,omitempty"`
+}
+
+// migrations[v] upgrades a catalog of version v, given as raw JSON, to v+1.
+var migrations = map[int]func(data []byte) ([]byte, error){
+	0: migrateV0,
+}
+
+func migrateV0(data []byte) ([]byte, error) {
+	var old metaDataV0
+	if err := json.Unmarshal(data, &old); err != nil {
+		return nil, err
+	}
+	meta := MetaData{Version: 1, Buckets: make([]BucketMeta, 0, len(old.Buckets))}
+	for _, name := range old.Buckets {
+		meta.Buckets = append(meta.Buckets, BucketMeta{Name: name, Options: old.Options[name]})
+	}
+	return json.Marshal(meta)
+}
+
+// errCorruptMeta marks a catalog that cannot be parsed at all.
+var errCorruptMeta = errors.New("bucket metadata is corrupt")
+
+// readMetaData parses the catalog, upgrading older versions. migrated is set
+// when the file on disk is in an older format and should be rewritten.
+func readMetaData(path string) (meta *MetaData, migrated bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var probe struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errCorruptMeta, err)
+	}
+	if probe.Version > MetaVersion {
+		return nil, false, fmt.Errorf("bucket metadata version %d is newer than this server supports (%d)", probe.Version, MetaVersion)
+	}
+
+	for v := probe.Version; v < MetaVersion; v++ {
+		migrate, ok := migrations[v]
+		if !ok {
+			return nil, false, fmt.Errorf("no migration from bucket metadata version %d", v)
+		}
+		if data, err = migrate(data); err != nil {
+			return nil, false, fmt.Errorf("%w: migrating from version %d: %v", errCorruptMeta, v, err)
+		}
+		migrated = true
+	}
+
+	meta = &MetaData{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errCorruptMeta, err)
+	}
+	return meta, migrated, nil
+}
+
+// LoadMetaData registers every bucket in the catalog without loading any of
+// them, see RecoverBuckets. A missing or unreadable catalog is rebuilt by scanning
+// the bucket directories.
+func (bm *BucketManager) LoadMetaData() error {
+	meta, migrated, err := readMetaData(bm.metaPath)
+	rebuilt := false
+
+	switch {
+	case err == nil:
+		if migrated {
+			// keep the old file around in case the upgrade needs to be undone
+			backup := bm.metaPath + ".bak"
+			if data, err := os.ReadFile(bm.metaPath); err == nil {
+				os.WriteFile(backup, data, 0644)
+			}
+			fmt.Printf("Bucket metadata upgraded to version %d (previous file kept as %s)\n", MetaVersion, backup)
+		}
+
+	case os.IsNotExist(err):
+		meta, err = bm.scanBucketDirs()
+		if err != nil {
+			return err
+		}
+		rebuilt = len(meta.Buckets) > 0
+		if rebuilt {
+			fmt.Printf("Bucket metadata missing, rebuilt the catalog from %d bucket directories\n", len(meta.Buckets))
+		}
+
+	case errors.Is(err, errCorruptMeta):
+		corrupt := fmt.Sprintf("%s.corrupt-%d", bm.metaPath, time.Now().Unix())
+		if rerr := os.Rename(bm.metaPath, corrupt); rerr != nil {
+			return fmt.Errorf("%v (and failed to move it aside: %v)", err, rerr)
+		}
+		fmt.Printf("%v, moved to %s and rebuilding from bucket directories\n", err, corrupt)
+		if meta, err = bm.scanBucketDirs(); err != nil {
+			return err
+		}
+		rebuilt = true
+
+	default:
+		return fmt.Errorf("failed to read bucket metadata: %v", err)
+	}
+
+	bm.removeStaleClones()
+	trashed := make(map[string]bool)
+	if entries, err := bm.ListTrash(); err == nil {
+		for _, entry := range entries {
+			trashed[entry.Name] = true
+		}
+	}
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	for _, entry := range meta.Buckets {
+		if entry.RenamedFrom != "" {
+			// interrupted rename, the catalog already has the new name
+			if err := bm.moveBucketFiles(entry.RenamedFrom, entry.Name); err != nil {
+				fmt.Printf("failed to finish renaming bucket %s to %s: %v\n", entry.RenamedFrom, entry.Name, err)
+				bm.unavailable[entry.Name] = unavailableBucket{meta: entry, reason: fmt.Sprintf("rename from %s not finished: %v", entry.RenamedFrom, err)}
+				continue
+			}
+			fmt.Printf("Finished renaming bucket %s to %s\n", entry.RenamedFrom, entry.Name)
+			rebuilt = true
+		}
+		bucketDir := filepath.Join(bm.BaseDir, entry.Name)
+		if _, err := os.Stat(bucketDir); os.IsNotExist(err) && trashed[entry.Name] {
+			// dropped, but the catalog was not saved before a crash
+			fmt.Printf("Bucket %s is in the trash, removing it from the catalog\n", entry.Name)
+			rebuilt = true
+			continue
+		}
+
+		walPath := filepath.Join(bucketDir, entry.Name+constants.WALFILENAME)
+		if _, err := os.Stat(walPath); err != nil {
+			fmt.Printf("failed to load bucket %s: %v\n", entry.Name, err)
+			bm.unavailable[entry.Name] = unavailableBucket{meta: entry, reason: err.Error()}
+			continue
+		}
+
+		// loaded by RecoverBuckets or on first use
+		bucket, err := newLazyBucket(entry.Name, bucketDir, entry.Options.withDefaults(bm.Defaults))
+		if err != nil {
+			fmt.Printf("failed to load bucket %s: %v\n", entry.Name, err)
+			bm.unavailable[entry.Name] = unavailableBucket{meta: entry, reason: err.Error()}
+			continue
+		}
+		bm.Buckets[entry.Name] = bucket
+	}
+
+	if migrated || rebuilt {
+		return bm.SaveMetaData()
+	}
+	return nil
+}
+
+// SaveMetaData atomically replaces the catalog. Callers hold bm.mutex.
+func (bm *BucketManager) SaveMetaData() error {
+	data, err := bm.catalogJSON()
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(bm.metaPath, data, 0644)
+}
+
+// catalogJSON is the catalog as SaveMetaData writes it. Callers hold bm.mutex.
+func (bm *BucketManager) catalogJSON() ([]byte, error) {
+	meta := MetaData{
+		Version: MetaVersion,
+		Buckets: make([]BucketMeta, 0, len(bm.Buckets)+len(bm.unavailable)),
+	}
+	for bucketName, bucket := range bm.Buckets {
+		meta.Buckets = append(meta.Buckets, BucketMeta{Name: bucketName, Options: bucket.Options, RenamedFrom: bucket.renamedFrom})
+	}
+	// kept as they were so a later start can open them again
+	for _, u := range bm.unavailable {
+		meta.Buckets = append(meta.Buckets, u.meta)
+	}
+	sort.Slice(meta.Buckets, func(i, j int) bool { return meta.Buckets[i].Name < meta.Buckets[j].Name })
+
+	return json.MarshalIndent(meta, "", "  ")
+}
+
+// ReadCatalog reads the catalog at path without opening any bucket, for
+// offline tools. Older formats are upgraded in memory only.
+func ReadCatalog(path string) (*MetaData, error) {
+	meta, _, err := readMetaData(path)
+	return meta, err
+}
+
+// scanBucketDirs finds every directory under BaseDir holding a bucket WAL.
+// Options cannot be recovered this way, so the defaults are used.
+func (bm *BucketManager) scanBucketDirs() (*MetaData, error) {
+	entries, err := os.ReadDir(bm.BaseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %v", bm.BaseDir, err)
+	}
+
+	meta := &MetaData{Version: MetaVersion}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		walPath := filepath.Join(bm.BaseDir, entry.Name(), entry.Name()+constants.WALFILENAME)
+		if _, err := os.Stat(walPath); err != nil {
+			continue
+		}
+		meta.Buckets = append(meta.Buckets, BucketMeta{Name: entry.Name(), Options: bm.Defaults})
+	}
+	return meta, nil
+}
+
+// RebuildCatalog adds buckets found on disk but missing from the catalog and
+// returns their names.
+func (bm *BucketManager) RebuildCatalog() ([]string, error) {
+	found, err := bm.scanBucketDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	var added []string
+	for _, entry := range found.Buckets {
+		if bm.nameInUse(entry.Name) {
+			continue
+		}
+		bucket, err := NewBucket(entry.Name, filepath.Join(bm.BaseDir, entry.Name), entry.Options)
+		if err != nil {
+			return added, fmt.Errorf("failed to open bucket %s: %v", entry.Name, err)
+		}
+		bm.Buckets[entry.Name] = bucket
+		added = append(added, entry.Name)
+	}
+	if len(added) > 0 {
+		if err := bm.SaveMetaData(); err != nil {
+			return added, err
+		}
+	}
+	return added, nil
+}
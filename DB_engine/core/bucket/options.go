@@ -0,0 +1,203 @@
+package bucket
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"byted/DB_engine/constants"
+	"byted/DB_engine/core/kv"
+)
+
+// Options are the per-bucket settings, persisted in the bucket metadata.
+type Options struct {
+	Order        int    `json:"order"`          // B+ tree order
+	Durability   string `json:"durability"`     // "sync" or "none"
+	DefaultTTL   int64  `json:"default_ttl"`    // seconds, 0 = keys never expire
+	MaxValueSize int    `json:"max_value_size"` // bytes, 0 = unlimited
+	Compression  string `json:"compression"`    // "none" or "deflate"
+	ReadOnly     bool   `json:"read_only"`
+	Protected    bool   `json:"protected"` // drop is refused while set
+	Pinned       bool   `json:"pinned"`    // loaded at startup and never unloaded when idle
+
+	// set by `freeze --chmod`: the bucket directory and WAL have no write permission
+	ReadOnlyOnDisk bool `json:"read_only_on_disk,omitempty"`
+}
+
+// DefaultOptions are used when the server configuration does not say otherwise.
+func DefaultOptions() Options {
+	return Options{Order: constants.DEFAULTREEORDER, Durability: "sync", Compression: kv.CompressionNone}
+}
+
+// Validate rejects options a bucket cannot be opened with.
+func (o Options) Validate() error {
+	if o.Order < 3 {
+		return fmt.Errorf("order must be at least 3, got %d", o.Order)
+	}
+	if o.Durability != "sync" && o.Durability != "none" {
+		return fmt.Errorf("durability must be 'sync' or 'none', got '%s'", o.Durability)
+	}
+	if o.DefaultTTL < 0 || o.MaxValueSize < 0 {
+		return fmt.Errorf("ttl and max_value_size must not be negative")
+	}
+	if o.Compression != kv.CompressionNone && o.Compression != kv.CompressionDeflate {
+		return fmt.Errorf("compression must be '%s' or '%s', got '%s'", kv.CompressionNone, kv.CompressionDeflate, o.Compression)
+	}
+	return nil
+}
+
+// withDefaults fills fields missing from older metadata.
+func (o Options) withDefaults(defaults Options) Options {
+	if o.Order == 0 {
+		o.Order = defaults.Order
+	}
+	if o.Durability == "" {
+		o.Durability = defaults.Durability
+	}
+	if o.Compression == "" {
+		o.Compression = defaults.Compression
+	}
+	if o.Compression == "" {
+		o.Compression = kv.CompressionNone
+	}
+	return o
+}
+
+// ParseOptions applies "key=value" pairs on top of base.
+//
+//	order=<n> durability=sync|none ttl=<seconds|duration> max_value_size=<bytes>
+//	compression=none|deflate read_only=true|false protected=true|false
+func ParseOptions(base Options, pairs []string) (Options, error) {
+	opts := base
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" || value == "" {
+			return base, fmt.Errorf("invalid option '%s', expected key=value", pair)
+		}
+
+		var err error
+		switch strings.ToLower(key) {
+		case "order":
+			opts.Order, err = strconv.Atoi(value)
+		case "durability":
+			opts.Durability = value
+		case "ttl", "default_ttl":
+			opts.DefaultTTL, err = parseSeconds(value)
+		case "max_value_size":
+			opts.MaxValueSize, err = strconv.Atoi(value)
+		case "compression":
+			opts.Compression = value
+		case "read_only", "readonly":
+			opts.ReadOnly, err = strconv.ParseBool(value)
+		case "protected":
+			opts.Protected, err = strconv.ParseBool(value)
+		default:
+			return base, fmt.Errorf("unknown option '%s'", key)
+		}
+		if err != nil {
+			return base, fmt.Errorf("invalid value for %s: '%s'", key, value)
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return base, err
+	}
+	return opts, nil
+}
+
+// parseSeconds accepts plain seconds ("3600") or a duration ("1h").
+func parseSeconds(value string) (int64, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	return int64(d / time.Second), nil
+}
+
+// Describe lists the options, one per line.
+func (o Options) Describe() []string {
+	ttl := "none"
+	if o.DefaultTTL > 0 {
+		ttl = (time.Duration(o.DefaultTTL) * time.Second).String()
+	}
+	maxValue := "unlimited"
+	if o.MaxValueSize > 0 {
+		maxValue = fmt.Sprintf("%d bytes", o.MaxValueSize)
+	}
+	return []string{
+		fmt.Sprintf("  order          : %d", o.Order),
+		fmt.Sprintf("  durability     : %s", o.Durability),
+		fmt.Sprintf("  default ttl    : %s", ttl),
+		fmt.Sprintf("  max value size : %s", maxValue),
+		fmt.Sprintf("  compression    : %s", o.Compression),
+		fmt.Sprintf("  read only      : %t%s", o.ReadOnly, onDisk(o)),
+		fmt.Sprintf("  protected      : %t", o.Protected),
+		fmt.Sprintf("  pinned         : %t", o.Pinned),
+	}
+}
+
+// Pairs lists the options as the key=value pairs ParseOptions takes.
+func (o Options) Pairs() []string {
+	return []string{
+		fmt.Sprintf("order=%d", o.Order),
+		fmt.Sprintf("durability=%s", o.Durability),
+		fmt.Sprintf("ttl=%d", o.DefaultTTL),
+		fmt.Sprintf("max_value_size=%d", o.MaxValueSize),
+		fmt.Sprintf("compression=%s", o.Compression),
+		fmt.Sprintf("read_only=%t", o.ReadOnly),
+		fmt.Sprintf("protected=%t", o.Protected),
+	}
+}
+
+func onDisk(o Options) string {
+	if o.ReadOnlyOnDisk {
+		return " (files write-protected)"
+	}
+	return ""
+}
+
+// applyOptions pushes the bucket's options down to its engine.
+func (b *Bucket) applyOptions() error {
+	if err := b.KvEngine.SetCompression(b.Options.Compression); err != nil {
+		return err
+	}
+	b.KvEngine.SetOrder(b.Options.Order)
+	b.KvEngine.SetSyncWrites(b.Options.Durability != "none")
+	b.KvEngine.SetMaxValueSize(b.Options.MaxValueSize)
+	b.KvEngine.SetDefaultTTL(time.Duration(b.Options.DefaultTTL) * time.Second)
+	b.KvEngine.SetReadOnly(b.Options.ReadOnly)
+	return nil
+}
+
+// AlterBucket applies "key=value" changes to a bucket and persists them.
+func (bm *BucketManager) AlterBucket(name string, pairs []string) (Options, error) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	bucket, exists := bm.Buckets[name]
+	if !exists {
+		return Options{}, bm.missing(name)
+	}
+	opts, err := ParseOptions(bucket.Options, pairs)
+	if err != nil {
+		return Options{}, err
+	}
+	if !opts.ReadOnly && opts.ReadOnlyOnDisk {
+		return Options{}, fmt.Errorf("bucket %s is frozen on disk, use 'unfreeze %s'", name, name)
+	}
+
+	previous := bucket.Options
+	bucket.Options = opts
+	if err := bucket.applyOptions(); err != nil {
+		bucket.Options = previous
+		bucket.applyOptions()
+		return Options{}, err
+	}
+	if err := bm.SaveMetaData(); err != nil {
+		return opts, fmt.Errorf("options applied but metadata not saved: %v", err)
+	}
+	return opts, nil
+}
@@ -0,0 +1,84 @@
+package bucket
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RecoverySummary describes a startup recovery.
+type RecoverySummary struct {
+	Buckets int
+	Records int
+	Bytes   int64
+	Elapsed time.Duration
+	Failed  []string // buckets whose WAL could not be replayed
+}
+
+// RecoverBuckets loads the buckets that belong in memory at startup: the
+// pinned ones, or every bucket when all is set. Up to workers buckets are
+// replayed at once (0 = one per CPU). progress, if not nil, is called after
+// each bucket with the number done so far and the total.
+func (bm *BucketManager) RecoverBuckets(workers int, all bool, progress func(done, total int)) RecoverySummary {
+	start := time.Now()
+
+	bm.mutex.RLock()
+	var pending []*Bucket
+	for _, bucket := range bm.Buckets {
+		if all || bucket.Options.Pinned {
+			pending = append(pending, bucket)
+		}
+	}
+	bm.mutex.RUnlock()
+
+	// handed out in name order, so the log reads predictably
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Name < pending[j].Name })
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(pending) {
+		workers = len(pending)
+	}
+
+	var summary RecoverySummary
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan *Bucket)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for bucket := range jobs {
+				err := bucket.KvEngine.Load()
+				stats := bucket.KvEngine.LastLoad()
+
+				mutex.Lock()
+				if err != nil {
+					fmt.Printf("failed to recover bucket %s: %v\n", bucket.Name, err)
+					summary.Failed = append(summary.Failed, bucket.Name)
+				} else {
+					summary.Records += stats.Records
+					summary.Bytes += stats.Bytes
+				}
+				summary.Buckets++
+				if progress != nil {
+					progress(summary.Buckets, len(pending))
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, bucket := range pending {
+		jobs <- bucket
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Strings(summary.Failed)
+	summary.Elapsed = time.Since(start)
+	return summary
+}
@@ -0,0 +1,194 @@
+package bucket
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"byted/DB_engine/constants"
+	"byted/DB_engine/core/fsutil"
+	"byted/DB_engine/core/kv"
+)
+
+// cloneStagingPrefix marks directories a clone is still being written to.
+const cloneStagingPrefix = ".clone-"
+
+// RenameBucket gives a bucket a new name, moving its directory and WAL.
+//
+// The catalog is the commit point: it is rewritten first, naming the bucket
+// newName and remembering the old name, and only then are the files moved.
+// A crash in between is finished by LoadMetaData on the next start.
+func (bm *BucketManager) RenameBucket(oldName, newName string) error {
+	if err := ValidateName(newName); err != nil {
+		return err
+	}
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	bucket, exists := bm.Buckets[oldName]
+	if !exists {
+		return bm.missing(oldName)
+	}
+	if bm.nameInUse(newName) {
+		return bucketExists(newName)
+	}
+	if bucket.Options.ReadOnly {
+		return fmt.Errorf("bucket %s is frozen, unfreeze it before renaming", oldName)
+	}
+	newDir := filepath.Join(bm.BaseDir, newName)
+	if _, err := os.Stat(newDir); err == nil {
+		return fmt.Errorf("directory %s already exists", newDir)
+	}
+
+	if err := bucket.Close(); err != nil {
+		return err
+	}
+	// puts the bucket back under its old name after a failure
+	restore := func(cause error) error {
+		delete(bm.Buckets, newName)
+		reopened, err := NewBucket(oldName, filepath.Join(bm.BaseDir, oldName), bucket.Options)
+		if err != nil {
+			return fmt.Errorf("%v (and failed to reopen bucket %s: %v)", cause, oldName, err)
+		}
+		bm.Buckets[oldName] = reopened
+		if err := bm.SaveMetaData(); err != nil {
+			return fmt.Errorf("%v (and failed to restore metadata: %v)", cause, err)
+		}
+		return cause
+	}
+
+	delete(bm.Buckets, oldName)
+	bm.Buckets[newName] = &Bucket{Name: newName, KvEngine: bucket.KvEngine, Options: bucket.Options, renamedFrom: oldName}
+	if err := bm.SaveMetaData(); err != nil {
+		return restore(fmt.Errorf("failed to save bucket metadata: %v", err))
+	}
+
+	if err := bm.moveBucketFiles(oldName, newName); err != nil {
+		return restore(err)
+	}
+
+	renamed, err := NewBucket(newName, newDir, bucket.Options)
+	if err != nil {
+		delete(bm.Buckets, newName)
+		return fmt.Errorf("bucket renamed but failed to reopen: %v", err)
+	}
+	bm.Buckets[newName] = renamed
+	if err := bm.SaveMetaData(); err != nil {
+		return fmt.Errorf("bucket renamed but metadata not saved: %v", err)
+	}
+	return nil
+}
+
+// moveBucketFiles renames <from>/<from>wal.log to <to>/<to>wal.log. It either
+// moves both or neither, and does nothing if the move already happened.
+func (bm *BucketManager) moveBucketFiles(from, to string) error {
+	fromDir := filepath.Join(bm.BaseDir, from)
+	toDir := filepath.Join(bm.BaseDir, to)
+
+	if _, err := os.Stat(fromDir); os.IsNotExist(err) {
+		if _, err := os.Stat(toDir); err == nil {
+			return nil
+		}
+		return fmt.Errorf("bucket directory %s is missing", fromDir)
+	}
+
+	oldWAL := filepath.Join(fromDir, from+constants.WALFILENAME)
+	newWAL := filepath.Join(fromDir, to+constants.WALFILENAME)
+	if _, err := os.Stat(oldWAL); err == nil {
+		if err := os.Rename(oldWAL, newWAL); err != nil {
+			return fmt.Errorf("failed to rename WAL: %v", err)
+		}
+	}
+	if err := os.Rename(fromDir, toDir); err != nil {
+		os.Rename(newWAL, oldWAL)
+		return fmt.Errorf("failed to rename bucket directory: %v", err)
+	}
+	return fsutil.SyncDir(bm.BaseDir)
+}
+
+// CloneBucket creates dst as an independent copy of src, as of LSN asOf or
+// of its current state if asOf is 0. It returns the LSN the copy reflects and
+// the number of keys copied. The source is only locked long enough to take
+// the snapshot; the copy is written to a staging directory that is renamed
+// into place at the end.
+func (bm *BucketManager) CloneBucket(src, dst string, asOf uint64) (uint64, int, error) {
+	if err := ValidateName(dst); err != nil {
+		return 0, 0, err
+	}
+
+	bm.mutex.RLock()
+	source, exists := bm.Buckets[src]
+	srcErr := bm.missing(src)
+	dstExists := bm.nameInUse(dst)
+	bm.mutex.RUnlock()
+
+	if !exists {
+		return 0, 0, srcErr
+	}
+	if dstExists {
+		return 0, 0, bucketExists(dst)
+	}
+
+	entries, lsn, err := source.KvEngine.Snapshot(asOf)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// a clone of a frozen bucket is writable
+	opts := source.Options
+	opts.ReadOnly = false
+	opts.ReadOnlyOnDisk = false
+
+	staging := filepath.Join(bm.BaseDir, fmt.Sprintf("%s%s-%d", cloneStagingPrefix, dst, time.Now().UnixNano()))
+	if err := os.MkdirAll(staging, constants.OWNERPERMISSION); err != nil {
+		return 0, 0, err
+	}
+	walPath := filepath.Join(staging, dst+constants.WALFILENAME)
+	if err := kv.WriteEntries(walPath, entries, opts.Compression == kv.CompressionDeflate); err != nil {
+		os.RemoveAll(staging)
+		return 0, 0, fmt.Errorf("failed to write clone: %v", err)
+	}
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	if bm.nameInUse(dst) {
+		os.RemoveAll(staging)
+		return 0, 0, bucketExists(dst)
+	}
+	dstDir := filepath.Join(bm.BaseDir, dst)
+	if err := os.Rename(staging, dstDir); err != nil {
+		os.RemoveAll(staging)
+		return 0, 0, fmt.Errorf("failed to move clone into place: %v", err)
+	}
+
+	bucket, err := NewBucket(dst, dstDir, opts)
+	if err != nil {
+		os.RemoveAll(dstDir)
+		return 0, 0, err
+	}
+	bm.Buckets[dst] = bucket
+	if err := bm.SaveMetaData(); err != nil {
+		delete(bm.Buckets, dst)
+		bucket.Close()
+		os.RemoveAll(dstDir)
+		return 0, 0, fmt.Errorf("failed to save bucket metadata: %v", err)
+	}
+	return lsn, len(entries), nil
+}
+
+// removeStaleClones deletes staging directories left by interrupted clones.
+func (bm *BucketManager) removeStaleClones() {
+	entries, err := os.ReadDir(bm.BaseDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), cloneStagingPrefix) {
+			os.RemoveAll(filepath.Join(bm.BaseDir, entry.Name()))
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package bucket
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Session is one client's view of the shared BucketManager: every connection
+// has its own active bucket, while the buckets themselves are shared.
+type Session struct {
+	*BucketManager
+
+	mutex    sync.RWMutex
+	isActive *Bucket
+
+	// Notify, when set, sends a progress message to the client while a long
+	// command is still running.
+	Notify func(message string)
+}
+
+// NewSession starts a session with no active bucket.
+func (bm *BucketManager) NewSession() *Session {
+	return &Session{BucketManager: bm}
+}
+
+func (s *Session) UseBucket(name string) (*Bucket, error) {
+	bucket, err := s.GetBucket(name)
+	if err != nil {
+		return nil, err
+	}
+	// load it now, so a broken bucket is reported here rather than on first command
+	if err := bucket.KvEngine.Load(); err != nil {
+		return nil, fmt.Errorf("failed to load bucket %s: %v", name, err)
+	}
+
+	s.mutex.Lock()
+	s.isActive = bucket
+	s.mutex.Unlock()
+	return bucket, nil
+}
+
+func (s *Session) GetActiveBucket() (*Bucket, error) {
+	s.mutex.RLock()
+	active := s.isActive
+	s.mutex.RUnlock()
+
+	if active == nil {
+		return nil, fmt.Errorf("no active bucket selected")
+	}
+
+	// another session may have dropped it in the meantime
+	if current, err := s.GetBucket(active.Name); err != nil || current != active {
+		s.mutex.Lock()
+		s.isActive = nil
+		s.mutex.Unlock()
+		return nil, fmt.Errorf("bucket %s no longer exists", active.Name)
+	}
+	return active, nil
+}
+
+func (s *Session) ExitBucket() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.isActive == nil {
+		return fmt.Errorf("no active bucket to exit")
+	}
+	s.isActive = nil
+	return nil
+}
@@ -0,0 +1,41 @@
+package bucket
+
+import (
+	"bytes"
+
+	"byted/DB_engine/core/kv"
+	"byted/DB_engine/core/transfer"
+)
+
+// Export passes the bucket's live keys to write in key order, limited to
+// [start, end] when start or end are set. It returns the number of keys and
+// the LSN the export corresponds to.
+func (b *Bucket) Export(start, end []byte, write func(transfer.Record) error) (int, uint64, error) {
+	entries, lsn, err := b.KvEngine.Snapshot(0)
+	if err != nil {
+		return 0, 0, err
+	}
+	count := 0
+	for _, e := range entries {
+		if start != nil && bytes.Compare(e.Key, start) < 0 {
+			continue
+		}
+		if end != nil && bytes.Compare(e.Key, end) > 0 {
+			break
+		}
+		if err := write(transfer.Record{Key: e.Key, Value: e.Value, ExpireAt: e.ExpireAt}); err != nil {
+			return count, lsn, err
+		}
+		count++
+	}
+	return count, lsn, nil
+}
+
+// ImportBatch writes records as one atomic batch.
+func (b *Bucket) ImportBatch(records []transfer.Record) (uint64, error) {
+	ops := make([]kv.BatchOp, len(records))
+	for i, rec := range records {
+		ops[i] = kv.BatchOp{Key: rec.Key, Value: rec.Value, ExpireAt: rec.ExpireAt}
+	}
+	return b.KvEngine.ApplyBatch(ops)
+}
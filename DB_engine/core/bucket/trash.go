@@ -0,0 +1,163 @@
+package bucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"byted/DB_engine/constants"
+	"byted/DB_engine/core/fsutil"
+)
+
+// TrashEntry is a dropped bucket waiting in the trash. Each entry is a
+// directory under <data_dir>/trash holding the bucket's files and a
+// dropped.json describing it, so the trash needs no catalog of its own.
+type TrashEntry struct {
+	Name      string    `json:"name"`
+	DroppedAt time.Time `json:"dropped_at"`
+	Options   Options   `json:"options"`
+
+	dir string // the entry's directory in the trash
+}
+
+// TrashDir is where dropped buckets are kept, next to the buckets directory.
+func (bm *BucketManager) TrashDir() string {
+	return filepath.Join(filepath.Dir(bm.BaseDir), constants.TRASHDIR)
+}
+
+// moveToTrash moves a closed bucket's directory into the trash. Callers hold bm.mutex.
+func (bm *BucketManager) moveToTrash(bucket *Bucket) error {
+	if err := os.MkdirAll(bm.TrashDir(), constants.OWNERPERMISSION); err != nil {
+		return err
+	}
+	entry := TrashEntry{Name: bucket.Name, DroppedAt: time.Now().UTC(), Options: bucket.Options}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	bucketDir := filepath.Join(bm.BaseDir, bucket.Name)
+	infoPath := filepath.Join(bucketDir, constants.TRASHINFOFILE)
+	if err := fsutil.WriteFileAtomic(infoPath, data, 0644); err != nil {
+		return err
+	}
+	trashed := filepath.Join(bm.TrashDir(), fmt.Sprintf("%s.%d", bucket.Name, entry.DroppedAt.UnixNano()))
+	if err := os.Rename(bucketDir, trashed); err != nil {
+		os.Remove(infoPath)
+		return fmt.Errorf("failed to move bucket to the trash: %v", err)
+	}
+	return fsutil.SyncDir(bm.BaseDir)
+}
+
+// ListTrash returns the buckets in the trash, most recently dropped first.
+func (bm *BucketManager) ListTrash() ([]TrashEntry, error) {
+	dirs, err := os.ReadDir(bm.TrashDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []TrashEntry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		dir := filepath.Join(bm.TrashDir(), d.Name())
+		data, err := os.ReadFile(filepath.Join(dir, constants.TRASHINFOFILE))
+		if err != nil {
+			continue
+		}
+		var entry TrashEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			fmt.Printf("skipping unreadable trash entry %s: %v\n", dir, err)
+			continue
+		}
+		entry.dir = dir
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].DroppedAt.After(entries[j].DroppedAt) })
+	return entries, nil
+}
+
+// Undrop restores the most recently dropped bucket with the given name.
+func (bm *BucketManager) Undrop(name string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	// held throughout so PurgeTrash cannot delete the entry being restored
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	entries, err := bm.ListTrash()
+	if err != nil {
+		return err
+	}
+	if bm.nameInUse(name) {
+		return fmt.Errorf("bucket %s exists, rename or drop it before restoring the dropped one", name)
+	}
+	for _, entry := range entries {
+		if entry.Name != name {
+			continue
+		}
+		bucketDir := filepath.Join(bm.BaseDir, name)
+		if err := os.Rename(entry.dir, bucketDir); err != nil {
+			return fmt.Errorf("failed to restore bucket from the trash: %v", err)
+		}
+
+		bucket, err := NewBucket(name, bucketDir, entry.Options.withDefaults(bm.Defaults))
+		if err != nil {
+			// back into the trash, with its info file, so it can be tried again
+			if rerr := os.Rename(bucketDir, entry.dir); rerr != nil {
+				return fmt.Errorf("%v (and failed to move it back to the trash: %v)", err, rerr)
+			}
+			return err
+		}
+		os.Remove(filepath.Join(bucketDir, constants.TRASHINFOFILE))
+		bm.Buckets[name] = bucket
+		if err := bm.SaveMetaData(); err != nil {
+			return fmt.Errorf("bucket restored but metadata not saved: %v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("bucket %s is not in the trash", name)
+}
+
+// PurgeTrash permanently deletes trash entries dropped more than olderThan
+// ago; name limits it to one bucket when not empty. It returns what was deleted.
+func (bm *BucketManager) PurgeTrash(name string, olderThan time.Duration) ([]TrashEntry, error) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	entries, err := bm.ListTrash()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	var purged []TrashEntry
+	for _, entry := range entries {
+		if (name != "" && entry.Name != name) || entry.DroppedAt.After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(entry.dir); err != nil {
+			return purged, fmt.Errorf("failed to purge %s: %v", entry.dir, err)
+		}
+		purged = append(purged, entry)
+	}
+	return purged, nil
+}
+
+// PurgeExpiredTrash deletes entries older than TrashRetention; a retention of
+// 0 keeps everything until it is purged by hand.
+func (bm *BucketManager) PurgeExpiredTrash() ([]TrashEntry, error) {
+	if bm.TrashRetention <= 0 {
+		return nil, nil
+	}
+	return bm.PurgeTrash("", bm.TrashRetention)
+}
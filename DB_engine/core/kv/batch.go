@@ -0,0 +1,125 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"byted/DB_engine/core/wal"
+)
+
+// BatchOp is one operation of a batch.
+type BatchOp struct {
+	Key      []byte
+	Value    []byte
+	Delete   bool
+	ExpireAt int64 // unix nanoseconds, 0 = the bucket's default TTL
+}
+
+// ApplyBatch writes ops as a single WAL record: after a crash either all of
+// them are there or none. It returns the LSN of the batch.
+func (kv *KVEngine) ApplyBatch(ops []BatchOp) (uint64, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.ensureLoaded(); err != nil {
+		return 0, err
+	}
+	if kv.readOnly {
+		return 0, ErrReadOnly
+	}
+	if len(ops) == 0 {
+		return kv.wal.LastLSN(), nil
+	}
+
+	// encode everything first so a bad op leaves nothing behind
+	metas := make([]*valueMeta, len(ops))
+	var payload []byte
+	for i, op := range ops {
+		if op.Delete {
+			payload = appendBatchOp(payload, wal.RecordDelete, op.Key, nil)
+			continue
+		}
+		before := len(payload)
+		if kv.maxValueSize > 0 && len(op.Value) > kv.maxValueSize {
+			return 0, fmt.Errorf("%w: key %q has %d bytes, over the limit of %d bytes", ErrTooLarge, op.Key, len(op.Value), kv.maxValueSize)
+		}
+		expireAt := op.ExpireAt
+		if expireAt == 0 && kv.defaultTTL > 0 {
+			expireAt = time.Now().Add(kv.defaultTTL).UnixNano()
+		}
+		if expireAt == 0 && !kv.compress {
+			payload = appendBatchOp(payload, wal.RecordPut, op.Key, op.Value)
+		} else {
+			ext, err := encodeExtValue(op.Value, expireAt, 0, kv.compress)
+			if err != nil {
+				return 0, err
+			}
+			payload = appendBatchOp(payload, wal.RecordPutExt, op.Key, ext)
+		}
+		metas[i] = &valueMeta{value: make([]byte, len(op.Value)), expireAt: expireAt, size: int64(len(payload) - before)}
+		copy(metas[i].value, op.Value)
+	}
+
+	lsn, err := kv.wal.AppendBatch(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to append batch to WAL: %w", err)
+	}
+
+	for i, op := range ops {
+		if op.Delete {
+			kv.deletes.Add(1)
+			delete(kv.pointIndex, string(op.Key))
+			kv.index.Delete(string(op.Key))
+			kv.changed(Change{Key: op.Key, Delete: true, LSN: lsn})
+			continue
+		}
+		kv.puts.Add(1)
+		metas[i].lsn = lsn
+		kv.pointIndex[string(op.Key)] = metas[i]
+		kv.index.Insert(string(op.Key), metas[i].value)
+		kv.changed(Change{Key: op.Key, Value: metas[i].value, LSN: lsn})
+	}
+	return lsn, nil
+}
+
+func appendBatchOp(buf []byte, recordType uint8, key, value []byte) []byte {
+	buf = append(buf, recordType)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(key)))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(value)))
+	buf = append(buf, key...)
+	return append(buf, value...)
+}
+
+// forEachOp calls fn with every operation in a WAL record: the operations of a
+// batch one by one, any other record as is. size is the bytes each one takes.
+func forEachOp(recordType uint8, key, value []byte, fn func(recordType uint8, key, value []byte, size int64) error) error {
+	if recordType != wal.RecordBatch {
+		return fn(recordType, key, value, wal.RecordSize(key, value))
+	}
+	for len(value) > 0 {
+		if len(value) < 1+4+4 {
+			return errors.New("truncated batch operation")
+		}
+		opType := value[0]
+		keySize := int(binary.LittleEndian.Uint32(value[1:]))
+		valueSize := int(binary.LittleEndian.Uint32(value[5:]))
+		value = value[9:]
+		if len(value) < keySize+valueSize {
+			return errors.New("truncated batch operation")
+		}
+		if opType == wal.RecordBatch {
+			return errors.New("nested batch")
+		}
+		var opValue []byte
+		if valueSize > 0 {
+			opValue = value[keySize : keySize+valueSize]
+		}
+		if err := fn(opType, value[:keySize], opValue, int64(1+4+4+keySize+valueSize)); err != nil {
+			return err
+		}
+		value = value[keySize+valueSize:]
+	}
+	return nil
+}
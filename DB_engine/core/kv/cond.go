@@ -0,0 +1,126 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrNotNumber is wrapped by the error for incrementing a value that is not
+// an unsigned decimal number.
+var ErrNotNumber = errors.New("not an unsigned number")
+
+// Cond is what a conditional write requires of the key before it writes.
+// The zero Cond requires nothing.
+type Cond struct {
+	Exists bool   // the key holds a value
+	Absent bool   // it does not
+	LSN    uint64 // its last write had this LSN; ErrNotFound if it is missing
+}
+
+// check tests cond against key under a held lock.
+func (kv *KVEngine) check(key []byte, cond Cond) error {
+	vm, ok := kv.pointIndex[string(key)]
+	live := ok && !vm.expired(time.Now().UnixNano())
+	switch {
+	case cond.LSN != 0 && !live:
+		return ErrNotFound
+	case cond.LSN != 0 && vm.lsn != cond.LSN,
+		cond.Exists && !live,
+		cond.Absent && live:
+		return ErrConditionFailed
+	}
+	return nil
+}
+
+// PutIf writes the key with its item flags if cond holds. A ttl of 0 means
+// the default TTL, as for Put. The flags are opaque, GetItem returns them.
+func (kv *KVEngine) PutIf(key, value []byte, ttl time.Duration, flags uint32, cond Cond) (uint64, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.ensureLoaded(); err != nil {
+		return 0, err
+	}
+	if err := kv.check(key, cond); err != nil {
+		return 0, err
+	}
+	if ttl == 0 {
+		ttl = kv.defaultTTL
+	}
+	return kv.put(key, value, ttl, flags)
+}
+
+// DeleteIf deletes the key if cond holds. With cond.Exists nothing is logged
+// for a key that is already gone.
+func (kv *KVEngine) DeleteIf(key []byte, cond Cond) (uint64, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.ensureLoaded(); err != nil {
+		return 0, err
+	}
+	if err := kv.check(key, cond); err != nil {
+		return 0, err
+	}
+	return kv.delete(key)
+}
+
+// Incr adds delta to the unsigned decimal number the key holds, or subtracts it
+// when decr is set, and returns the new value, keeping the key's expiry and
+// item flags. As in
+// memcached, a decrement stops at 0 and an increment wraps around at 2^64.
+func (kv *KVEngine) Incr(key []byte, delta uint64, decr bool) ([]byte, uint64, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.ensureLoaded(); err != nil {
+		return nil, 0, err
+	}
+	if kv.readOnly {
+		return nil, 0, ErrReadOnly
+	}
+	vm, ok := kv.pointIndex[string(key)]
+	if !ok || vm.expired(time.Now().UnixNano()) {
+		return nil, 0, ErrNotFound
+	}
+	n, err := strconv.ParseUint(string(vm.value), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: value of %q", ErrNotNumber, key)
+	}
+
+	switch {
+	case !decr:
+		n += delta
+	case delta > n:
+		n = 0
+	default:
+		n -= delta
+	}
+	value := []byte(strconv.FormatUint(n, 10))
+	lsn, err := kv.putExpiring(key, value, vm.expireAt, vm.flags)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, lsn, nil
+}
+
+// Touch gives an existing key a new TTL, 0 meaning it never expires, by
+// writing its value and item flags again.
+func (kv *KVEngine) Touch(key []byte, ttl time.Duration) (uint64, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.ensureLoaded(); err != nil {
+		return 0, err
+	}
+	if kv.readOnly {
+		return 0, ErrReadOnly
+	}
+	vm, ok := kv.pointIndex[string(key)]
+	if !ok || vm.expired(time.Now().UnixNano()) {
+		return 0, ErrNotFound
+	}
+	return kv.put(key, vm.value, ttl, vm.flags)
+}
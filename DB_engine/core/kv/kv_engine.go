@@ -3,55 +3,266 @@ package kv
 import (
 	"errors"
 	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"byted/DB_engine/core/btree"
 	"byted/DB_engine/core/wal"
 )
 
+// ErrReadOnly is returned by writes to a read-only engine.
+var ErrReadOnly = errors.New("bucket is read-only")
+
+// ErrClosed is returned by operations on an engine after Close.
+var ErrClosed = errors.New("bucket is closed")
+
+// ErrNotFound is returned by Get for a missing or expired key.
+var ErrNotFound = errors.New("key not found")
+
+// ErrTooLarge is wrapped by the error for a value over the bucket's size limit.
+var ErrTooLarge = errors.New("value too large")
+
+// ErrConditionFailed is returned by conditional writes when the key was not
+// in the state they require; nothing is written then.
+var ErrConditionFailed = errors.New("condition not met")
+
 // valueMeta holds the value and its last associated LSN.
 type valueMeta struct {
-	value []byte
-	lsn   uint64
+	value    []byte
+	lsn      uint64
+	expireAt int64  // unix nanoseconds, 0 = never expires
+	flags    uint32 // item flags, stored for the client and never looked at
+	size     int64  // bytes of the WAL record that wrote it
 }
 
+func (vm *valueMeta) expired(now int64) bool {
+	return vm.expireAt != 0 && vm.expireAt <= now
+}
+
+// KVEngine is loaded lazily: the WAL is opened and replayed by the first
+// operation that needs it, and Unload releases it again. Holders of the engine
+// never see the difference, an unloaded engine simply loads on next use.
 type KVEngine struct {
-	wal        *wal.WAL              // write ahead logs for durability
-	pointIndex map[string]*valueMeta // in-memory point index
-	index      *btree.BPlusTree      // on-disk b+tree for range queries
+	wal          *wal.WAL              // write ahead logs for durability, nil while unloaded
+	pointIndex   map[string]*valueMeta // in-memory point index
+	index        *btree.BPlusTree      // on-disk b+tree for range queries
+	order        int                   // order of the B+ tree
+	maxValueSize int                   // largest accepted value in bytes, 0 = unlimited
+	defaultTTL   time.Duration         // TTL applied by Put, 0 = keys never expire
+	compress     bool                  // deflate values written to the WAL
+	readOnly     bool                  // reject Put and Delete
+	mutex        sync.RWMutex          // buckets are shared by every connection
+
+	walPath     string       // where the WAL lives, for loading
+	walReadOnly bool         // open the WAL file without write access
+	syncWrites  bool         // fsync every append
+	closed      bool         // set by Close, the engine never loads again
+	lastUsed    atomic.Int64 // unix nanoseconds of the last operation
+	lastLoad    LoadStats
+	onLoad      func(LoadStats) // called after every load
+	loadedAt    time.Time       // when the current load finished
+	unloadedLSN uint64          // last LSN when unloaded, for Stats
+
+	onChange atomic.Pointer[func(Change)] // called with every write, see SetChangeHook
+
+	gets, puts, deletes, ranges atomic.Uint64 // operation counters, kept across unloads
+}
+
+// Change is a write reported to the change hook. Value is nil for a delete;
+// neither may be modified.
+type Change struct {
+	Key    []byte
+	Value  []byte
+	Delete bool
+	LSN    uint64
+}
+
+// LoadStats describes one load of the engine from its WAL.
+type LoadStats struct {
+	Records int           // WAL records replayed
+	Bytes   int64         // size of the WAL
+	Elapsed time.Duration // time taken to open and replay it
+	Reload  bool          // the engine had been loaded and unloaded before
 }
 
 // NewKVEngine initializes the key-value engine with WAL and B+ tree.
 func NewKVEngine(walPath string, btreeOrder int) (*KVEngine, error) {
+	return openKVEngine(walPath, btreeOrder, false)
+}
+
+// NewKVEngineReadOnly opens an engine whose WAL file is not writable; it starts
+// out read-only until ReopenWAL gives it write access again.
+func NewKVEngineReadOnly(walPath string, btreeOrder int) (*KVEngine, error) {
+	return openKVEngine(walPath, btreeOrder, true)
+}
 
+// NewLazyKVEngine returns an engine that does not touch walPath until it is
+// first used. readOnly is as for NewKVEngineReadOnly.
+func NewLazyKVEngine(walPath string, btreeOrder int, readOnly bool) *KVEngine {
+	return &KVEngine{
+		order:       btreeOrder,
+		readOnly:    readOnly,
+		walPath:     walPath,
+		walReadOnly: readOnly,
+		syncWrites:  true,
+	}
+}
+
+func openKVEngine(walPath string, btreeOrder int, readOnly bool) (*KVEngine, error) {
+	engine := NewLazyKVEngine(walPath, btreeOrder, readOnly)
+	if err := engine.Load(); err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
+// Load opens the WAL and rebuilds the in-memory state, if not done already.
+func (kv *KVEngine) Load() error {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	return kv.ensureLoaded()
+}
+
+// ensureLoaded loads the engine under the held write lock.
+func (kv *KVEngine) ensureLoaded() error {
+	kv.touch()
+	if kv.closed {
+		return ErrClosed
+	}
+	if kv.wal != nil {
+		return nil
+	}
+
+	start := time.Now()
 	// opens wal (create if not exists) and recovers last LSN
-	w, err := wal.New(walPath)
+	w, err := wal.Open(kv.walPath, kv.walReadOnly)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize WAL: %w", err)
+		return fmt.Errorf("failed to initialize WAL: %w", err)
 	}
+	w.SetSync(kv.syncWrites)
 
-	// create B Tree index
-	tree := btree.New(btreeOrder)
+	kv.wal = w
+	kv.pointIndex = make(map[string]*valueMeta)
+	kv.index = btree.New(kv.order) // create B Tree index
 
-	engine := &KVEngine{
-		wal:        w,
-		pointIndex: make(map[string]*valueMeta),
-		index:      tree,
+	// replay Wal to rebuild memory state
+	if err := kv.ReplayWAL(); err != nil {
+		_ = w.Close()
+		kv.wal, kv.pointIndex, kv.index = nil, nil, nil
+		return fmt.Errorf("WAL replay failed: %w", err)
 	}
 
-	// replay Wal to rebuild memory state
-	err = engine.ReplayWAL()
+	if info, err := os.Stat(kv.walPath); err == nil {
+		kv.lastLoad.Bytes = info.Size()
+	}
+	kv.lastLoad.Elapsed = time.Since(start)
+	kv.lastLoad.Reload = !kv.loadedAt.IsZero()
+	kv.loadedAt = time.Now()
+	if kv.onLoad != nil {
+		kv.onLoad(kv.lastLoad)
+	}
+	return nil
+}
+
+// SetLoadHook registers a function called with the stats of every load.
+func (kv *KVEngine) SetLoadHook(hook func(LoadStats)) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	kv.onLoad = hook
+}
+
+// SetChangeHook registers a function called with every put and delete, in
+// LSN order, nil to remove it. It runs under the write lock and must not
+// block or call back into the engine. Replaying the WAL and keys expiring are
+// not reported.
+func (kv *KVEngine) SetChangeHook(hook func(Change)) {
+	if hook == nil {
+		kv.onChange.Store(nil)
+		return
+	}
+	kv.onChange.Store(&hook)
+}
+
+// changed reports a write to the change hook.
+func (kv *KVEngine) changed(c Change) {
+	if hook := kv.onChange.Load(); hook != nil {
+		(*hook)(c)
+	}
+}
+
+// LastLoad returns the stats of the most recent load.
+func (kv *KVEngine) LastLoad() LoadStats {
+	kv.mutex.RLock()
+	defer kv.mutex.RUnlock()
+
+	return kv.lastLoad
+}
+
+// rlock takes the read lock on a loaded engine, loading it first if needed.
+func (kv *KVEngine) rlock() error {
+	for {
+		kv.mutex.RLock()
+		if kv.wal != nil {
+			kv.touch()
+			return nil
+		}
+		kv.mutex.RUnlock()
+
+		if err := kv.Load(); err != nil {
+			return err
+		}
+	}
+}
+
+func (kv *KVEngine) touch() {
+	kv.lastUsed.Store(time.Now().UnixNano())
+}
+
+// Loaded reports whether the engine's state is in memory.
+func (kv *KVEngine) Loaded() bool {
+	kv.mutex.RLock()
+	defer kv.mutex.RUnlock()
+
+	return kv.wal != nil
+}
+
+// IdleSince is the time of the last operation on the engine.
+func (kv *KVEngine) IdleSince() time.Time {
+	return time.Unix(0, kv.lastUsed.Load())
+}
+
+// Unload flushes and closes the WAL and drops the in-memory state; the next
+// operation loads it again.
+func (kv *KVEngine) Unload() error {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if kv.wal == nil {
+		return nil
+	}
+	kv.unloadedLSN = kv.wal.LastLSN()
+	err := kv.wal.Close()
+	kv.wal, kv.pointIndex, kv.index = nil, nil, nil
 	if err != nil {
-		_ = w.Close()
-		return nil, fmt.Errorf("WAL replay failed: %w", err)
+		return fmt.Errorf("failed to close WAL: %w", err)
 	}
-	fmt.Println("WAL replayed successfully, in-memory state restored.")
-	return engine, nil
+	return nil
 }
 
 // Close gracefully closes the KV engine, ensuring all data is flushed.
 func (kv *KVEngine) Close() error {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	kv.closed = true
 	if kv.wal != nil {
-		if err := kv.wal.Close(); err != nil {
+		err := kv.wal.Close()
+		kv.wal, kv.pointIndex, kv.index = nil, nil, nil
+		if err != nil {
 			return fmt.Errorf("failed to close WAL: %w", err)
 		}
 	}
@@ -59,87 +270,311 @@ func (kv *KVEngine) Close() error {
 	return nil
 }
 
+// SetSyncWrites controls whether every write is fsynced to the WAL before it is acknowledged.
+func (kv *KVEngine) SetSyncWrites(sync bool) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	kv.syncWrites = sync
+	if kv.wal != nil {
+		kv.wal.SetSync(sync)
+	}
+}
+
+// SetMaxValueSize limits the size of values accepted by Put, 0 means unlimited.
+func (kv *KVEngine) SetMaxValueSize(size int) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	kv.maxValueSize = size
+}
+
+// SetDefaultTTL sets the TTL Put gives new values, 0 means they never expire.
+func (kv *KVEngine) SetDefaultTTL(ttl time.Duration) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	kv.defaultTTL = ttl
+}
+
+// SetCompression selects how values are written to the WAL: "none" or "deflate".
+func (kv *KVEngine) SetCompression(mode string) error {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	switch mode {
+	case CompressionNone, "":
+		kv.compress = false
+	case CompressionDeflate:
+		kv.compress = true
+	default:
+		return fmt.Errorf("unknown compression '%s'", mode)
+	}
+	return nil
+}
+
+// SetReadOnly makes Put and Delete fail with ErrReadOnly.
+func (kv *KVEngine) SetReadOnly(readOnly bool) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	kv.readOnly = readOnly
+}
+
+// ReopenWAL reopens the WAL file with or without write access, e.g. after the
+// file's permissions were changed.
+func (kv *KVEngine) ReopenWAL(readOnly bool) error {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	kv.walReadOnly = readOnly
+	if kv.wal == nil {
+		return nil // opened that way when loaded
+	}
+	return kv.wal.Reopen(readOnly)
+}
+
+// SetOrder rebuilds the B+ tree index with a new order.
+func (kv *KVEngine) SetOrder(order int) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if order == kv.order {
+		return
+	}
+	kv.order = order
+	if kv.wal == nil {
+		return
+	}
+	tree := btree.New(order)
+	for key, vm := range kv.pointIndex {
+		tree.Insert(key, vm.value)
+	}
+	kv.index = tree
+}
+
+// WALPath is the path of the engine's WAL file.
+func (kv *KVEngine) WALPath() string {
+	return kv.walPath
+}
+
+// WALSize returns the WAL's size while holding off writers, so the first
+// WALSize bytes are a consistent, complete log even as writes continue.
+func (kv *KVEngine) WALSize() (int64, error) {
+	kv.mutex.RLock()
+	defer kv.mutex.RUnlock()
+
+	info, err := os.Stat(kv.walPath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// LastLSN returns the LSN of the most recent write. An engine that is not
+// loaded reads it from the WAL's record headers instead of loading.
+func (kv *KVEngine) LastLSN() (uint64, error) {
+	kv.mutex.RLock()
+	defer kv.mutex.RUnlock()
+
+	switch {
+	case kv.closed:
+		return 0, ErrClosed
+	case kv.wal != nil:
+		return kv.wal.LastLSN(), nil
+	case !kv.loadedAt.IsZero():
+		return kv.unloadedLSN, nil
+	}
+	w, err := wal.Open(kv.walPath, true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read WAL: %w", err)
+	}
+	defer w.Close()
+	return w.LastLSN(), nil
+}
+
 // reads wal from start and applies each record to in-memory structure.
 func (kv *KVEngine) ReplayWAL() error {
 
 	if kv.wal == nil {
 		return errors.New("WAL is not initialized")
 	}
+	kv.lastLoad = LoadStats{}
 
 	// handler to process each WAL record
 	handler := func(lsn uint64, recordType uint8, key, value []byte) error {
-		switch recordType {
+		kv.lastLoad.Records++
+		return forEachOp(recordType, key, value, func(recordType uint8, key, value []byte, size int64) error {
+			return kv.replayOp(lsn, recordType, key, value, size)
+		})
+	}
 
-		case wal.RecordPut:
-			// create valueMeta and update point index
+	// replay WAL using the handler
+	if err := kv.wal.Replay(handler); err != nil {
+		return fmt.Errorf("WAL replay error: %w", err)
+	}
+	return nil
+}
 
-			vm := &valueMeta{value: make([]byte, len(value)), lsn: lsn}
-			copy(vm.value, value)
-			kv.pointIndex[string(key)] = vm
-			kv.index.Insert(string(key), value) // also insert into B+ tree
+// replayOp applies one replayed operation to the in-memory state; size is
+// what it takes up in the WAL.
+func (kv *KVEngine) replayOp(lsn uint64, recordType uint8, key, value []byte, size int64) error {
+	switch recordType {
 
-		case wal.RecordDelete:
-			// delete from point index
-			delete(kv.pointIndex, string(key))
-			kv.index.Delete(string(key)) // also delete from B+ tree
+	case wal.RecordPut:
+		// create valueMeta and update point index
+
+		vm := &valueMeta{value: make([]byte, len(value)), lsn: lsn, size: size}
+		copy(vm.value, value)
+		kv.pointIndex[string(key)] = vm
+		kv.index.Insert(string(key), vm.value) // also insert into B+ tree
 
-		default:
-			return fmt.Errorf("unknown record type: %d", recordType)
+	case wal.RecordPutExt:
+		decoded, expireAt, flags, err := decodeExtValue(value)
+		if err != nil {
+			return fmt.Errorf("LSN %d: %v", lsn, err)
 		}
-		return nil
-	}
+		vm := &valueMeta{value: decoded, lsn: lsn, expireAt: expireAt, flags: flags, size: size}
+		if vm.expired(time.Now().UnixNano()) {
+			// already gone, behaves like a delete
+			delete(kv.pointIndex, string(key))
+			kv.index.Delete(string(key))
+			break
+		}
+		kv.pointIndex[string(key)] = vm
+		kv.index.Insert(string(key), vm.value)
 
-	// replay WAL using the handler
-	if err := kv.wal.Replay(handler); err != nil {
-		return fmt.Errorf("WAL replay error: %w", err)
+	case wal.RecordDelete:
+		// delete from point index
+		delete(kv.pointIndex, string(key))
+		kv.index.Delete(string(key)) // also delete from B+ tree
+
+	default:
+		return fmt.Errorf("unknown record type: %d", recordType)
 	}
 	return nil
 }
 
-// Put adds or updates a key-value pair in the KV engine.
+// Put adds or updates a key-value pair in the KV engine, using the default TTL.
 func (kv *KVEngine) Put(key, value []byte) (uint64, error) {
-	if kv.wal == nil {
-		return 0, errors.New("WAL is not initialized")
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	return kv.put(key, value, kv.defaultTTL, 0)
+}
+
+// PutWithTTL adds or updates a key that expires after ttl; 0 means never.
+func (kv *KVEngine) PutWithTTL(key, value []byte, ttl time.Duration) (uint64, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	return kv.put(key, value, ttl, 0)
+}
+
+// put writes a value and its item flags under the held write lock.
+func (kv *KVEngine) put(key, value []byte, ttl time.Duration, flags uint32) (uint64, error) {
+	if err := kv.ensureLoaded(); err != nil {
+		return 0, err
+	}
+	if kv.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	var expireAt int64
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl).UnixNano()
+	}
+	return kv.putExpiring(key, value, expireAt, flags)
+}
+
+// putExpiring writes a value that expires at expireAt (unix nanoseconds, 0 =
+// never) under the held write lock, once the engine is loaded and writable.
+func (kv *KVEngine) putExpiring(key, value []byte, expireAt int64, flags uint32) (uint64, error) {
+	if kv.maxValueSize > 0 && len(value) > kv.maxValueSize {
+		return 0, fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrTooLarge, len(value), kv.maxValueSize)
+	}
+
+	// append to WAL, plain records unless the value needs a header
+	var lsn uint64
+	var err error
+	payload := value
+	if expireAt == 0 && flags == 0 && !kv.compress {
+		lsn, err = kv.wal.AppendPut(key, value)
+	} else {
+		if payload, err = encodeExtValue(value, expireAt, flags, kv.compress); err == nil {
+			lsn, err = kv.wal.AppendPutExt(key, payload)
+		}
 	}
-	// append to WAL
-	lsn, err := kv.wal.AppendPut(key, value)
 	if err != nil {
 		return 0, fmt.Errorf("failed to append PUT to WAL: %w", err)
 	}
+	kv.puts.Add(1)
 
 	// update in-memory point index
-	vm := &valueMeta{value: make([]byte, len(value)), lsn: lsn}
+	vm := &valueMeta{value: make([]byte, len(value)), lsn: lsn, expireAt: expireAt, flags: flags, size: wal.RecordSize(key, payload)}
 	copy(vm.value, value)
 	kv.pointIndex[string(key)] = vm
 
 	// insert into B+ tree for range queries
-	kv.index.Insert(string(key), value)
+	kv.index.Insert(string(key), vm.value)
 
+	kv.changed(Change{Key: key, Value: vm.value, LSN: lsn})
 	return lsn, nil
 }
 
+// Item is a key's value with what is stored along with it.
+type Item struct {
+	Value []byte
+	LSN   uint64 // of the key's last write, changes whenever the key does
+	Flags uint32 // item flags given to PutIf
+}
+
 // Get retrieves the value for a given key.
 func (kv *KVEngine) Get(key []byte) ([]byte, error) {
+	item, err := kv.GetItem(key)
+	return item.Value, err
+}
+
+// GetItem is Get that also returns the key's LSN and item flags.
+func (kv *KVEngine) GetItem(key []byte) (Item, error) {
+	if err := kv.rlock(); err != nil {
+		return Item{}, err
+	}
+	defer kv.mutex.RUnlock()
+
+	kv.gets.Add(1)
 	vm, ok := kv.pointIndex[string(key)]
-	if !ok {
-		return nil, errors.New("key not found")
+	if !ok || vm.expired(time.Now().UnixNano()) {
+		return Item{}, ErrNotFound
 	}
 	// return a copy to prevent external modification
 	valueCopy := make([]byte, len(vm.value))
 	copy(valueCopy, vm.value)
-	return valueCopy, nil
+	return Item{Value: valueCopy, LSN: vm.lsn, Flags: vm.flags}, nil
 }
 
 // Delete removes a key-value pair from the KV engine.
 func (kv *KVEngine) Delete(key []byte) (uint64, error) {
-	if kv.wal == nil {
-		return 0, errors.New("WAL is not initialized")
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if err := kv.ensureLoaded(); err != nil {
+		return 0, err
+	}
+	return kv.delete(key)
+}
+
+// delete removes a key under the held write lock.
+func (kv *KVEngine) delete(key []byte) (uint64, error) {
+	if kv.readOnly {
+		return 0, ErrReadOnly
 	}
 	// append delete record to WAL
 	lsn, err := kv.wal.AppendDelete(key)
 	if err != nil {
 		return 0, fmt.Errorf("failed to append DELETE to WAL: %w", err)
 	}
+	kv.deletes.Add(1)
 
 	// remove from in-memory point index
 	delete(kv.pointIndex, string(key))
@@ -147,10 +582,53 @@ func (kv *KVEngine) Delete(key []byte) (uint64, error) {
 	// remove from B+ tree
 	kv.index.Delete(string(key))
 
+	kv.changed(Change{Key: key, Delete: true, LSN: lsn})
 	return lsn, nil
 }
 
 // Range retrieves all key-value pairs within the specified key range [startKey, endKey].
-func (kv *KVEngine) Range(startKey, endKey []byte) []btree.KVPair {
-	return kv.index.RangeQuery(string(startKey), string(endKey))
+func (kv *KVEngine) Range(startKey, endKey []byte) ([]btree.KVPair, error) {
+	if err := kv.rlock(); err != nil {
+		return nil, err
+	}
+	defer kv.mutex.RUnlock()
+
+	kv.ranges.Add(1)
+	pairs := kv.index.RangeQuery(string(startKey), string(endKey))
+
+	// drop keys whose TTL ran out
+	now := time.Now().UnixNano()
+	live := pairs[:0]
+	for _, p := range pairs {
+		if vm, ok := kv.pointIndex[p.Key]; ok && !vm.expired(now) {
+			live = append(live, p)
+		}
+	}
+	return live, nil
+}
+
+// Scan returns up to limit live pairs with keys from start on, and the key to
+// continue from, nil once there are no more.
+func (kv *KVEngine) Scan(start []byte, limit int) ([]btree.KVPair, []byte, error) {
+	if err := kv.rlock(); err != nil {
+		return nil, nil, err
+	}
+	defer kv.mutex.RUnlock()
+
+	kv.ranges.Add(1)
+	var pairs []btree.KVPair
+	var next []byte
+	now := time.Now().UnixNano()
+	kv.index.Ascend(string(start), func(key string, value any) bool {
+		if vm, ok := kv.pointIndex[key]; !ok || vm.expired(now) {
+			return true
+		}
+		if len(pairs) == limit {
+			next = []byte(key)
+			return false
+		}
+		pairs = append(pairs, btree.KVPair{Key: key, Value: value})
+		return true
+	})
+	return pairs, next, nil
 }
@@ -0,0 +1,100 @@
+package kv
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// compression modes for values written to the WAL
+const (
+	CompressionNone    = "none"
+	CompressionDeflate = "deflate"
+)
+
+// flags in the header of a wal.RecordPutExt value
+const (
+	flagTTL        = 1 << 0 // an int64 expiry (unix nanoseconds) follows the flags
+	flagCompressed = 1 << 1 // data is deflate compressed
+	flagItemFlags  = 1 << 2 // the uint32 item flags follow the expiry
+)
+
+// encodeExtValue builds the payload of a RecordPutExt record.
+func encodeExtValue(value []byte, expireAt int64, itemFlags uint32, compress bool) ([]byte, error) {
+	var flags uint8
+	header := make([]byte, 1, 13)
+	if expireAt != 0 {
+		flags |= flagTTL
+		header = binary.LittleEndian.AppendUint64(header, uint64(expireAt))
+	}
+	if itemFlags != 0 {
+		flags |= flagItemFlags
+		header = binary.LittleEndian.AppendUint32(header, itemFlags)
+	}
+
+	data := value
+	if compress {
+		var buf bytes.Buffer
+		zw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(value); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		// only worth it if it actually got smaller
+		if buf.Len() < len(value) {
+			flags |= flagCompressed
+			data = buf.Bytes()
+		}
+	}
+
+	header[0] = flags
+	return append(header, data...), nil
+}
+
+// decodeExtValue is the inverse of encodeExtValue.
+func decodeExtValue(payload []byte) ([]byte, int64, uint32, error) {
+	if len(payload) < 1 {
+		return nil, 0, 0, errors.New("extended put record without header")
+	}
+	flags := payload[0]
+	payload = payload[1:]
+
+	var expireAt int64
+	if flags&flagTTL != 0 {
+		if len(payload) < 8 {
+			return nil, 0, 0, errors.New("extended put record with truncated expiry")
+		}
+		expireAt = int64(binary.LittleEndian.Uint64(payload))
+		payload = payload[8:]
+	}
+	var itemFlags uint32
+	if flags&flagItemFlags != 0 {
+		if len(payload) < 4 {
+			return nil, 0, 0, errors.New("extended put record with truncated item flags")
+		}
+		itemFlags = binary.LittleEndian.Uint32(payload)
+		payload = payload[4:]
+	}
+
+	if flags&flagCompressed == 0 {
+		value := make([]byte, len(payload))
+		copy(value, payload)
+		return value, expireAt, itemFlags, nil
+	}
+
+	zr := flate.NewReader(bytes.NewReader(payload))
+	defer zr.Close()
+	value, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to decompress value: %v", err)
+	}
+	return value, expireAt, itemFlags, nil
+}
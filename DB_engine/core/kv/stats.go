@@ -0,0 +1,75 @@
+package kv
+
+import (
+	"os"
+	"time"
+
+	"byted/DB_engine/core/btree"
+)
+
+// Stats is a point-in-time description of an engine. The key, byte and tree
+// figures are only filled in while the engine is loaded.
+type Stats struct {
+	Loaded     bool
+	LoadedAt   time.Time // when the current load finished
+	Keys       int       // live keys
+	KeyBytes   int64     // total size of the live keys
+	ValueBytes int64     // total size of their values
+	WALBytes   int64     // size of the WAL file
+	LiveBytes  int64     // part of the WAL still holding live values
+	LastLSN    uint64
+	Tree       btree.Stats
+
+	// operations served since the server started
+	Gets, Puts, Deletes, Ranges uint64
+}
+
+// GarbageRatio is the fraction of the WAL taken up by overwritten, deleted or
+// expired values.
+func (s Stats) GarbageRatio() float64 {
+	if s.WALBytes == 0 || !s.Loaded {
+		return 0
+	}
+	garbage := float64(s.WALBytes-s.LiveBytes) / float64(s.WALBytes)
+	if garbage < 0 {
+		return 0
+	}
+	return garbage
+}
+
+// Stats describes the engine without loading it. It walks every key and the
+// whole B+ tree, so it holds the read lock for a while on large buckets.
+func (kv *KVEngine) Stats() Stats {
+	kv.mutex.RLock()
+	defer kv.mutex.RUnlock()
+
+	s := Stats{
+		Loaded:  kv.wal != nil,
+		LastLSN: kv.unloadedLSN,
+		Gets:    kv.gets.Load(),
+		Puts:    kv.puts.Load(),
+		Deletes: kv.deletes.Load(),
+		Ranges:  kv.ranges.Load(),
+	}
+	if info, err := os.Stat(kv.walPath); err == nil {
+		s.WALBytes = info.Size()
+	}
+	if !s.Loaded {
+		return s
+	}
+
+	s.LoadedAt = kv.loadedAt
+	s.LastLSN = kv.wal.LastLSN()
+	now := time.Now().UnixNano()
+	for key, vm := range kv.pointIndex {
+		if vm.expired(now) {
+			continue
+		}
+		s.Keys++
+		s.KeyBytes += int64(len(key))
+		s.ValueBytes += int64(len(vm.value))
+		s.LiveBytes += vm.size
+	}
+	s.Tree = kv.index.Stats()
+	return s
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package lock
+
+import "os"
+
+// No advisory locking on this platform; only the PID file is written.
+func tryLock(f *os.File) error {
+	return nil
+}
+
+func unlock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,90 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"byted/DB_engine/constants"
+)
+
+// ErrLocked is returned when another process holds the data directory.
+var ErrLocked = errors.New("data directory is locked")
+
+// DirLock is an exclusive, advisory lock on a data directory plus a PID file
+// naming the owner. The lock itself is held by the kernel, so it disappears when
+// the process dies; the PID file is only informational and a leftover one is
+// reported as stale.
+type DirLock struct {
+	dir      string
+	f        *os.File
+	pidPath  string
+	StalePID int // pid found in a PID file left behind by a crashed process, 0 if none
+}
+
+// Acquire locks dir, failing fast if another server or offline tool holds it.
+func Acquire(dir string) (*DirLock, error) {
+	if err := os.MkdirAll(dir, constants.OWNERPERMISSION); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	lockPath := filepath.Join(dir, constants.LOCKFILENAME)
+	pidPath := filepath.Join(dir, constants.PIDFILENAME)
+
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+
+	if err := tryLock(f); err != nil {
+		f.Close()
+		if pid := readPID(pidPath); pid > 0 {
+			return nil, fmt.Errorf("%w: %s is in use by process %d (see %s)", ErrLocked, dir, pid, pidPath)
+		}
+		return nil, fmt.Errorf("%w: %s is in use by another process", ErrLocked, dir)
+	}
+
+	l := &DirLock{dir: dir, f: f, pidPath: pidPath}
+
+	// we hold the lock, so whoever wrote an old PID file is gone
+	if pid := readPID(pidPath); pid > 0 && pid != os.Getpid() {
+		l.StalePID = pid
+	}
+
+	if err := os.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		l.Release()
+		return nil, fmt.Errorf("failed to write pid file: %v", err)
+	}
+	return l, nil
+}
+
+// Release removes the PID file and drops the lock.
+func (l *DirLock) Release() error {
+	if l.f == nil {
+		return errors.New("lock is not held")
+	}
+	if readPID(l.pidPath) == os.Getpid() {
+		os.Remove(l.pidPath)
+	}
+	err := unlock(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
+
+func readPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
@@ -0,0 +1,307 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Exported keys are written one per line (JSON Lines) or one per row (CSV):
+//
+//	{"key": "user:1", "value": "alice", "expire_at": "2025-01-02T15:04:05Z"}
+//	{"key": "AAE=", "value": "/w==", "encoding": "base64"}
+//
+//	key,value,encoding,expire_at
+//	user:1,alice,,2025-01-02T15:04:05Z
+//
+// Keys and values that are not plain text (invalid UTF-8, or carriage returns
+// that CSV readers rewrite) are base64 encoded, so binary data round-trips.
+
+// formats
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// EncodingBase64 marks a record whose key and value are base64 encoded.
+const EncodingBase64 = "base64"
+
+// DefaultBatchSize is the number of keys Import writes per batch.
+const DefaultBatchSize = 1000
+
+var csvHeader = []string{"key", "value", "encoding", "expire_at"}
+
+// Record is one exported key.
+type Record struct {
+	Key      []byte
+	Value    []byte
+	ExpireAt int64 // unix nanoseconds, 0 = never expires
+}
+
+type line struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"`
+	ExpireAt string `json:"expire_at,omitempty"`
+}
+
+// ParseFormat checks a format name, "" meaning the one implied by path.
+func ParseFormat(format, path string) (string, error) {
+	if format == "" {
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			return FormatCSV, nil
+		}
+		return FormatJSONL, nil
+	}
+	switch strings.ToLower(format) {
+	case FormatJSONL, "json":
+		return FormatJSONL, nil
+	case FormatCSV:
+		return FormatCSV, nil
+	}
+	return "", fmt.Errorf("unknown format '%s', expected jsonl or csv", format)
+}
+
+func toLine(rec Record) line {
+	l := line{Key: string(rec.Key), Value: string(rec.Value)}
+	if !plain(rec.Key) || !plain(rec.Value) {
+		l.Key = base64.StdEncoding.EncodeToString(rec.Key)
+		l.Value = base64.StdEncoding.EncodeToString(rec.Value)
+		l.Encoding = EncodingBase64
+	}
+	if rec.ExpireAt != 0 {
+		l.ExpireAt = time.Unix(0, rec.ExpireAt).UTC().Format(time.RFC3339Nano)
+	}
+	return l
+}
+
+func plain(b []byte) bool {
+	return utf8.Valid(b) && bytes.IndexByte(b, '\r') < 0
+}
+
+func (l line) record() (Record, error) {
+	rec := Record{Key: []byte(l.Key), Value: []byte(l.Value)}
+	switch l.Encoding {
+	case "":
+	case EncodingBase64:
+		var err error
+		if rec.Key, err = base64.StdEncoding.DecodeString(l.Key); err != nil {
+			return Record{}, fmt.Errorf("invalid base64 key: %v", err)
+		}
+		if rec.Value, err = base64.StdEncoding.DecodeString(l.Value); err != nil {
+			return Record{}, fmt.Errorf("invalid base64 value: %v", err)
+		}
+	default:
+		return Record{}, fmt.Errorf("unknown encoding '%s'", l.Encoding)
+	}
+	if len(rec.Key) == 0 {
+		return Record{}, errors.New("empty key")
+	}
+	if l.ExpireAt != "" {
+		t, err := time.Parse(time.RFC3339Nano, l.ExpireAt)
+		if err != nil {
+			return Record{}, fmt.Errorf("invalid expire_at: %v", err)
+		}
+		rec.ExpireAt = t.UnixNano()
+	}
+	return rec, nil
+}
+
+// MarshalLine encodes rec as a JSON line, without the newline.
+func MarshalLine(rec Record) (string, error) {
+	data, err := json.Marshal(toLine(rec))
+	return string(data), err
+}
+
+// UnmarshalLine is the inverse of MarshalLine.
+func UnmarshalLine(data string) (Record, error) {
+	var l line
+	if err := json.Unmarshal([]byte(data), &l); err != nil {
+		return Record{}, err
+	}
+	return l.record()
+}
+
+// Writer writes records in one of the formats.
+type Writer struct {
+	buf *bufio.Writer
+	csv *csv.Writer
+}
+
+// NewWriter returns a Writer for format; Flush must be called at the end.
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+	tw := &Writer{buf: bufio.NewWriter(w)}
+	switch format {
+	case FormatJSONL:
+	case FormatCSV:
+		tw.csv = csv.NewWriter(tw.buf)
+		if err := tw.csv.Write(csvHeader); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", format)
+	}
+	return tw, nil
+}
+
+func (w *Writer) Write(rec Record) error {
+	l := toLine(rec)
+	if w.csv != nil {
+		return w.csv.Write([]string{l.Key, l.Value, l.Encoding, l.ExpireAt})
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	w.buf.Write(data)
+	return w.buf.WriteByte('\n')
+}
+
+// Flush writes out anything buffered.
+func (w *Writer) Flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return w.buf.Flush()
+}
+
+// Reader reads records written by Writer. A CSV file may leave out the header
+// and the trailing columns.
+type Reader struct {
+	lines *bufio.Scanner
+	csv   *csv.Reader
+	line  int
+}
+
+// NewReader returns a Reader for format.
+func NewReader(r io.Reader, format string) (*Reader, error) {
+	switch format {
+	case FormatJSONL:
+		lines := bufio.NewScanner(r)
+		lines.Buffer(make([]byte, 64*1024), 1<<30)
+		return &Reader{lines: lines}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.ReuseRecord = true
+		return &Reader{csv: cr}, nil
+	}
+	return nil, fmt.Errorf("unknown format '%s'", format)
+}
+
+// Read returns the next record, io.EOF at the end. Errors name the line.
+func (r *Reader) Read() (Record, error) {
+	for {
+		r.line++
+		var l line
+		if r.csv != nil {
+			fields, err := r.csv.Read()
+			if err == io.EOF {
+				return Record{}, io.EOF
+			}
+			if err != nil {
+				return Record{}, err
+			}
+			r.line, _ = r.csv.FieldPos(0)
+			if r.line == 1 && len(fields) >= 2 && fields[0] == csvHeader[0] && fields[1] == csvHeader[1] {
+				continue
+			}
+			if len(fields) < 2 || len(fields) > len(csvHeader) {
+				return Record{}, fmt.Errorf("line %d: expected %d to %d columns, got %d", r.line, 2, len(csvHeader), len(fields))
+			}
+			fields = append(fields, "", "")
+			l = line{Key: fields[0], Value: fields[1], Encoding: fields[2], ExpireAt: fields[3]}
+		} else {
+			if !r.lines.Scan() {
+				if err := r.lines.Err(); err != nil {
+					return Record{}, fmt.Errorf("line %d: %v", r.line, err)
+				}
+				return Record{}, io.EOF
+			}
+			text := strings.TrimSpace(r.lines.Text())
+			if text == "" {
+				continue
+			}
+			if err := json.Unmarshal([]byte(text), &l); err != nil {
+				return Record{}, fmt.Errorf("line %d: %v", r.line, err)
+			}
+		}
+
+		rec, err := l.record()
+		if err != nil {
+			return Record{}, fmt.Errorf("line %d: %v", r.line, err)
+		}
+		return rec, nil
+	}
+}
+
+// Progress is how far an import got.
+type Progress struct {
+	Records int    // keys written
+	Skipped int    // keys whose TTL had already run out
+	Batches int    // batches written
+	LSN     uint64 // LSN of the last batch
+}
+
+// Import reads every record from r and hands them to apply in batches of
+// batchSize, calling progress after each batch. Expired records are skipped.
+// On error, the batches already applied stay applied.
+func Import(r *Reader, batchSize int, apply func([]Record) (uint64, error), progress func(Progress)) (Progress, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	var p Progress
+	batch := make([]Record, 0, batchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		lsn, err := apply(batch)
+		if err != nil {
+			return fmt.Errorf("batch %d: %w", p.Batches+1, err)
+		}
+		p.Records += len(batch)
+		p.Batches++
+		p.LSN = lsn
+		batch = batch[:0]
+		if progress != nil {
+			progress(p)
+		}
+		return nil
+	}
+
+	now := time.Now().UnixNano()
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return p, err
+		}
+		if rec.ExpireAt != 0 && rec.ExpireAt <= now {
+			p.Skipped++
+			continue
+		}
+		batch = append(batch, rec)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return p, err
+			}
+		}
+	}
+	return p, flush()
+}
@@ -1,6 +1,7 @@
 package wal
 
 import (
+	"bufio"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -12,27 +13,43 @@ import (
 const (
 	RecordPut    = 1
 	RecordDelete = 2
+	RecordPutExt = 3 // put whose value carries a header: | uint8 flags | [int64 expireAt] | [uint32 item flags] | data |
+	RecordBatch  = 4 // operations applied together, value: | uint8 type | uint32 keySize | uint32 valueSize | key | value | ...
 )
 
 type WAL struct {
-	f       *os.File // underlying file
-	lastLSN uint64   // last log sequence number( monotonically increasing)
+	f        *os.File // underlying file
+	lastLSN  uint64   // last log sequence number( monotonically increasing)
+	skipSync bool     // when set, appends are not fsynced (durability mode "none")
+	readOnly bool     // opened without write access
 }
 
-// opens/ creates a WAL file at path. If file exits, it opens in append mode and
-// replays headers to recover lastLSN.
-func New(path string) (*WAL, error) {
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644) // RDWR for read and write
+func openFile(path string, readOnly bool) (*os.File, error) {
+	if readOnly {
+		return os.OpenFile(path, os.O_RDONLY, 0)
+	}
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644) // RDWR for read and write
 	// O_APPEND - append data to the file when writing
 	// O_CREATE - create a new file if it does not exist
 	// O_RDWR - open the file for both reading and writing
 	// combining above three flags - > 1024 | 64 | 2 -> decides what to do with the file
 	// 0644 - user read write, group read, others read
+}
 
+// opens/ creates a WAL file at path. If file exits, it opens in append mode and
+// replays headers to recover lastLSN.
+func New(path string) (*WAL, error) {
+	return Open(path, false)
+}
+
+// Open is New with the option to open the file read-only, for WAL files whose
+// write permission was removed (frozen buckets). Appends fail in that mode.
+func Open(path string, readOnly bool) (*WAL, error) {
+	f, err := openFile(path, readOnly)
 	if err != nil {
 		return nil, err
 	}
-	w := &WAL{f: f}
+	w := &WAL{f: f, readOnly: readOnly}
 
 	// replay to recover lastLSN
 	if err := w.recoverLastLSN(); err != nil {
@@ -42,12 +59,45 @@ func New(path string) (*WAL, error) {
 	return w, nil
 }
 
-// Close closes the underlying file.
+// Close flushes and closes the underlying file.
 func (w *WAL) Close() error {
 	if w.f == nil {
 		return errors.New("WAL file is not open")
 	}
-	return w.f.Close()
+	f := w.f
+	w.f = nil
+	if w.skipSync && !w.readOnly {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// Reopen reopens the file read-only or read-write, e.g. after its permissions changed.
+func (w *WAL) Reopen(readOnly bool) error {
+	if w.f == nil {
+		return errors.New("WAL file is not open")
+	}
+	f, err := openFile(w.f.Name(), readOnly)
+	if err != nil {
+		return err
+	}
+	w.f.Close()
+	w.f = f
+	w.readOnly = readOnly
+	return nil
+}
+
+// SetSync controls whether every append is fsynced before returning.
+func (w *WAL) SetSync(sync bool) {
+	w.skipSync = !sync
+}
+
+// LastLSN returns the LSN of the last record written or replayed.
+func (w *WAL) LastLSN() uint64 {
+	return w.lastLSN
 }
 
 // Put writes a put record to the WAL.
@@ -55,11 +105,27 @@ func (w *WAL) AppendPut(key, value []byte) (uint64, error) {
 	return w.appendRecord(RecordPut, key, value)
 }
 
+// AppendPutExt writes an extended put record; the value is already encoded with its header.
+func (w *WAL) AppendPutExt(key, value []byte) (uint64, error) {
+	return w.appendRecord(RecordPutExt, key, value)
+}
+
+// AppendBatch writes a batch record; the value already holds the encoded
+// operations. The batch is a single record, so it is replayed whole or not at all.
+func (w *WAL) AppendBatch(ops []byte) (uint64, error) {
+	return w.appendRecord(RecordBatch, nil, ops)
+}
+
 // Delete writes a delete record to the WAL.
 func (w *WAL) AppendDelete(key []byte) (uint64, error) {
 	return w.appendRecord(RecordDelete, key, nil)
 }
 
+// RecordSize is the number of bytes a record with key and value takes up in the WAL.
+func RecordSize(key, value []byte) int64 {
+	return int64(4 + 8 + 1 + 4 + 4 + len(key) + len(value))
+}
+
 // appendRecord is the low-level writer.
 // Format: | uint32 totalLen | uint64 LSN(8) | unit8 Type(1) | uint32 KeySize | uint32 ValueSize | Key | Value |
 func (w *WAL) appendRecord(recordType uint8, key, value []byte) (uint64, error) {
@@ -67,6 +133,9 @@ func (w *WAL) appendRecord(recordType uint8, key, value []byte) (uint64, error)
 	if w.f == nil {
 		return 0, errors.New("WAL file is not open")
 	}
+	if w.readOnly {
+		return 0, errors.New("WAL file is opened read-only")
+	}
 	// increment LSN
 	w.lastLSN++
 	lsn := w.lastLSN
@@ -119,8 +188,10 @@ func (w *WAL) appendRecord(recordType uint8, key, value []byte) (uint64, error)
 	}
 
 	// disk persistence for durability
-	if err := w.f.Sync(); err != nil {
-		return 0, err
+	if !w.skipSync {
+		if err := w.f.Sync(); err != nil {
+			return 0, err
+		}
 	}
 
 	return lsn, nil
@@ -138,50 +209,12 @@ func (w *WAL) Replay(handler func(lsn uint64, recordTychan uint8, key, value []b
 	}
 
 	for {
-		// read total length
-		lenBuf := make([]byte, 4) // first 4 bytes are of each log record
-		if _, err := io.ReadFull(w.f, lenBuf); err != nil {
-
-			if err == io.EOF {
-				break // reached end of file
-			}
-			return fmt.Errorf("error reading total length: %v", err)
+		lsn, recordType, key, value, err := readRecord(w.f)
+		if err == io.EOF {
+			break // reached end of file
 		}
-
-		totalLen := binary.LittleEndian.Uint32(lenBuf)
-		recordBuf := make([]byte, totalLen)
-		if _, err := io.ReadFull(w.f, recordBuf); err != nil {
-			return fmt.Errorf("error reading record: %v", err)
-		}
-
-		curr := 0
-		// read LSN
-		lsn := binary.LittleEndian.Uint64(recordBuf[curr : curr+8])
-		curr += 8
-
-		// read record type
-		recordType := recordBuf[curr]
-		curr += 1
-
-		// read key size
-		keySize := binary.LittleEndian.Uint32(recordBuf[curr : curr+4])
-		curr += 4
-
-		// read value size
-		valueSize := binary.LittleEndian.Uint32(recordBuf[curr : curr+4])
-		curr += 4
-
-		// read key
-		key := make([]byte, keySize)
-		copy(key, recordBuf[curr:curr+int(keySize)])
-		curr += int(keySize)
-
-		// read value if present
-		var value []byte
-		if valueSize > 0 {
-			value = make([]byte, valueSize)
-			copy(value, recordBuf[curr:curr+int(valueSize)])
-			curr += int(valueSize)
+		if err != nil {
+			return err
 		}
 
 		// call handler
@@ -197,6 +230,114 @@ func (w *WAL) Replay(handler func(lsn uint64, recordTychan uint8, key, value []b
 	return nil
 }
 
+// ReplayFile reads the WAL file at path up to and including LSN upTo, without
+// opening it for writing. Records appended concurrently past upTo are never
+// read, so it is safe to use on the WAL of an open bucket.
+func ReplayFile(path string, upTo uint64, handler func(lsn uint64, recordType uint8, key, value []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		lsn, recordType, key, value, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if lsn > upTo {
+			return nil
+		}
+		if err := handler(lsn, recordType, key, value); err != nil {
+			return fmt.Errorf("error in handler: %v", err)
+		}
+	}
+}
+
+// ScanRecords walks the records in r, calling fn with each record's LSN and
+// offset (relative to the start of r). A truncated record at the end, as left
+// by a copy taken mid-append, ends the scan without an error. It returns the
+// number of bytes taken by complete records.
+func ScanRecords(r io.Reader, fn func(lsn uint64, offset int64)) (int64, error) {
+	br := bufio.NewReader(r)
+	var offset int64
+	header := make([]byte, 4+8)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+		totalLen := int64(binary.LittleEndian.Uint32(header))
+		if totalLen < 8+1+4+4 {
+			return offset, fmt.Errorf("corrupt record at offset %d", offset)
+		}
+		lsn := binary.LittleEndian.Uint64(header[4:])
+		if _, err := io.CopyN(io.Discard, br, totalLen-8); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+		if fn != nil {
+			fn(lsn, offset)
+		}
+		offset += 4 + totalLen
+	}
+}
+
+// readRecord reads the next record; io.EOF means the end of the log.
+func readRecord(r io.Reader) (lsn uint64, recordType uint8, key, value []byte, err error) {
+	// read total length
+	lenBuf := make([]byte, 4) // first 4 bytes are of each log record
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		if err == io.EOF {
+			return 0, 0, nil, nil, io.EOF
+		}
+		return 0, 0, nil, nil, fmt.Errorf("error reading total length: %v", err)
+	}
+
+	totalLen := binary.LittleEndian.Uint32(lenBuf)
+	recordBuf := make([]byte, totalLen)
+	if _, err := io.ReadFull(r, recordBuf); err != nil {
+		return 0, 0, nil, nil, fmt.Errorf("error reading record: %v", err)
+	}
+
+	curr := 0
+	// read LSN
+	lsn = binary.LittleEndian.Uint64(recordBuf[curr : curr+8])
+	curr += 8
+
+	// read record type
+	recordType = recordBuf[curr]
+	curr += 1
+
+	// read key size
+	keySize := binary.LittleEndian.Uint32(recordBuf[curr : curr+4])
+	curr += 4
+
+	// read value size
+	valueSize := binary.LittleEndian.Uint32(recordBuf[curr : curr+4])
+	curr += 4
+
+	// read key
+	key = make([]byte, keySize)
+	copy(key, recordBuf[curr:curr+int(keySize)])
+	curr += int(keySize)
+
+	// read value if present
+	if valueSize > 0 {
+		value = make([]byte, valueSize)
+		copy(value, recordBuf[curr:curr+int(valueSize)])
+	}
+	return lsn, recordType, key, value, nil
+}
+
 
 func (w * WAL) recoverLastLSN() error {
 	if w.f == nil {
@@ -0,0 +1,115 @@
+// Package memcached reads the memcached text protocol for the memcached
+// compatible listeners.
+package memcached
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// limits from memcached
+const (
+	MaxKeySize  = 250
+	MaxLineSize = 2048
+	MaxItemSize = 64 << 20
+)
+
+// relativeLimit is the largest exptime taken as seconds from now; anything
+// larger is a unix timestamp.
+const relativeLimit = 30 * 24 * 60 * 60
+
+// ErrLineTooLong is returned for a command line over MaxLineSize; the
+// connection can't be read any further.
+var ErrLineTooLong = errors.New("line too long")
+
+// Reader reads command lines and the data blocks of storage commands.
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, MaxLineSize)}
+}
+
+// Buffered is the number of bytes read from the connection but not parsed yet.
+func (r *Reader) Buffered() int {
+	return r.r.Buffered()
+}
+
+// ReadLine reads a command line without its line ending.
+func (r *Reader) ReadLine() (string, error) {
+	line, err := r.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", ErrLineTooLong
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return string(line), nil
+}
+
+// ReadData reads a data block of n bytes and the \r\n after it.
+func (r *Reader) ReadData(n int) ([]byte, error) {
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return nil, err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, errors.New("bad data chunk")
+	}
+	return buf[:n], nil
+}
+
+// Skip discards a data block of n bytes and the \r\n after it.
+func (r *Reader) Skip(n int) error {
+	_, err := r.r.Discard(n + 2)
+	return err
+}
+
+// ValidKey reports whether key can be used: at most MaxKeySize bytes and no
+// whitespace or control characters.
+func ValidKey(key string) bool {
+	if len(key) == 0 || len(key) > MaxKeySize {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// TTL converts an exptime to a TTL: 0 for none, seconds from now up to 30
+// days, a unix timestamp beyond that. expired is set for a negative exptime
+// or a timestamp that has passed, which memcached treats as already expired.
+func TTL(exptime string, now time.Time) (ttl time.Duration, expired bool, err error) {
+	n, err := strconv.ParseInt(exptime, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid exptime '%s'", exptime)
+	}
+	switch {
+	case n == 0:
+		return 0, false, nil
+	case n < 0:
+		return 0, true, nil
+	case n <= relativeLimit:
+		return time.Duration(n) * time.Second, false, nil
+	}
+	ttl = time.Unix(n, 0).Sub(now)
+	if ttl <= 0 {
+		return 0, true, nil
+	}
+	return ttl, false, nil
+}
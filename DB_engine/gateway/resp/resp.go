@@ -0,0 +1,317 @@
+// Package resp reads and writes the Redis serialization protocol, RESP2 and
+// RESP3, for the Redis compatible listener.
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// limits on what a client may send
+const (
+	MaxBulkSize  = 512 << 20 // the Redis default
+	MaxArraySize = 1 << 20
+	maxInline    = 64 << 10
+)
+
+// ErrProtocol is wrapped by errors about malformed input; the connection can't
+// be read any further after one.
+var ErrProtocol = errors.New("protocol error")
+
+func protocolError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrProtocol, fmt.Sprintf(format, args...))
+}
+
+// Reader reads commands.
+type Reader struct {
+	r *bufio.Reader
+
+	// MaxCommandSize bounds the bulk strings of one command together, 0
+	// leaves only MaxBulkSize for each.
+	MaxCommandSize int
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Buffered is the number of bytes already read from the connection but not yet
+// parsed; a writer can hold its replies back while more commands are waiting.
+func (r *Reader) Buffered() int {
+	return r.r.Buffered()
+}
+
+// ReadCommand reads a command and its arguments: an array of bulk strings, or
+// an inline command separated by spaces as typed into telnet. Blank inline
+// lines are skipped.
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '*' {
+			if args := bytes.Fields(line); len(args) > 0 {
+				return args, nil
+			}
+			continue
+		}
+
+		n, err := parseLength(line[1:], MaxArraySize)
+		if err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			continue // empty or null array
+		}
+		args := make([][]byte, n)
+		size := 0
+		for i := range args {
+			if args[i], err = r.readBulk(size); err != nil {
+				return nil, err
+			}
+			size += len(args[i])
+		}
+		return args, nil
+	}
+}
+
+// readBulk reads a bulk string of a command whose bulk strings before it hold
+// size bytes.
+func (r *Reader) readBulk(size int) ([]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, protocolError("expected '$', got %q", line)
+	}
+	n, err := parseLength(line[1:], MaxBulkSize)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, protocolError("invalid bulk length")
+	}
+	if r.MaxCommandSize > 0 && size+n > r.MaxCommandSize {
+		return nil, protocolError("command over the limit of %d bytes", r.MaxCommandSize)
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return nil, err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, protocolError("bulk string not terminated by CRLF")
+	}
+	return buf[:n], nil
+}
+
+// readLine reads a line without its CRLF; a bare LF is accepted for inline
+// commands.
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// longer than the buffer, only allowed up to maxInline
+		long := append([]byte{}, line...)
+		for err == bufio.ErrBufferFull && len(long) <= maxInline {
+			line, err = r.r.ReadSlice('\n')
+			long = append(long, line...)
+		}
+		if err == bufio.ErrBufferFull {
+			return nil, protocolError("line too long")
+		}
+		line = long
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+func parseLength(b []byte, max int) (int, error) {
+	n, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, protocolError("invalid length %q", b)
+	}
+	if n > max {
+		return 0, protocolError("length %d over the limit of %d", n, max)
+	}
+	return n, nil
+}
+
+// Writer writes replies. Proto is the protocol version agreed with HELLO, 2
+// unless the client asked for 3; it decides how nulls and maps are sent.
+type Writer struct {
+	w     *bufio.Writer
+	Proto int
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w), Proto: 2}
+}
+
+// Flush sends the buffered replies.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// SimpleString writes +s; s must not contain CR or LF.
+func (w *Writer) SimpleString(s string) {
+	w.w.WriteByte('+')
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// Error writes -message, where message starts with an error code such as ERR.
+func (w *Writer) Error(message string) {
+	w.w.WriteByte('-')
+	w.w.Write(bytes.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, []byte(message)))
+	w.w.WriteString("\r\n")
+}
+
+func (w *Writer) Integer(n int64) {
+	w.w.WriteByte(':')
+	w.w.WriteString(strconv.FormatInt(n, 10))
+	w.w.WriteString("\r\n")
+}
+
+func (w *Writer) Bulk(b []byte) {
+	w.header('$', len(b))
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+func (w *Writer) BulkString(s string) {
+	w.Bulk([]byte(s))
+}
+
+// Null writes a null bulk string in RESP2 and the null type in RESP3.
+func (w *Writer) Null() {
+	if w.Proto >= 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+	w.w.WriteString("$-1\r\n")
+}
+
+// Array starts an array of n elements, which the caller writes next.
+func (w *Writer) Array(n int) {
+	w.header('*', n)
+}
+
+// Map starts a map of n key/value pairs, sent as a flat array in RESP2.
+func (w *Writer) Map(n int) {
+	if w.Proto >= 3 {
+		w.header('%', n)
+		return
+	}
+	w.header('*', 2*n)
+}
+
+func (w *Writer) header(kind byte, n int) {
+	w.w.WriteByte(kind)
+	w.w.WriteString(strconv.Itoa(n))
+	w.w.WriteString("\r\n")
+}
+
+// Match reports whether s matches the glob pattern the way Redis does for
+// SCAN and KEYS: * any run of bytes, ? one byte, [abc], [^a], [a-z] a class,
+// and \ quoting the next byte.
+func Match(pattern, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if Match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			n, ok := matchClass(pattern, s[0])
+			if !ok {
+				return false
+			}
+			pattern, s = pattern[n:], s[1:]
+
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the class at the start of pattern and returns
+// the length of the class. An unterminated class runs to the end.
+func matchClass(pattern []byte, c byte) (int, bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			matched = matched || pattern[i] == c
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			i += 2
+		default:
+			matched = matched || pattern[i] == c
+		}
+	}
+	if i < len(pattern) {
+		i++ // the ]
+	}
+	return i, matched != negate
+}
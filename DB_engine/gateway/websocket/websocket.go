@@ -0,0 +1,268 @@
+// Package websocket implements the server side of the WebSocket protocol (RFC
+// 6455) on a hijacked net/http connection. A Conn is a net.Conn carrying a
+// byte stream: Read returns the payloads of the client's data frames one
+// after the other, and every Write is sent as one text message.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxFrameSize bounds a frame sent by a client.
+const MaxFrameSize = 64 << 20
+
+// acceptGUID is appended to the client's key in the handshake.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// close status codes
+const (
+	closeNormal      = 1000
+	closeProtocol    = 1002
+	closeTooLarge    = 1009
+	closeNoStatusSet = 1005
+)
+
+// ErrProtocol is returned by Read when the client broke the protocol; the
+// connection is closed with a protocol error.
+var ErrProtocol = errors.New("websocket protocol error")
+
+// Conn is an upgraded connection.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// read state, only used by the reading goroutine
+	remaining int64   // payload bytes left in the current data frame
+	mask      [4]byte // of the current data frame
+	maskPos   int
+	closed    bool // a close frame was received
+
+	writeMu   sync.Mutex // frames of Write, pongs and the close frame
+	closeOnce sync.Once
+}
+
+// Upgrade completes the WebSocket handshake of r. On failure it has answered
+// the request with an error status.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("not a websocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("invalid websocket key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection can't be upgraded", http.StatusInternalServerError)
+		return nil, errors.New("response writer does not support hijacking")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("hijack failed: %v", err)
+	}
+	// the server's deadlines for the request no longer apply
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, br: brw.Reader}, nil
+}
+
+// headerHas reports whether the comma separated header name holds token.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Read reads message payloads, answering pings on the way. It returns io.EOF
+// once the client closed the connection.
+func (c *Conn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.closed {
+			return 0, io.EOF
+		}
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= c.mask[c.maskPos&3]
+		c.maskPos++
+	}
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// nextFrame reads frame headers until one starts data, handling the control
+// frames before it.
+func (c *Conn) nextFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return err
+	}
+	fin, opcode := head[0]&0x80 != 0, head[0]&0x0f
+	masked, length := head[1]&0x80 != 0, int64(head[1]&0x7f)
+	if head[0]&0x70 != 0 || !masked {
+		// no extensions were negotiated, and clients must mask
+		return c.fail(closeProtocol)
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]) & (1<<63 - 1))
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return err
+	}
+
+	switch opcode {
+	case opText, opBinary, opContinuation:
+		if length > MaxFrameSize {
+			return c.fail(closeTooLarge)
+		}
+		c.remaining, c.mask, c.maskPos = length, mask, 0
+		return nil
+
+	case opClose, opPing, opPong:
+		if !fin || length > 125 {
+			return c.fail(closeProtocol)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i&3]
+		}
+		switch opcode {
+		case opPing:
+			return c.writeFrame(opPong, payload)
+		case opClose:
+			c.closed = true
+			status := uint16(closeNoStatusSet)
+			if len(payload) >= 2 {
+				status = binary.BigEndian.Uint16(payload)
+			}
+			if status == closeNoStatusSet {
+				status = closeNormal
+			}
+			c.sendClose(status)
+		}
+		return nil
+	}
+	return c.fail(closeProtocol)
+}
+
+// fail closes the connection with status.
+func (c *Conn) fail(status uint16) error {
+	c.sendClose(status)
+	c.conn.Close()
+	return ErrProtocol
+}
+
+// Write sends p as one text message.
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opText, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	buffers := net.Buffers{header, payload}
+	_, err := buffers.WriteTo(c.conn)
+	return err
+}
+
+// sendClose sends the close frame, once.
+func (c *Conn) sendClose(status uint16) {
+	c.closeOnce.Do(func() {
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeFrame(opClose, binary.BigEndian.AppendUint16(nil, status))
+	})
+}
+
+// Close sends a normal close frame and closes the connection.
+func (c *Conn) Close() error {
+	c.sendClose(closeNormal)
+	return c.conn.Close()
+}
+
+func (c *Conn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.conn.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
@@ -0,0 +1,442 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"byted/DB_engine/cmd/cli"
+	"byted/DB_engine/core/auth"
+	"byted/DB_engine/gateway/websocket"
+	"byted/DB_engine/structs"
+)
+
+// limits of the HTTP API
+const (
+	httpTokenTTL     = time.Hour // lifetime of a bearer token
+	httpDefaultLimit = 100       // keys per range page unless limit is given
+	httpMaxLimit     = 1000
+)
+
+// httpGateway serves the HTTP API. Every request authenticates on its own,
+// with Basic credentials of a user or a bearer token from POST /auth/token,
+// and runs as a structured request in a session of its own.
+type httpGateway struct {
+	s *Server
+
+	mutex  sync.Mutex
+	tokens map[string]httpToken
+}
+
+type httpToken struct {
+	user    string
+	expires time.Time
+}
+
+// httpError is the body of every error response.
+type httpError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// httpHandler routes the API:
+//
+//	POST   /auth/token                      bearer token for the Basic credentials
+//	DELETE /auth/token                      revoke the bearer token used
+//	GET    /buckets                         list buckets
+//	PUT    /buckets/{bucket}?key=value...   create, options as query parameters
+//	DELETE /buckets/{bucket}                drop into the trash
+//	GET    /buckets/{bucket}/keys/{key}     raw value, ETag is the key's LSN
+//	PUT    /buckets/{bucket}/keys/{key}     body is the value, ?ttl=<duration>
+//	DELETE /buckets/{bucket}/keys/{key}
+//	GET    /buckets/{bucket}/range?start=&end=&limit=
+//	GET    /ws                              WebSocket carrying the JSON protocol
+func (s *Server) httpHandler() http.Handler {
+	g := &httpGateway{s: s, tokens: make(map[string]httpToken)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth/token", g.handle(g.issueToken))
+	mux.HandleFunc("DELETE /auth/token", g.handle(g.revokeToken))
+	mux.HandleFunc("GET /buckets", g.handle(g.listBuckets))
+	mux.HandleFunc("PUT /buckets/{bucket}", g.handle(g.createBucket))
+	mux.HandleFunc("DELETE /buckets/{bucket}", g.handle(g.dropBucket))
+	mux.HandleFunc("GET /buckets/{bucket}/keys/{key...}", g.handle(g.getKey))
+	mux.HandleFunc("PUT /buckets/{bucket}/keys/{key...}", g.handle(g.putKey))
+	mux.HandleFunc("DELETE /buckets/{bucket}/keys/{key...}", g.handle(g.deleteKey))
+	mux.HandleFunc("GET /buckets/{bucket}/range", g.handle(g.rangeKeys))
+	mux.HandleFunc("GET /ws", g.websocket)
+	return mux
+}
+
+// handle runs an endpoint once the server is ready and the caller
+// authenticated, counted as an in-flight command for shutdown.
+func (g *httpGateway) handle(fn func(http.ResponseWriter, *http.Request, *ClientContext)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !g.s.isReady() {
+			w.Header().Set("Retry-After", "1")
+			writeHTTPError(w, http.StatusServiceUnavailable, structs.CodeInternal,
+				fmt.Sprintf("server is still recovering buckets (%d of %d done)", g.s.recovered.Load(), g.s.toLoad.Load()))
+			return
+		}
+		user, ok := g.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="bytedata"`)
+			writeHTTPError(w, http.StatusUnauthorized, "unauthorized", "valid credentials or a bearer token are required")
+			return
+		}
+		if !g.s.beginCommand() {
+			writeHTTPError(w, http.StatusServiceUnavailable, structs.CodeInternal, "server is shutting down")
+			return
+		}
+		defer g.s.inflight.Done()
+
+		fn(w, r, &ClientContext{
+			Session:    g.s.BucketManager.NewSession(),
+			User:       user,
+			RemoteAddr: r.RemoteAddr,
+		})
+	}
+}
+
+// authenticate returns the user of the request's Basic credentials or
+// bearer token.
+func (g *httpGateway) authenticate(r *http.Request) (string, bool) {
+	if user, password, ok := r.BasicAuth(); ok {
+		return user, auth.ValidateUser(user, password) == nil
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", false
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for t, info := range g.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			if time.Now().After(info.expires) {
+				delete(g.tokens, t)
+				return "", false
+			}
+			return info.user, true
+		}
+	}
+	return "", false
+}
+
+// execute runs req in the request's session and audits it.
+func (g *httpGateway) execute(ctx *ClientContext, req structs.Request) (*structs.Result, error) {
+	res, err := cli.Execute(ctx.Session, req)
+	auditRequest(ctx, nil, req, res, err)
+	return res, err
+}
+
+func (g *httpGateway) issueToken(w http.ResponseWriter, r *http.Request, ctx *ClientContext) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, structs.CodeInternal, "failed to generate token")
+		return
+	}
+	token := hex.EncodeToString(buf)
+	now := time.Now()
+
+	g.mutex.Lock()
+	for t, info := range g.tokens {
+		if now.After(info.expires) {
+			delete(g.tokens, t)
+		}
+	}
+	g.tokens[token] = httpToken{user: ctx.User, expires: now.Add(httpTokenTTL)}
+	g.mutex.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"token":      token,
+		"expires_in": int(httpTokenTTL.Seconds()),
+	})
+}
+
+func (g *httpGateway) revokeToken(w http.ResponseWriter, r *http.Request, ctx *ClientContext) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		writeHTTPError(w, http.StatusBadRequest, structs.CodeInvalid, "no bearer token to revoke")
+		return
+	}
+	g.mutex.Lock()
+	delete(g.tokens, token)
+	g.mutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *httpGateway) listBuckets(w http.ResponseWriter, r *http.Request, ctx *ClientContext) {
+	res, err := g.execute(ctx, structs.Request{Op: structs.OpList})
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	buckets := res.Buckets
+	if buckets == nil {
+		buckets = []structs.BucketInfo{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"buckets": buckets})
+}
+
+func (g *httpGateway) createBucket(w http.ResponseWriter, r *http.Request, ctx *ClientContext) {
+	req := structs.Request{Op: structs.OpCreate, Bucket: r.PathValue("bucket")}
+	for name, values := range r.URL.Query() {
+		for _, v := range values {
+			req.Options = append(req.Options, name+"="+v)
+		}
+	}
+	if _, err := g.execute(ctx, req); err != nil {
+		writeOpError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/buckets/"+url.PathEscape(req.Bucket))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (g *httpGateway) dropBucket(w http.ResponseWriter, r *http.Request, ctx *ClientContext) {
+	if _, err := g.execute(ctx, structs.Request{Op: structs.OpDrop, Bucket: r.PathValue("bucket")}); err != nil {
+		writeOpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *httpGateway) getKey(w http.ResponseWriter, r *http.Request, ctx *ClientContext) {
+	req, ok := keyRequest(w, r, structs.OpGet)
+	if !ok {
+		return
+	}
+	res, err := g.execute(ctx, req)
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	etag := lsnETag(res.LSN)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(res.Value)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(res.Value)
+}
+
+func (g *httpGateway) putKey(w http.ResponseWriter, r *http.Request, ctx *ClientContext) {
+	req, ok := keyRequest(w, r, structs.OpPut)
+	if !ok || !preconditions(w, r, &req) {
+		return
+	}
+	if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			writeHTTPError(w, http.StatusBadRequest, structs.CodeInvalid, fmt.Sprintf("invalid ttl '%s'", ttl))
+			return
+		}
+		req.TTL = max(d.Milliseconds(), 1)
+	}
+
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, g.s.maxBodySize()))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeHTTPError(w, http.StatusRequestEntityTooLarge, structs.CodeTooLarge,
+				fmt.Sprintf("request body exceeds the limit of %d bytes", tooLarge.Limit))
+		} else {
+			writeHTTPError(w, http.StatusBadRequest, structs.CodeInvalid, "failed to read the value")
+		}
+		return
+	}
+	req.Value = value
+
+	res, err := g.execute(ctx, req)
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	w.Header().Set("ETag", lsnETag(res.LSN))
+	if req.Cond == structs.CondAbsent {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (g *httpGateway) deleteKey(w http.ResponseWriter, r *http.Request, ctx *ClientContext) {
+	req, ok := keyRequest(w, r, structs.OpDelete)
+	if !ok || !preconditions(w, r, &req) {
+		return
+	}
+	if req.Cond == structs.CondAbsent {
+		writeHTTPError(w, http.StatusBadRequest, structs.CodeInvalid, "If-None-Match is not supported on DELETE")
+		return
+	}
+	if req.IfLSN == 0 {
+		// so a missing key is a 404
+		req.Cond = structs.CondExists
+	}
+	if _, err := g.execute(ctx, req); err != nil {
+		if req.IfLSN == 0 && cli.ErrorCode(err) == structs.CodeCondition {
+			writeHTTPError(w, http.StatusNotFound, structs.CodeNotFound, "key not found")
+			return
+		}
+		writeOpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *httpGateway) rangeKeys(w http.ResponseWriter, r *http.Request, ctx *ClientContext) {
+	query := r.URL.Query()
+	limit := httpDefaultLimit
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > httpMaxLimit {
+			writeHTTPError(w, http.StatusBadRequest, structs.CodeInvalid,
+				fmt.Sprintf("limit must be between 1 and %d", httpMaxLimit))
+			return
+		}
+		limit = n
+	}
+	bucket, start, end := r.PathValue("bucket"), query.Get("start"), query.Get("end")
+	if end != "" && start > end {
+		writeHTTPError(w, http.StatusBadRequest, structs.CodeInvalid, "start is after end")
+		return
+	}
+
+	res, err := g.execute(ctx, structs.Request{Op: structs.OpScan, Bucket: bucket, Key: []byte(start), Limit: limit})
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	pairs := res.Pairs
+	next := res.Next
+	if end != "" {
+		// end is inclusive, the scan stops at limit
+		for i, p := range pairs {
+			if bytes.Compare(p.Key, []byte(end)) > 0 {
+				pairs, next = pairs[:i], nil
+				break
+			}
+		}
+		if len(next) > 0 && bytes.Compare(next, []byte(end)) > 0 {
+			next = nil
+		}
+	}
+	if pairs == nil {
+		pairs = []structs.KVPair{}
+	}
+
+	body := map[string]any{"pairs": pairs}
+	if len(next) > 0 {
+		v := url.Values{"start": {string(next)}, "limit": {strconv.Itoa(limit)}}
+		if end != "" {
+			v.Set("end", end)
+		}
+		body["next"] = "/buckets/" + url.PathEscape(bucket) + "/range?" + v.Encode()
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// websocket upgrades the request and serves the same JSON message protocol
+// as the TCP listener on it, starting with its authentication.
+func (g *httpGateway) websocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	// the HTTP listener already counted the connection
+	g.s.serveAdmitted(conn, g.s.handleConnection, refuseJSON)
+}
+
+// keyRequest is the request of op on the key in the path.
+func keyRequest(w http.ResponseWriter, r *http.Request, op string) (structs.Request, bool) {
+	key := r.PathValue("key")
+	if key == "" {
+		writeHTTPError(w, http.StatusBadRequest, structs.CodeInvalid, "empty key")
+		return structs.Request{}, false
+	}
+	return structs.Request{Op: op, Bucket: r.PathValue("bucket"), Key: []byte(key)}, true
+}
+
+// preconditions maps If-Match and If-None-Match onto the request: an ETag
+// becomes IfLSN, If-Match: * CondExists and If-None-Match: * CondAbsent.
+func preconditions(w http.ResponseWriter, r *http.Request, req *structs.Request) bool {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	switch {
+	case ifMatch != "" && ifNoneMatch != "":
+		writeHTTPError(w, http.StatusBadRequest, structs.CodeInvalid, "If-Match and If-None-Match can't be combined")
+		return false
+	case ifMatch == "*":
+		req.Cond = structs.CondExists
+	case ifMatch != "":
+		lsn, ok := parseETag(ifMatch)
+		if !ok {
+			writeHTTPError(w, http.StatusPreconditionFailed, structs.CodeCondition, "If-Match does not name a version of the key")
+			return false
+		}
+		req.IfLSN = lsn
+	case ifNoneMatch == "*":
+		req.Cond = structs.CondAbsent
+	case ifNoneMatch != "":
+		writeHTTPError(w, http.StatusBadRequest, structs.CodeInvalid, "only If-None-Match: * is supported on writes")
+		return false
+	}
+	return true
+}
+
+func lsnETag(lsn uint64) string {
+	return `"` + strconv.FormatUint(lsn, 10) + `"`
+}
+
+// parseETag reads an ETag made by lsnETag. Weak ETags never match.
+func parseETag(etag string) (uint64, bool) {
+	if len(etag) < 3 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return 0, false
+	}
+	lsn, err := strconv.ParseUint(etag[1:len(etag)-1], 10, 64)
+	return lsn, err == nil && lsn != 0
+}
+
+// writeOpError answers with the status closest to the error's protocol code.
+func writeOpError(w http.ResponseWriter, err error) {
+	code := cli.ErrorCode(err)
+	status := http.StatusInternalServerError
+	switch code {
+	case structs.CodeInvalid, structs.CodeUnknownOp, structs.CodeNoBucket:
+		status = http.StatusBadRequest
+	case structs.CodeNotFound, structs.CodeNoSuchBucket:
+		status = http.StatusNotFound
+	case structs.CodeExists, structs.CodeReadOnly:
+		status = http.StatusConflict
+	case structs.CodeTooLarge:
+		status = http.StatusRequestEntityTooLarge
+	case structs.CodeCondition:
+		status = http.StatusPreconditionFailed
+	case structs.CodeUnavailable:
+		status = http.StatusServiceUnavailable
+	}
+	writeHTTPError(w, status, code, err.Error())
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, httpError{Code: code, Message: message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"byted/DB_engine/structs"
+)
+
+// maxAuthMessage bounds the messages of a client that has not logged in yet,
+// whatever max_message_size allows.
+const maxAuthMessage = 64 << 10
+
+// farewellTimeout is how long the last message to a client that hit a limit
+// may take to go out.
+const farewellTimeout = time.Second
+
+// farewellDrain bounds what is read from such a client before closing.
+const farewellDrain = 1 << 20
+
+// errMessageTooLarge is returned by a messageReader once the message being
+// decoded has outgrown its limit.
+var errMessageTooLarge = errors.New("message too large")
+
+// messageReader sits under a connection's decoder and caps the bytes read for
+// one message; set starts the next one. Bytes the decoder buffered ahead are
+// not counted again, so a message can overshoot by at most one read.
+type messageReader struct {
+	r         io.Reader
+	limit     int64 // 0 = unlimited
+	remaining int64
+	exceeded  bool
+}
+
+func (m *messageReader) set(limit int64) {
+	m.limit, m.remaining = limit, limit
+}
+
+func (m *messageReader) Read(p []byte) (int, error) {
+	if m.limit == 0 {
+		return m.r.Read(p)
+	}
+	if m.remaining <= 0 {
+		m.exceeded = true
+		return 0, errMessageTooLarge
+	}
+	if int64(len(p)) > m.remaining {
+		p = p[:m.remaining]
+	}
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	return n, err
+}
+
+// authLimit is the message limit before login.
+func (s *Server) authLimit() int64 {
+	if limit := s.Config.MaxMessageSize; limit > 0 && limit < maxAuthMessage {
+		return int64(limit)
+	}
+	return maxAuthMessage
+}
+
+// maxBodySize is the largest HTTP request body read.
+func (s *Server) maxBodySize() int64 {
+	if s.Config.MaxMessageSize > 0 {
+		return int64(s.Config.MaxMessageSize)
+	}
+	return math.MaxInt64
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// farewell sends msg to a client whose connection is about to be closed for
+// hitting a limit. The read deadline that expired may have taken the write
+// deadline with it. What the client still sends is read for a moment after:
+// closing with unread data resets the connection, which can drop msg.
+func farewell(conn net.Conn, send func(structs.Message) error, msg structs.Message) {
+	conn.SetWriteDeadline(time.Now().Add(farewellTimeout))
+	if send(msg) != nil {
+		return
+	}
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok && cw.CloseWrite() == nil {
+		conn.SetReadDeadline(time.Now().Add(farewellTimeout))
+		io.Copy(io.Discard, io.LimitReader(conn, farewellDrain))
+	}
+}
+
+// tooLargeMessage is the reply to a message over limit bytes.
+func tooLargeMessage(limit int64) structs.Message {
+	return structs.Message{
+		Type:    "error",
+		Code:    structs.CodeTooLarge,
+		Message: fmt.Sprintf("message exceeds the limit of %d bytes, closing the connection", limit),
+	}
+}
+
+// admit takes a connection slot for conn and counts it against its IP
+// address. When a limit is hit it returns the reason the client is refused.
+func (s *Server) admit(conn net.Conn) (string, bool) {
+	if !s.acquireSlot() {
+		return "server busy: too many connections", false
+	}
+	ip := clientIP(conn)
+	if !s.acquireIP(ip) {
+		s.releaseSlot()
+		return fmt.Sprintf("too many connections from %s", ip), false
+	}
+	return "", true
+}
+
+// release gives back what admit took for conn.
+func (s *Server) release(conn net.Conn) {
+	s.releaseIP(clientIP(conn))
+	s.releaseSlot()
+}
+
+// clientIP is the IP address conn comes from, "" for Unix sockets which are
+// not limited per address.
+func clientIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+func (s *Server) acquireIP(ip string) bool {
+	if s.Config.MaxConnsPerIP <= 0 || ip == "" {
+		return true
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.perIP[ip] >= s.Config.MaxConnsPerIP {
+		return false
+	}
+	s.perIP[ip]++
+	return true
+}
+
+func (s *Server) releaseIP(ip string) {
+	if s.Config.MaxConnsPerIP <= 0 || ip == "" {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.perIP[ip]--; s.perIP[ip] <= 0 {
+		delete(s.perIP, ip)
+	}
+}
+
+// limitListener holds the HTTP listener's connections to the same limits as
+// the other listeners, answering the ones turned away with a 503.
+type limitListener struct {
+	net.Listener
+	s *Server
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if reason, ok := l.s.admit(conn); !ok {
+			go refuseHTTP(conn, reason)
+			continue
+		}
+		return &admittedConn{Conn: conn, release: func() { l.s.release(conn) }}, nil
+	}
+}
+
+// admittedConn releases its slot once closed, by the HTTP server or, after a
+// WebSocket upgrade, by the session.
+type admittedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *admittedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// refuseHTTP answers the client's first request with 503 and closes conn.
+// The request is read first so closing doesn't reset the connection under
+// the response.
+func refuseHTTP(conn net.Conn, reason string) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(farewellTimeout))
+	if req, err := http.ReadRequest(bufio.NewReader(conn)); err == nil {
+		io.Copy(io.Discard, io.LimitReader(req.Body, maxAuthMessage))
+	}
+	body, _ := json.Marshal(httpError{Code: structs.CodeLimit, Message: reason})
+	fmt.Fprintf(conn, "HTTP/1.1 503 Service Unavailable\r\nContent-Type: application/json\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		len(body), body)
+}
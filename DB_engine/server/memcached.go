@@ -0,0 +1,403 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"byted/DB_engine/cmd/cli"
+	"byted/DB_engine/gateway/memcached"
+	"byted/DB_engine/structs"
+)
+
+// memcachedVersion is the version reported by the version command.
+const memcachedVersion = "1.6.0"
+
+// MemcachedListener is a memcached protocol listener and the bucket its
+// clients work on.
+type MemcachedListener struct {
+	net.Listener
+	Bucket string
+}
+
+// memcachedConn is a client of a memcached listener. The text protocol has
+// no authentication, so a listener should only be reachable by trusted
+// clients; its commands run as structured requests on the listener's bucket
+// and are audited as user "memcached". CAS values are the per-key LSNs, the
+// flags are stored with the value, and an exptime of 0 stores with the
+// bucket's default TTL.
+type memcachedConn struct {
+	s      *Server
+	ctx    *ClientContext
+	bucket string
+	r      *memcached.Reader
+	w      *bufio.Writer
+}
+
+func refuseMemcached(conn net.Conn, msg structs.Message) {
+	fmt.Fprintf(conn, "SERVER_ERROR %s\r\n", msg.Message)
+}
+
+// handleMemcached returns the connection handler of a listener for bucket.
+// Replies go out in order, flushed once no further pipelined command is
+// waiting.
+func (s *Server) handleMemcached(bucket string) func(net.Conn) {
+	return func(conn net.Conn) {
+		if !s.isReady() {
+			fmt.Fprintf(conn, "SERVER_ERROR server is still recovering buckets (%d of %d done)\r\n", s.recovered.Load(), s.toLoad.Load())
+			return
+		}
+
+		c := &memcachedConn{
+			s: s,
+			ctx: &ClientContext{
+				Session:    s.BucketManager.NewSession(),
+				User:       "memcached",
+				RemoteAddr: conn.RemoteAddr().String(),
+				conn:       conn,
+			},
+			bucket: bucket,
+			r:      memcached.NewReader(conn),
+			w:      bufio.NewWriter(conn),
+		}
+
+		for {
+			if s.Config.IdleTimeout.Duration > 0 {
+				conn.SetReadDeadline(time.Now().Add(s.Config.IdleTimeout.Duration))
+			}
+			line, err := c.r.ReadLine()
+			if err != nil {
+				if errors.Is(err, memcached.ErrLineTooLong) {
+					c.w.WriteString("CLIENT_ERROR line too long\r\n")
+					c.w.Flush()
+				}
+				return
+			}
+
+			if !s.beginCommand() {
+				c.w.WriteString("SERVER_ERROR server is shutting down\r\n")
+				c.w.Flush()
+				return
+			}
+			quit := c.command(line)
+			s.inflight.Done()
+
+			if quit || c.r.Buffered() == 0 {
+				if err := c.w.Flush(); err != nil {
+					return
+				}
+			}
+			if quit {
+				return
+			}
+		}
+	}
+}
+
+// command runs one command line and writes its reply; true when the
+// connection is to be closed.
+func (c *memcachedConn) command(line string) bool {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		c.reply("ERROR")
+		return false
+	}
+	name, args := args[0], args[1:]
+
+	switch name {
+	case "get", "gets":
+		c.get(args, name == "gets")
+	case "set", "add", "replace", "cas":
+		return c.store(name, args)
+	case "delete":
+		c.delete(args)
+	case "incr", "decr":
+		c.incr(name, args)
+	case "touch":
+		c.touch(args)
+	case "version":
+		c.reply("VERSION " + memcachedVersion)
+	case "quit":
+		return true
+	default:
+		c.reply("ERROR")
+	}
+	return false
+}
+
+func (c *memcachedConn) reply(line string) {
+	c.w.WriteString(line)
+	c.w.WriteString("\r\n")
+}
+
+// noreply strips a trailing noreply from args.
+func noreply(args []string) ([]string, bool) {
+	if n := len(args); n > 0 && args[n-1] == "noreply" {
+		return args[:n-1], true
+	}
+	return args, false
+}
+
+// execute runs req on the listener's bucket and audits it.
+func (c *memcachedConn) execute(req structs.Request) (*structs.Result, error) {
+	req.Bucket = c.bucket
+	res, err := cli.Execute(c.ctx.Session, req)
+	auditRequest(c.ctx, nil, req, res, err)
+	return res, err
+}
+
+// serverError is the reply to an error no command has its own reply for.
+func serverError(err error) string {
+	if cli.ErrorCode(err) == structs.CodeTooLarge {
+		return "SERVER_ERROR object too large for cache"
+	}
+	return "SERVER_ERROR " + err.Error()
+}
+
+// get <key>*
+// gets <key>*
+func (c *memcachedConn) get(keys []string, withCAS bool) {
+	if len(keys) == 0 {
+		c.reply("ERROR")
+		return
+	}
+	for _, key := range keys {
+		if !memcached.ValidKey(key) {
+			c.reply("CLIENT_ERROR bad command line format")
+			return
+		}
+	}
+	for _, key := range keys {
+		res, err := c.execute(structs.Request{Op: structs.OpGet, Key: []byte(key)})
+		if err != nil {
+			if cli.ErrorCode(err) == structs.CodeNotFound {
+				continue
+			}
+			c.reply(serverError(err))
+			return
+		}
+		if withCAS {
+			fmt.Fprintf(c.w, "VALUE %s %d %d %d\r\n", key, res.Flags, len(res.Value), res.LSN)
+		} else {
+			fmt.Fprintf(c.w, "VALUE %s %d %d\r\n", key, res.Flags, len(res.Value))
+		}
+		c.w.Write(res.Value)
+		c.w.WriteString("\r\n")
+	}
+	c.reply("END")
+}
+
+// set|add|replace <key> <flags> <exptime> <bytes> [noreply]
+// cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func (c *memcachedConn) store(name string, args []string) bool {
+	args, quiet := noreply(args)
+	want := 4
+	if name == "cas" {
+		want = 5
+	}
+	if len(args) != want {
+		c.reply("ERROR")
+		return false
+	}
+	size, err := strconv.Atoi(args[3])
+	if err != nil || size < 0 {
+		c.reply("CLIENT_ERROR bad command line format")
+		return false
+	}
+	if size > memcached.MaxItemSize || (c.s.Config.MaxMessageSize > 0 && size > c.s.Config.MaxMessageSize) {
+		c.reply("SERVER_ERROR object too large for cache")
+		return c.r.Skip(size) != nil
+	}
+	value, err := c.r.ReadData(size)
+	if err != nil {
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			c.reply("CLIENT_ERROR bad data chunk")
+		}
+		return true
+	}
+
+	key := args[0]
+	flags, flagsErr := strconv.ParseUint(args[1], 10, 32)
+	ttl, expired, ttlErr := memcached.TTL(args[2], time.Now())
+	var cas uint64
+	var casErr error
+	if name == "cas" {
+		cas, casErr = strconv.ParseUint(args[4], 10, 64)
+	}
+	if !memcached.ValidKey(key) || flagsErr != nil || ttlErr != nil || casErr != nil {
+		c.reply("CLIENT_ERROR bad command line format")
+		return false
+	}
+
+	req := structs.Request{Op: structs.OpPut, Key: []byte(key), Value: value, TTL: ttl.Milliseconds(), Flags: uint32(flags)}
+	if ttl > 0 && req.TTL == 0 {
+		req.TTL = 1
+	}
+	switch name {
+	case "add":
+		req.Cond = structs.CondAbsent
+	case "replace":
+		req.Cond = structs.CondExists
+	case "cas":
+		if cas == 0 {
+			// no item ever has version 0
+			c.storeReply(quiet, "EXISTS")
+			return false
+		}
+		req.IfLSN = cas
+	}
+	if expired {
+		// storing an already expired item leaves the key without a value
+		c.storeExpired(req, quiet)
+		return false
+	}
+
+	_, err = c.execute(req)
+	switch {
+	case err == nil:
+		c.storeReply(quiet, "STORED")
+	case cli.ErrorCode(err) == structs.CodeCondition && name == "cas":
+		c.storeReply(quiet, "EXISTS")
+	case cli.ErrorCode(err) == structs.CodeCondition:
+		c.storeReply(quiet, "NOT_STORED")
+	case cli.ErrorCode(err) == structs.CodeNotFound:
+		c.storeReply(quiet, "NOT_FOUND")
+	default:
+		c.storeReply(quiet, serverError(err))
+	}
+	return false
+}
+
+// storeExpired carries out a store of an item that has already expired: a
+// delete under the same condition.
+func (c *memcachedConn) storeExpired(put structs.Request, quiet bool) {
+	req := structs.Request{Op: structs.OpDelete, Key: put.Key, IfLSN: put.IfLSN}
+	if put.Cond == structs.CondAbsent {
+		// add succeeds only on a missing key, which stays missing
+		if _, err := c.execute(structs.Request{Op: structs.OpGet, Key: put.Key}); err == nil {
+			c.storeReply(quiet, "NOT_STORED")
+		} else if cli.ErrorCode(err) == structs.CodeNotFound {
+			c.storeReply(quiet, "STORED")
+		} else {
+			c.storeReply(quiet, serverError(err))
+		}
+		return
+	}
+	if put.Cond == "" && put.IfLSN == 0 {
+		_, err := c.execute(req)
+		if err != nil && cli.ErrorCode(err) != structs.CodeNotFound {
+			c.storeReply(quiet, serverError(err))
+			return
+		}
+		c.storeReply(quiet, "STORED")
+		return
+	}
+
+	if put.IfLSN == 0 {
+		req.Cond = structs.CondExists
+	}
+	_, err := c.execute(req)
+	switch {
+	case err == nil:
+		c.storeReply(quiet, "STORED")
+	case cli.ErrorCode(err) == structs.CodeCondition && put.IfLSN != 0:
+		c.storeReply(quiet, "EXISTS")
+	case cli.ErrorCode(err) == structs.CodeCondition:
+		c.storeReply(quiet, "NOT_STORED")
+	case cli.ErrorCode(err) == structs.CodeNotFound:
+		c.storeReply(quiet, "NOT_FOUND")
+	default:
+		c.storeReply(quiet, serverError(err))
+	}
+}
+
+// storeReply writes the reply to a command unless it was sent with noreply.
+func (c *memcachedConn) storeReply(quiet bool, line string) {
+	if !quiet {
+		c.reply(line)
+	}
+}
+
+// delete <key> [0] [noreply]
+func (c *memcachedConn) delete(args []string) {
+	args, quiet := noreply(args)
+	if len(args) == 2 && args[1] == "0" {
+		// the old hold time, only 0 is still accepted
+		args = args[:1]
+	}
+	if len(args) != 1 || !memcached.ValidKey(args[0]) {
+		c.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+	_, err := c.execute(structs.Request{Op: structs.OpDelete, Key: []byte(args[0]), Cond: structs.CondExists})
+	switch {
+	case err == nil:
+		c.storeReply(quiet, "DELETED")
+	case cli.ErrorCode(err) == structs.CodeCondition:
+		c.storeReply(quiet, "NOT_FOUND")
+	default:
+		c.storeReply(quiet, serverError(err))
+	}
+}
+
+// incr|decr <key> <value> [noreply]
+func (c *memcachedConn) incr(name string, args []string) {
+	args, quiet := noreply(args)
+	if len(args) != 2 || !memcached.ValidKey(args[0]) {
+		c.reply("ERROR")
+		return
+	}
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.reply("CLIENT_ERROR invalid numeric delta argument")
+		return
+	}
+	req := structs.Request{Op: structs.OpIncr, Key: []byte(args[0]), Delta: delta, Decr: name == "decr"}
+	res, err := c.execute(req)
+	switch {
+	case err == nil:
+		c.storeReply(quiet, string(res.Value))
+	case cli.ErrorCode(err) == structs.CodeNotFound:
+		c.storeReply(quiet, "NOT_FOUND")
+	case cli.ErrorCode(err) == structs.CodeInvalid:
+		c.storeReply(quiet, "CLIENT_ERROR cannot increment or decrement non-numeric value")
+	default:
+		c.storeReply(quiet, serverError(err))
+	}
+}
+
+// touch <key> <exptime> [noreply]
+func (c *memcachedConn) touch(args []string) {
+	args, quiet := noreply(args)
+	if len(args) != 2 || !memcached.ValidKey(args[0]) {
+		c.reply("ERROR")
+		return
+	}
+	ttl, expired, err := memcached.TTL(args[1], time.Now())
+	if err != nil {
+		c.reply("CLIENT_ERROR invalid exptime argument")
+		return
+	}
+
+	req := structs.Request{Op: structs.OpTouch, Key: []byte(args[0]), TTL: ttl.Milliseconds()}
+	if ttl > 0 && req.TTL == 0 {
+		req.TTL = 1
+	}
+	if expired {
+		req = structs.Request{Op: structs.OpDelete, Key: req.Key, Cond: structs.CondExists}
+	}
+	_, err = c.execute(req)
+	switch {
+	case err == nil:
+		c.storeReply(quiet, "TOUCHED")
+	case cli.ErrorCode(err) == structs.CodeNotFound, cli.ErrorCode(err) == structs.CodeCondition:
+		c.storeReply(quiet, "NOT_FOUND")
+	default:
+		c.storeReply(quiet, serverError(err))
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+// No SO_PEERCRED on this platform; Unix socket clients log in with a password.
+const peerCredentials = false
+
+func peerUID(conn *net.UnixConn) (uint32, error) {
+	return 0, errors.New("peer credentials are only supported on Linux")
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"strings"
+	"sync"
+
+	"byted/DB_engine/structs"
+)
+
+// maxInFlight bounds the pipelined messages a connection may have queued or
+// running; the server stops reading from the client until one completes.
+const maxInFlight = 1024
+
+// pipeline runs a connection's pipelined messages. Each bucket has its own
+// worker, so messages on one bucket run in the order they arrived while
+// replies for different buckets may overtake each other.
+type pipeline struct {
+	s       *Server
+	ctx     *ClientContext
+	workers map[string]chan structs.Message
+	slots   chan struct{}
+	pending sync.WaitGroup
+}
+
+func newPipeline(s *Server, ctx *ClientContext) *pipeline {
+	return &pipeline{
+		s:       s,
+		ctx:     ctx,
+		workers: make(map[string]chan structs.Message),
+		slots:   make(chan struct{}, maxInFlight),
+	}
+}
+
+// pipelineKey is the bucket a message is ordered on, or false when the message
+// has to run in order with everything else: it carries no ID, changes the
+// session or spans buckets.
+func pipelineKey(ctx *ClientContext, msg structs.Message) (string, bool) {
+	if msg.ID == 0 {
+		return "", false // the client waits for each reply
+	}
+	active, _ := ctx.Session.GetActiveBucket()
+
+	switch msg.Type {
+	case "request":
+		if msg.Request == nil {
+			return "", false
+		}
+		switch msg.Request.Op {
+		case structs.OpPut, structs.OpGet, structs.OpDelete, structs.OpRange, structs.OpScan,
+			structs.OpIncr, structs.OpTouch:
+			if msg.Request.Bucket != "" {
+				return msg.Request.Bucket, true
+			}
+			if active != nil {
+				return active.Name, true
+			}
+		}
+
+	case "command":
+		if active == nil {
+			return "", false
+		}
+		fields := strings.Fields(msg.Command)
+		if len(fields) == 0 {
+			return "", false
+		}
+		switch fields[0] {
+		case "put", "get", "del", "delete", "range", "describe", "stats":
+			return active.Name, true
+		}
+	}
+	return "", false
+}
+
+// submit queues msg on the worker for bucket, starting one if needed. The
+// caller has already counted msg as in flight.
+func (p *pipeline) submit(bucket string, msg structs.Message) {
+	p.slots <- struct{}{}
+	p.pending.Add(1)
+
+	queue, ok := p.workers[bucket]
+	if !ok {
+		queue = make(chan structs.Message, maxInFlight)
+		p.workers[bucket] = queue
+		go p.work(queue)
+	}
+	queue <- msg
+}
+
+func (p *pipeline) work(queue chan structs.Message) {
+	for msg := range queue {
+		p.s.execute(p.ctx, msg)
+		p.s.inflight.Done()
+		<-p.slots
+		p.pending.Done()
+	}
+}
+
+// wait returns once every pipelined message has been answered.
+func (p *pipeline) wait() {
+	p.pending.Wait()
+}
+
+// close finishes the queued messages and stops the workers.
+func (p *pipeline) close() {
+	for _, queue := range p.workers {
+		close(queue)
+	}
+	p.pending.Wait()
+}
@@ -0,0 +1,475 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"byted/DB_engine/cmd/cli"
+	"byted/DB_engine/core/auth"
+	"byted/DB_engine/gateway/resp"
+	"byted/DB_engine/structs"
+)
+
+// respVersion is the Redis version reported to clients that check it.
+const respVersion = "7.0.0"
+
+// maxScanCursors bounds the SCAN cursors a connection keeps; older ones are
+// forgotten.
+const maxScanCursors = 64
+
+// respConn is a client of the Redis protocol listener. Buckets take the
+// place of Redis databases: SELECT <bucket> picks the one GET, SET, DEL,
+// EXISTS and SCAN work on, and the commands run as structured requests.
+type respConn struct {
+	s      *Server
+	ctx    *ClientContext // session and audit identity, never sent the shutdown notice
+	r      *resp.Reader
+	w      *resp.Writer
+	authed bool
+
+	cursors    map[uint64][]byte // SCAN cursor -> key to continue from
+	lastCursor uint64
+}
+
+func refuseRESP(conn net.Conn, msg structs.Message) {
+	fmt.Fprintf(conn, "-ERR %s\r\n", msg.Message)
+}
+
+// handleRESP serves a Redis protocol client. Replies go out in order, flushed
+// once no further pipelined command is waiting.
+func (s *Server) handleRESP(conn net.Conn) {
+	if !s.isReady() {
+		fmt.Fprintf(conn, "-LOADING server is still recovering buckets (%d of %d done)\r\n", s.recovered.Load(), s.toLoad.Load())
+		return
+	}
+
+	c := &respConn{
+		s: s,
+		ctx: &ClientContext{
+			Session:    s.BucketManager.NewSession(),
+			RemoteAddr: conn.RemoteAddr().String(),
+			conn:       conn,
+		},
+		r:       resp.NewReader(conn),
+		w:       resp.NewWriter(conn),
+		cursors: make(map[uint64][]byte),
+	}
+	c.r.MaxCommandSize = s.Config.MaxMessageSize
+	if s.Config.AuthTimeout.Duration > 0 {
+		conn.SetDeadline(time.Now().Add(s.Config.AuthTimeout.Duration))
+	}
+
+	for {
+		if c.authed && s.Config.IdleTimeout.Duration > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.Config.IdleTimeout.Duration))
+		}
+		args, err := c.r.ReadCommand()
+		if err != nil {
+			if errors.Is(err, resp.ErrProtocol) {
+				c.w.Error("ERR " + err.Error())
+				c.w.Flush()
+			}
+			return
+		}
+
+		if !s.beginCommand() {
+			c.w.Error("ERR server is shutting down")
+			c.w.Flush()
+			return
+		}
+		quit := c.command(args)
+		s.inflight.Done()
+
+		if quit || c.r.Buffered() == 0 {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// command runs one command and writes its reply; true when the client quit.
+func (c *respConn) command(args [][]byte) bool {
+	name := strings.ToUpper(string(args[0]))
+	args = args[1:]
+
+	// allowed before AUTH
+	switch name {
+	case "PING":
+		if len(args) > 1 {
+			c.arity(name)
+		} else if len(args) == 1 {
+			c.w.Bulk(args[0])
+		} else {
+			c.w.SimpleString("PONG")
+		}
+		return false
+	case "QUIT":
+		c.w.SimpleString("OK")
+		return true
+	case "AUTH":
+		c.auth(args)
+		return false
+	case "HELLO":
+		c.hello(args)
+		return false
+	}
+
+	if !c.authed {
+		c.w.Error("NOAUTH Authentication required.")
+		return false
+	}
+
+	switch name {
+	case "ECHO":
+		if len(args) != 1 {
+			c.arity(name)
+			return false
+		}
+		c.w.Bulk(args[0])
+	case "SELECT":
+		if len(args) != 1 {
+			c.arity(name)
+			return false
+		}
+		if _, err := c.execute(structs.Request{Op: structs.OpUse, Bucket: string(args[0])}); err != nil {
+			c.error(err)
+			return false
+		}
+		c.w.SimpleString("OK")
+	case "GET":
+		if len(args) != 1 {
+			c.arity(name)
+			return false
+		}
+		res, err := c.execute(structs.Request{Op: structs.OpGet, Key: args[0]})
+		switch {
+		case cli.ErrorCode(err) == structs.CodeNotFound:
+			c.w.Null()
+		case err != nil:
+			c.error(err)
+		default:
+			c.w.Bulk(res.Value)
+		}
+	case "SET":
+		c.set(args)
+	case "DEL", "EXISTS":
+		if len(args) == 0 {
+			c.arity(name)
+			return false
+		}
+		c.count(name, args)
+	case "SCAN":
+		c.scan(args)
+	case "INFO":
+		c.info()
+	case "COMMAND":
+		// redis-cli asks for the command table on start; an empty one is fine
+		c.w.Array(0)
+	case "CLIENT":
+		if len(args) > 0 {
+			switch strings.ToUpper(string(args[0])) {
+			case "SETNAME", "SETINFO":
+				c.w.SimpleString("OK")
+				return false
+			}
+		}
+		c.w.Error("ERR unsupported CLIENT subcommand")
+	default:
+		c.w.Error(fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(name)))
+	}
+	return false
+}
+
+func (c *respConn) arity(name string) {
+	c.w.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+// execute runs req in the connection's session and audits it.
+func (c *respConn) execute(req structs.Request) (*structs.Result, error) {
+	active, _ := c.ctx.Session.GetActiveBucket()
+	res, err := cli.Execute(c.ctx.Session, req)
+	auditRequest(c.ctx, active, req, res, err)
+	return res, err
+}
+
+// error writes err with the Redis error code closest to its protocol code.
+func (c *respConn) error(err error) {
+	switch cli.ErrorCode(err) {
+	case structs.CodeNoBucket:
+		c.w.Error("ERR no bucket selected, SELECT <bucket> first")
+	case structs.CodeReadOnly:
+		c.w.Error("READONLY " + err.Error())
+	default:
+		c.w.Error("ERR " + err.Error())
+	}
+}
+
+// AUTH <username> <password>
+func (c *respConn) auth(args [][]byte) {
+	if len(args) != 2 {
+		c.w.Error("ERR ByteData has no default user, use AUTH <username> <password>")
+		return
+	}
+	if !c.login(string(args[0]), string(args[1])) {
+		c.w.Error("WRONGPASS invalid username-password pair or user is disabled.")
+		return
+	}
+	c.w.SimpleString("OK")
+}
+
+func (c *respConn) login(user, password string) bool {
+	if err := auth.ValidateUser(user, password); err != nil {
+		return false
+	}
+	c.authed = true
+	c.ctx.User = user
+	c.ctx.conn.SetDeadline(time.Time{})
+	return true
+}
+
+// HELLO [protover [AUTH <username> <password>] [SETNAME <name>]]
+func (c *respConn) hello(args [][]byte) {
+	proto := c.w.Proto
+	if len(args) > 0 {
+		n, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			c.w.Error("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if n < 2 || n > 3 {
+			c.w.Error("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = n
+		args = args[1:]
+	}
+	for len(args) > 0 {
+		switch strings.ToUpper(string(args[0])) {
+		case "AUTH":
+			if len(args) < 3 {
+				c.w.Error("ERR syntax error")
+				return
+			}
+			if !c.login(string(args[1]), string(args[2])) {
+				c.w.Error("WRONGPASS invalid username-password pair or user is disabled.")
+				return
+			}
+			args = args[3:]
+		case "SETNAME":
+			if len(args) < 2 {
+				c.w.Error("ERR syntax error")
+				return
+			}
+			args = args[2:]
+		default:
+			c.w.Error("ERR syntax error")
+			return
+		}
+	}
+	if !c.authed {
+		c.w.Error("NOAUTH HELLO must be called with the client already authenticated, use HELLO <proto> AUTH <username> <password>")
+		return
+	}
+
+	c.w.Proto = proto
+	c.w.Map(6)
+	c.w.BulkString("server")
+	c.w.BulkString("bytedata")
+	c.w.BulkString("version")
+	c.w.BulkString(respVersion)
+	c.w.BulkString("proto")
+	c.w.Integer(int64(proto))
+	c.w.BulkString("mode")
+	c.w.BulkString("standalone")
+	c.w.BulkString("role")
+	c.w.BulkString("master")
+	c.w.BulkString("modules")
+	c.w.Array(0)
+}
+
+// SET <key> <value> [NX | XX] [EX <seconds> | PX <milliseconds>]
+func (c *respConn) set(args [][]byte) {
+	if len(args) < 2 {
+		c.arity("SET")
+		return
+	}
+	req := structs.Request{Op: structs.OpPut, Key: args[0], Value: args[1]}
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); opt {
+		case "NX", "XX":
+			if req.Cond != "" {
+				c.w.Error("ERR syntax error")
+				return
+			}
+			req.Cond = structs.CondAbsent
+			if opt == "XX" {
+				req.Cond = structs.CondExists
+			}
+		case "EX", "PX":
+			if req.TTL != 0 || i+1 == len(args) {
+				c.w.Error("ERR syntax error")
+				return
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			scale := int64(1)
+			if opt == "EX" {
+				scale = 1000
+			}
+			// the TTL has to fit a time.Duration
+			if err != nil || n <= 0 || n > math.MaxInt64/int64(time.Millisecond)/scale {
+				c.w.Error("ERR invalid expire time in 'set' command")
+				return
+			}
+			req.TTL = n * scale
+		default:
+			c.w.Error("ERR syntax error")
+			return
+		}
+	}
+
+	_, err := c.execute(req)
+	switch {
+	case cli.ErrorCode(err) == structs.CodeCondition:
+		c.w.Null()
+	case err != nil:
+		c.error(err)
+	default:
+		c.w.SimpleString("OK")
+	}
+}
+
+// DEL <key> ... and EXISTS <key> ... reply with how many of the keys were
+// there.
+func (c *respConn) count(name string, keys [][]byte) {
+	var n int64
+	for _, key := range keys {
+		req := structs.Request{Op: structs.OpGet, Key: key}
+		if name == "DEL" {
+			req = structs.Request{Op: structs.OpDelete, Key: key, Cond: structs.CondExists}
+		}
+		_, err := c.execute(req)
+		switch code := cli.ErrorCode(err); {
+		case err == nil:
+			n++
+		case code == structs.CodeNotFound || code == structs.CodeCondition:
+		default:
+			c.error(err)
+			return
+		}
+	}
+	c.w.Integer(n)
+}
+
+// SCAN <cursor> [MATCH <pattern>] [COUNT <count>]
+//
+// Cursors are handed out per connection and stand for the key the next page
+// starts at, so keys are returned in order and exactly once if the bucket
+// does not change in between.
+func (c *respConn) scan(args [][]byte) {
+	if len(args) == 0 {
+		c.arity("SCAN")
+		return
+	}
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		c.w.Error("ERR invalid cursor")
+		return
+	}
+	var start []byte
+	if cursor != 0 {
+		var ok bool
+		if start, ok = c.cursors[cursor]; !ok {
+			c.w.Error("ERR invalid cursor")
+			return
+		}
+	}
+
+	count := 10
+	var match []byte
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			c.w.Error("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			match = args[i+1]
+		case "COUNT":
+			if count, err = strconv.Atoi(string(args[i+1])); err != nil || count < 1 {
+				c.w.Error("ERR syntax error")
+				return
+			}
+		default:
+			c.w.Error("ERR syntax error")
+			return
+		}
+	}
+
+	res, err := c.execute(structs.Request{Op: structs.OpScan, Key: start, Limit: count})
+	if err != nil {
+		c.error(err)
+		return
+	}
+	delete(c.cursors, cursor)
+	var next uint64
+	if len(res.Next) > 0 {
+		c.lastCursor++
+		next = c.lastCursor
+		c.cursors[next] = res.Next
+		delete(c.cursors, next-maxScanCursors)
+	}
+
+	keys := make([][]byte, 0, len(res.Pairs))
+	for _, p := range res.Pairs {
+		if match == nil || resp.Match(match, p.Key) {
+			keys = append(keys, p.Key)
+		}
+	}
+	c.w.Array(2)
+	c.w.BulkString(strconv.FormatUint(next, 10))
+	c.w.Array(len(keys))
+	for _, key := range keys {
+		c.w.Bulk(key)
+	}
+}
+
+// INFO: the server and a line per bucket, without loading any.
+func (c *respConn) info() {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Server\r\nredis_version:%s\r\nserver:bytedata\r\n", respVersion)
+	if addr := c.s.RESPListener.Addr(); addr != nil {
+		if tcp, ok := addr.(*net.TCPAddr); ok {
+			fmt.Fprintf(&b, "tcp_port:%d\r\n", tcp.Port)
+		}
+	}
+
+	list, _ := cli.Execute(c.ctx.Session, structs.Request{Op: structs.OpList})
+	b.WriteString("\r\n# Keyspace\r\n")
+	for _, info := range list.Buckets {
+		bkt, err := c.ctx.Session.GetBucket(info.Name)
+		if err != nil {
+			continue
+		}
+		stats := bkt.KvEngine.Stats()
+		fmt.Fprintf(&b, "%s:loaded=%d,keys=%d,wal_bytes=%d,last_lsn=%d\r\n",
+			info.Name, boolInt(stats.Loaded), stats.Keys, stats.WALBytes, stats.LastLSN)
+	}
+	c.w.BulkString(b.String())
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
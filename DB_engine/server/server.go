@@ -0,0 +1,804 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"byted/DB_engine/cmd/cli"
+	"byted/DB_engine/codec"
+	"byted/DB_engine/config"
+	"byted/DB_engine/core/audit"
+	"byted/DB_engine/core/auth"
+	"byted/DB_engine/core/bucket"
+	"byted/DB_engine/core/lock"
+	"byted/DB_engine/gateway/websocket"
+	"byted/DB_engine/structs"
+)
+
+// ErrShutdownTimeout is returned by Serve when in-flight commands were still
+// running at the shutdown deadline.
+var ErrShutdownTimeout = errors.New("shutdown deadline exceeded, in-flight commands were interrupted")
+
+// ErrRecoveryInterrupted is returned by Serve when startup recovery was still
+// running at the shutdown deadline; the buckets are left to WAL recovery on
+// the next start.
+var ErrRecoveryInterrupted = errors.New("shutdown deadline exceeded during startup recovery")
+
+type Server struct {
+	ListenAddr    string
+	Config        *config.Config
+	BucketManager *bucket.BucketManager // shared by every connection
+	dirLock       *lock.DirLock         // exclusive hold on the data directory
+
+	Listener     net.Listener
+	RESPListener net.Listener // Redis protocol listener, nil unless resp_addr is set
+	HTTPListener net.Listener // HTTP API listener, nil unless http_addr is set
+	UnixListener net.Listener // nil unless unix_socket is set
+	peerUsers    map[uint32]string
+	Memcached    []MemcachedListener
+	httpServer   *http.Server
+	slots        chan struct{} // one token per open connection when max_connections is set
+
+	ready             chan struct{} // closed once startup recovery is done
+	recovered, toLoad atomic.Int32  // recovery progress, in buckets
+
+	mutex    sync.Mutex
+	draining bool // set once shutdown starts, no new commands run after that
+	sessions map[net.Conn]*ClientContext
+	perIP    map[string]int // open connections by client IP, when max_connections_per_ip is set
+	watches  *watchHub
+	inflight sync.WaitGroup // commands currently executing
+	conns    sync.WaitGroup // connection goroutines
+}
+
+type ClientContext struct {
+	Session    *bucket.Session
+	User       string
+	RemoteAddr string
+
+	conn    net.Conn
+	in      *messageReader      // under dec, caps each message at max_message_size
+	enc     codec.Encoder       // JSON, or binary frames after the handshake
+	dec     codec.Decoder       // only used by readLoop
+	writeMu sync.Mutex          // replies and the shutdown notice may race
+	current uint64              // ID of the message run in order, for its progress messages
+	watches map[string]*watcher // by bucket, only used by readLoop
+}
+
+func NewServer(cfg *config.Config) *Server {
+	s := &Server{
+		ListenAddr: cfg.ListenAddr,
+		Config:     cfg,
+		sessions:   make(map[net.Conn]*ClientContext),
+		perIP:      make(map[string]int),
+		watches:    newWatchHub(),
+		ready:      make(chan struct{}),
+	}
+	if cfg.MaxConnections > 0 {
+		s.slots = make(chan struct{}, cfg.MaxConnections)
+	}
+	return s
+}
+
+// Listen locks the data directory, opens the buckets and binds the listener;
+// Serve does the rest.
+func (s *Server) Listen() error {
+	dirLock, err := lock.Acquire(s.Config.DataDir)
+	if err != nil {
+		return err
+	}
+	if dirLock.StalePID != 0 {
+		fmt.Printf("Found a stale lock left by process %d, taking over %s\n", dirLock.StalePID, s.Config.DataDir)
+	}
+
+	bm, err := bucket.NewBucketManager(s.Config.BucketsDir(), bucket.Options{
+		Order:        s.Config.BTreeOrder,
+		Durability:   s.Config.Durability,
+		MaxValueSize: s.Config.MaxValueSize,
+	})
+	if err != nil {
+		dirLock.Release()
+		return fmt.Errorf("failed to open buckets: %v", err)
+	}
+	bm.TrashRetention = s.Config.TrashRetention.Duration
+	if purged, err := bm.PurgeExpiredTrash(); err != nil {
+		fmt.Println("failed to purge the trash:", err)
+	} else if len(purged) > 0 {
+		fmt.Printf("Purged %d dropped bucket(s) past the trash retention\n", len(purged))
+	}
+
+	ln, err := net.Listen("tcp", s.ListenAddr)
+	if err != nil {
+		bm.Close()
+		dirLock.Release()
+		return fmt.Errorf("failed to start server: %v", err)
+	}
+	s.Listener = ln
+	if err := s.listenGateways(bm); err != nil {
+		s.closeListeners()
+		bm.Close()
+		dirLock.Release()
+		return err
+	}
+	if idle := s.Config.BucketIdle.Duration; idle > 0 {
+		bm.StartIdleUnloader(idle)
+	}
+	s.dirLock = dirLock
+	s.BucketManager = bm
+	fmt.Printf("Server listening on %s\n", ln.Addr())
+
+	// clients are told to come back later until this is done
+	go s.recover()
+	return nil
+}
+
+// listenGateways opens the listeners of the other protocols that are
+// configured.
+func (s *Server) listenGateways(bm *bucket.BucketManager) error {
+	var err error
+	if s.Config.RESPAddr != "" {
+		if s.RESPListener, err = net.Listen("tcp", s.Config.RESPAddr); err != nil {
+			return fmt.Errorf("failed to start the Redis protocol listener: %v", err)
+		}
+		fmt.Printf("Redis protocol listening on %s\n", s.RESPListener.Addr())
+	}
+	if s.Config.HTTPAddr != "" {
+		if s.HTTPListener, err = net.Listen("tcp", s.Config.HTTPAddr); err != nil {
+			return fmt.Errorf("failed to start the HTTP listener: %v", err)
+		}
+		s.httpServer = &http.Server{
+			Handler:           s.httpHandler(),
+			ReadHeaderTimeout: s.Config.AuthTimeout.Duration,
+			IdleTimeout:       s.Config.IdleTimeout.Duration,
+		}
+		fmt.Printf("HTTP API listening on %s\n", s.HTTPListener.Addr())
+	}
+
+	if s.Config.UnixSocket != "" {
+		if s.UnixListener, err = listenUnix(s.Config.UnixSocket, s.Config); err != nil {
+			return fmt.Errorf("failed to start the Unix socket listener: %v", err)
+		}
+		if s.peerUsers, err = s.Config.PeerUsers(); err != nil {
+			return err
+		}
+		if len(s.peerUsers) > 0 && !peerCredentials {
+			fmt.Println("unix_peer_users is ignored, peer credentials are not supported on this platform")
+		}
+		fmt.Printf("Listening on Unix socket %s\n", s.Config.UnixSocket)
+	}
+
+	listeners, err := s.Config.MemcachedListeners()
+	if err != nil {
+		return err
+	}
+	for _, l := range listeners {
+		if _, err := bm.GetBucket(l.Bucket); err != nil {
+			return fmt.Errorf("memcached listener on %s: %v", l.Addr, err)
+		}
+		ln, err := net.Listen("tcp", l.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to start the memcached listener on %s: %v", l.Addr, err)
+		}
+		s.Memcached = append(s.Memcached, MemcachedListener{Listener: ln, Bucket: l.Bucket})
+		fmt.Printf("Memcached protocol for bucket %s listening on %s\n", l.Bucket, ln.Addr())
+	}
+	return nil
+}
+
+// listenUnix listens on the socket at path, replacing one left behind by a
+// server that did not shut down cleanly.
+func listenUnix(path string, cfg *config.Config) (net.Listener, error) {
+	mode, err := cfg.SocketMode()
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// authenticate logs the client in: a Unix socket peer whose uid is in
+// unix_peer_users as that user, everyone else with username and password.
+func (s *Server) authenticate(conn net.Conn, comm *structs.Communicators) (string, bool) {
+	if unixConn, ok := conn.(*net.UnixConn); ok && len(s.peerUsers) > 0 {
+		uid, err := peerUID(unixConn)
+		if err != nil {
+			fmt.Println("failed to read the peer credentials:", err)
+		} else if user, ok := s.peerUsers[uid]; ok {
+			return auth.HandlePeerConnection(comm, user)
+		}
+	}
+	return auth.HandleAuthenticatedConnection(comm)
+}
+
+// closeListeners stops accepting on every listener that is open.
+func (s *Server) closeListeners() {
+	if s.Listener != nil {
+		s.Listener.Close()
+	}
+	if s.RESPListener != nil {
+		s.RESPListener.Close()
+	}
+	if s.HTTPListener != nil {
+		s.HTTPListener.Close()
+	}
+	if s.UnixListener != nil {
+		s.UnixListener.Close()
+	}
+	for _, l := range s.Memcached {
+		l.Close()
+	}
+}
+
+// recover replays the buckets that are loaded at startup and then marks the
+// server ready.
+func (s *Server) recover() {
+	defer close(s.ready)
+
+	summary := s.BucketManager.RecoverBuckets(s.Config.RecoveryWorkers, s.Config.PreloadBuckets, func(done, total int) {
+		s.recovered.Store(int32(done))
+		s.toLoad.Store(int32(total))
+	})
+	fmt.Printf("Recovery complete: %d bucket(s), %d records, %d bytes in %s\n",
+		summary.Buckets, summary.Records, summary.Bytes, summary.Elapsed.Round(time.Millisecond))
+	if len(summary.Failed) > 0 {
+		fmt.Printf("Buckets that failed to recover: %s\n", strings.Join(summary.Failed, ", "))
+	}
+}
+
+// Ready is closed once startup recovery has finished.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+func (s *Server) isReady() bool {
+	select {
+	case <-s.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Addr is the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.Listener.Addr()
+}
+
+// Serve accepts connections until ctx is cancelled and then shuts down.
+func (s *Server) Serve(ctx context.Context) error {
+	go s.acceptLoop(s.Listener, s.handleConnection, refuseJSON)
+	if s.RESPListener != nil {
+		go s.acceptLoop(s.RESPListener, s.handleRESP, refuseRESP)
+	}
+	if s.HTTPListener != nil {
+		go s.httpServer.Serve(&limitListener{Listener: s.HTTPListener, s: s})
+	}
+	if s.UnixListener != nil {
+		go s.acceptLoop(s.UnixListener, s.handleConnection, refuseJSON)
+	}
+	for _, l := range s.Memcached {
+		go s.acceptLoop(l.Listener, s.handleMemcached(l.Bucket), refuseMemcached)
+	}
+
+	<-ctx.Done()
+	return s.Shutdown()
+}
+
+// Run is Listen followed by Serve.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve(ctx)
+}
+
+// Shutdown stops accepting, lets in-flight commands finish within the
+// configured deadline, tells clients the server is going away, and flushes
+// and closes every bucket.
+func (s *Server) Shutdown() error {
+	fmt.Println("Shutting down...")
+	s.closeListeners()
+
+	// shutdown_timeout 0 waits for as long as it takes
+	var deadline <-chan time.Time
+	if timeout := s.Config.ShutdownTimeout.Duration; timeout > 0 {
+		deadline = time.After(timeout)
+	}
+	select {
+	case <-s.ready:
+	case <-deadline:
+		// buckets must not be closed under a recovery worker; the process
+		// exits like after a crash and the next start takes over the lock
+		if s.httpServer != nil {
+			s.httpServer.Close()
+		}
+		return ErrRecoveryInterrupted
+	}
+
+	s.mutex.Lock()
+	s.draining = true
+	s.mutex.Unlock()
+
+	var shutdownErr error
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-deadline:
+		shutdownErr = ErrShutdownTimeout
+	}
+
+	s.mutex.Lock()
+	for conn, ctx := range s.sessions {
+		if ctx != nil {
+			ctx.send(structs.Message{Type: "shutdown", Message: "server is shutting down"})
+		}
+		conn.Close()
+	}
+	s.mutex.Unlock()
+	if s.httpServer != nil {
+		s.httpServer.Close()
+	}
+	s.conns.Wait()
+
+	if err := s.BucketManager.Close(); err != nil {
+		fmt.Println("failed to close buckets:", err)
+		if shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+	if logger := audit.Default(); logger != nil {
+		logger.Close()
+	}
+	// only now may another process take over the data directory
+	if err := s.dirLock.Release(); err != nil && shutdownErr == nil {
+		shutdownErr = fmt.Errorf("failed to release data directory lock: %v", err)
+	}
+	fmt.Println("Shutdown complete.")
+	return shutdownErr
+}
+
+func communicators(conn net.Conn, in io.Reader) *structs.Communicators {
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(in)
+
+	return &structs.Communicators{Enc: enc, Dec: dec}
+}
+
+// refuseJSON turns a client away with msg.
+func refuseJSON(conn net.Conn, msg structs.Message) {
+	json.NewEncoder(conn).Encode(msg)
+}
+
+// acceptLoop accepts connections on ln and serves each with serve; refuse
+// answers the ones turned away.
+func (s *Server) acceptLoop(ln net.Listener, serve func(net.Conn), refuse func(net.Conn, structs.Message)) {
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Printf("failed to accept connection: %v\n", err)
+			continue
+		}
+
+		go s.serveConn(conn, serve, refuse)
+	}
+}
+
+// serveConn serves conn with serve unless a connection limit is hit or the
+// server is shutting down.
+func (s *Server) serveConn(conn net.Conn, serve func(net.Conn), refuse func(net.Conn, structs.Message)) {
+	if reason, ok := s.admit(conn); !ok {
+		refuse(conn, structs.Message{Type: "error", Code: structs.CodeLimit, Message: reason})
+		conn.Close()
+		return
+	}
+	defer s.release(conn)
+	s.serveAdmitted(conn, serve, refuse)
+}
+
+// serveAdmitted serves a connection that already counts against the limits.
+func (s *Server) serveAdmitted(conn net.Conn, serve func(net.Conn), refuse func(net.Conn, structs.Message)) {
+	if !s.track(conn) {
+		refuse(conn, structs.Message{Type: "shutdown", Message: "server is shutting down"})
+		conn.Close()
+		return
+	}
+	defer s.conns.Done()
+	defer s.untrack(conn)
+	serve(conn)
+}
+
+// track registers a new connection, unless shutdown has already started.
+func (s *Server) track(conn net.Conn) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.draining {
+		return false
+	}
+	s.sessions[conn] = nil
+	s.conns.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mutex.Lock()
+	delete(s.sessions, conn)
+	s.mutex.Unlock()
+	conn.Close()
+}
+
+func (s *Server) acquireSlot() bool {
+	if s.slots == nil {
+		return true
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) releaseSlot() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// handleConnection authenticates the client and then serves its commands.
+// A failed authentication always ends the session.
+func (s *Server) handleConnection(conn net.Conn) {
+	in := &messageReader{r: conn}
+	in.set(s.authLimit())
+	comm := communicators(conn, in)
+
+	if !s.isReady() {
+		comm.Enc.Encode(structs.Message{
+			Type:    "loading",
+			Message: fmt.Sprintf("server is still recovering buckets (%d of %d done), try again shortly", s.recovered.Load(), s.toLoad.Load()),
+		})
+		return
+	}
+
+	var deadline time.Time
+	if s.Config.AuthTimeout.Duration > 0 {
+		deadline = time.Now().Add(s.Config.AuthTimeout.Duration)
+		conn.SetDeadline(deadline)
+	}
+	user, ok := s.authenticate(conn, comm)
+	if !ok {
+		send := func(msg structs.Message) error { return comm.Enc.Encode(msg) }
+		switch {
+		case in.exceeded:
+			farewell(conn, send, tooLargeMessage(in.limit))
+		case !deadline.IsZero() && !time.Now().Before(deadline):
+			farewell(conn, send, structs.Message{
+				Type:    "error",
+				Code:    structs.CodeLimit,
+				Message: fmt.Sprintf("authentication timed out after %s", s.Config.AuthTimeout),
+			})
+		}
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	ctx := &ClientContext{
+		Session:    s.BucketManager.NewSession(),
+		User:       user,
+		RemoteAddr: conn.RemoteAddr().String(),
+		conn:       conn,
+		in:         in,
+		enc:        codec.NewJSONEncoder(comm.Enc),
+		dec:        codec.NewJSONDecoder(comm.Dec),
+	}
+	ctx.Session.Notify = func(message string) {
+		// only messages run in order report progress
+		ctx.reply(ctx.current, structs.Message{Type: "progress", Message: message})
+	}
+
+	s.mutex.Lock()
+	if s.draining {
+		s.mutex.Unlock()
+		return
+	}
+	s.sessions[conn] = ctx
+	s.mutex.Unlock()
+
+	s.readLoop(ctx)
+}
+
+// beginCommand marks a command as in flight; false once shutdown has started.
+func (s *Server) beginCommand() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.draining {
+		return false
+	}
+	s.inflight.Add(1)
+	return true
+}
+
+func (ctx *ClientContext) send(msg structs.Message) error {
+	ctx.writeMu.Lock()
+	defer ctx.writeMu.Unlock()
+	return ctx.enc.Encode(msg)
+}
+
+// reply sends msg as the answer to the client's message id.
+func (ctx *ClientContext) reply(id uint64, msg structs.Message) error {
+	msg.ID = id
+	return ctx.send(msg)
+}
+
+// handshake answers the client's hello with the capabilities it accepted and
+// switches to the binary framing when that is one of them.
+func (ctx *ClientContext) handshake(hello structs.Message) {
+	accepted := codec.Accept(hello.Capabilities)
+	if _, ok := ctx.conn.(*websocket.Conn); ok {
+		// WebSocket messages are text, JSON only
+		accepted = nil
+	}
+
+	ctx.writeMu.Lock()
+	defer ctx.writeMu.Unlock()
+	ctx.enc.Encode(structs.Message{Type: "hello", ID: hello.ID, Version: codec.Version, Capabilities: accepted})
+	if _, binary := ctx.dec.(*codec.BinaryDecoder); !binary && codec.Has(accepted, codec.CapBinary) {
+		ctx.enc = codec.NewBinaryEncoder(ctx.conn)
+		ctx.dec = codec.NewBinaryDecoder(codec.Continue(ctx.dec, ctx.in))
+	}
+}
+
+// readLoop serves the client's messages. Messages tagged with an ID that only
+// touch one bucket are pipelined; everything else waits for the messages before
+// it and runs in order.
+func (s *Server) readLoop(ctx *ClientContext) {
+	conn := ctx.conn
+	p := newPipeline(s, ctx)
+	defer p.close()
+	defer s.unwatchAll(ctx)
+	for {
+		var msg structs.Message
+
+		if s.Config.IdleTimeout.Duration > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.Config.IdleTimeout.Duration))
+		}
+		ctx.in.set(int64(s.Config.MaxMessageSize))
+		// Read message from client
+		if err := ctx.dec.Decode(&msg); err != nil {
+			switch {
+			case ctx.in.exceeded:
+				farewell(conn, ctx.send, tooLargeMessage(ctx.in.limit))
+			case isTimeout(err) && s.Config.IdleTimeout.Duration > 0:
+				farewell(conn, ctx.send, structs.Message{
+					Type:    "error",
+					Code:    structs.CodeLimit,
+					Message: fmt.Sprintf("connection idle for more than %s, closing it", s.Config.IdleTimeout),
+				})
+			}
+			fmt.Println("Client disconnected:", err)
+			return
+		}
+		if err := msg.DecodeBinary(); err != nil {
+			ctx.reply(msg.ID, structs.Message{Type: "error", Message: err.Error()})
+			continue
+		}
+
+		if msg.Type == "hello" {
+			p.wait()
+			ctx.handshake(msg)
+			continue
+		}
+
+		if !s.beginCommand() {
+			// Shutdown will notify the client and close the connection
+			return
+		}
+		if key, ok := pipelineKey(ctx, msg); ok {
+			p.submit(key, msg)
+			continue
+		}
+		p.wait()
+		ctx.current = msg.ID
+		s.execute(ctx, msg)
+		s.inflight.Done()
+	}
+}
+
+func (s *Server) execute(ctx *ClientContext, msg structs.Message) {
+	active, _ := ctx.Session.GetActiveBucket()
+
+	switch msg.Type {
+	case "command":
+		// Execute the command
+		var data []string
+		var lsn uint64
+		var err error
+
+		if active == nil {
+			data, err = cli.ExecuteGlobalCommmand(msg.Command, ctx.Session, ctx.conn)
+		} else {
+			data, lsn, err = cli.ExecuteCommand(msg.Command, active, ctx.Session)
+		}
+		auditCommand(ctx, active, msg.Command, lsn, err)
+
+		active, _ = ctx.Session.GetActiveBucket()
+		var currentBkt string
+		if active == nil {
+			currentBkt = ""
+		} else {
+			currentBkt = active.Name
+		}
+		if err != nil {
+			// Send error back to client
+			ctx.reply(msg.ID, structs.Message{
+				Type:    "error",
+				Message: err.Error(),
+				Bucket:  currentBkt,
+			})
+		} else {
+			ctx.reply(msg.ID, structs.Message{
+				Type:   "success",
+				Data:   data,
+				Bucket: currentBkt,
+			})
+		}
+
+	case "request":
+		// structured operation, answered with a typed result or an error code
+		if msg.Request == nil {
+			ctx.reply(msg.ID, structs.Message{Type: "error", Code: structs.CodeInvalid, Message: "request message without a request"})
+			return
+		}
+		req := *msg.Request
+		res, err := cli.Execute(ctx.Session, req)
+		auditRequest(ctx, active, req, res, err)
+
+		currentBkt := ""
+		if after, _ := ctx.Session.GetActiveBucket(); after != nil {
+			currentBkt = after.Name
+		}
+		if err != nil {
+			ctx.reply(msg.ID, structs.Message{Type: "error", Code: cli.ErrorCode(err), Message: err.Error(), Bucket: currentBkt})
+			return
+		}
+		ctx.reply(msg.ID, structs.Message{Type: "result", Result: res, Bucket: currentBkt})
+
+	case "export":
+		// client-side export: the keys are streamed back as "rows" messages
+		var start, end []byte
+		if len(msg.Data) == 2 {
+			start, end = []byte(msg.Data[0]), []byte(msg.Data[1])
+		}
+		count, lsn, err := cli.ExportRows(ctx.Session, msg.Bucket, start, end, func(rows []string) error {
+			return ctx.reply(msg.ID, structs.Message{Type: "rows", Data: rows})
+		})
+		auditCommand(ctx, nil, "export "+msg.Bucket, 0, err)
+		if err != nil {
+			ctx.reply(msg.ID, structs.Message{Type: "error", Message: "export failed: " + err.Error()})
+			return
+		}
+		ctx.reply(msg.ID, structs.Message{Type: "success", Message: fmt.Sprintf("%d key(s) as of LSN %d", count, lsn)})
+
+	case "import":
+		// client-side import: every message is one atomic batch
+		lsn, err := cli.ImportRows(ctx.Session, msg.Bucket, msg.Data)
+		auditCommand(ctx, nil, "import "+msg.Bucket, lsn, err)
+		if err != nil {
+			ctx.reply(msg.ID, structs.Message{Type: "error", Message: "import failed: " + err.Error()})
+			return
+		}
+		ctx.reply(msg.ID, structs.Message{Type: "success", Message: fmt.Sprintf("LSN %d", lsn)})
+
+	case "watch":
+		s.watch(ctx, msg)
+
+	case "unwatch":
+		s.unwatch(ctx, msg)
+
+	default:
+		// Unknown message type
+		ctx.reply(msg.ID, structs.Message{
+			Type:    "error",
+			Message: "Unknown message type: " + msg.Type,
+		})
+	}
+}
+
+// auditRequest records a structured request like auditCommand does a command.
+func auditRequest(ctx *ClientContext, active *bucket.Bucket, req structs.Request, res *structs.Result, reqErr error) {
+	rec := audit.Record{
+		User:    ctx.User,
+		Remote:  ctx.RemoteAddr,
+		Command: req.Op,
+		Bucket:  req.Bucket,
+		Outcome: "ok",
+	}
+	switch req.Op {
+	case structs.OpPut, structs.OpGet, structs.OpDelete, structs.OpRange, structs.OpScan,
+		structs.OpIncr, structs.OpTouch:
+		if rec.Bucket == "" && active != nil {
+			rec.Bucket = active.Name
+		}
+	}
+	if reqErr != nil {
+		rec.Outcome = reqErr.Error()
+	} else if res.LSN != 0 {
+		rec.LSN = res.LSN
+	}
+
+	if err := audit.Log(rec); err != nil {
+		fmt.Println("failed to write audit record:", err)
+	}
+}
+
+// auditCommand records who ran a command, where, and what came of it; lsn is
+// that of the write the command made, if any.
+func auditCommand(ctx *ClientContext, active *bucket.Bucket, command string, lsn uint64, cmdErr error) {
+	parts := strings.Fields(command)
+	if len(parts) == 0 {
+		return
+	}
+
+	rec := audit.Record{
+		User:    ctx.User,
+		Remote:  ctx.RemoteAddr,
+		Command: parts[0],
+		Outcome: "ok",
+		LSN:     lsn,
+	}
+	if active != nil {
+		rec.Bucket = active.Name
+	} else if len(parts) > 1 && (rec.Command == "use" || rec.Command == "create" || rec.Command == "drop" ||
+		rec.Command == "freeze" || rec.Command == "unfreeze" || rec.Command == "rename" ||
+		rec.Command == "clone" || rec.Command == "copy" || rec.Command == "undrop" ||
+		rec.Command == "pin" || rec.Command == "unpin" || rec.Command == "export" ||
+		rec.Command == "import") {
+		rec.Bucket = parts[1]
+	}
+	if cmdErr != nil {
+		rec.Outcome = cmdErr.Error()
+	}
+
+	if err := audit.Log(rec); err != nil {
+		fmt.Println("failed to write audit record:", err)
+	}
+}
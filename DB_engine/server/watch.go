@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"byted/DB_engine/cmd/cli"
+	"byted/DB_engine/core/kv"
+	"byted/DB_engine/structs"
+)
+
+// watchBacklog bounds the events queued for one watch; a client that falls
+// further behind loses the watch rather than holding up writes.
+const watchBacklog = 1024
+
+// watchHub fans the changes of watched buckets out to their watchers. A
+// bucket's engine has a change hook only while someone watches it.
+type watchHub struct {
+	mutex sync.Mutex
+	feeds map[*kv.KVEngine]map[*watcher]struct{}
+}
+
+// watcher is one watch of a connection, sending its events in LSN order.
+type watcher struct {
+	ctx    *ClientContext
+	id     uint64 // of the watch message, echoed in every event
+	bucket string
+	engine *kv.KVEngine
+	prefix []byte
+	events chan structs.Event
+	lost   bool // events were dropped, set before events is closed
+	done   chan struct{}
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{feeds: make(map[*kv.KVEngine]map[*watcher]struct{})}
+}
+
+// add registers a watch on engine; its events queue up until run is started.
+func (h *watchHub) add(ctx *ClientContext, id uint64, bucket string, engine *kv.KVEngine, prefix []byte) *watcher {
+	w := &watcher{
+		ctx:    ctx,
+		id:     id,
+		bucket: bucket,
+		engine: engine,
+		prefix: prefix,
+		events: make(chan structs.Event, watchBacklog),
+		done:   make(chan struct{}),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	feed := h.feeds[engine]
+	if feed == nil {
+		feed = make(map[*watcher]struct{})
+		h.feeds[engine] = feed
+		engine.SetChangeHook(func(c kv.Change) { h.publish(engine, c) })
+	}
+	feed[w] = struct{}{}
+	return w
+}
+
+// remove ends a watch. With wait it returns once the events queued before
+// have been sent.
+func (h *watchHub) remove(w *watcher, wait bool) {
+	h.mutex.Lock()
+	if _, ok := h.feeds[w.engine][w]; ok {
+		h.removeLocked(w)
+	}
+	h.mutex.Unlock()
+	if wait {
+		<-w.done
+	}
+}
+
+func (h *watchHub) removeLocked(w *watcher) {
+	feed := h.feeds[w.engine]
+	delete(feed, w)
+	close(w.events)
+	if len(feed) == 0 {
+		delete(h.feeds, w.engine)
+		w.engine.SetChangeHook(nil)
+	}
+}
+
+// publish is the change hook of a watched engine. It runs under the engine's
+// write lock, so it never waits for a client.
+func (h *watchHub) publish(engine *kv.KVEngine, c kv.Change) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for w := range h.feeds[engine] {
+		if !bytes.HasPrefix(c.Key, w.prefix) {
+			continue
+		}
+		ev := structs.Event{Op: structs.OpPut, Key: append([]byte{}, c.Key...), Value: c.Value, LSN: c.LSN}
+		if c.Delete {
+			ev.Op = structs.OpDelete
+		}
+		select {
+		case w.events <- ev:
+		default:
+			w.lost = true
+			h.removeLocked(w)
+		}
+	}
+}
+
+// run sends the watch's events until it is removed.
+func (w *watcher) run() {
+	defer close(w.done)
+	for ev := range w.events {
+		w.ctx.reply(w.id, structs.Message{Type: "event", Bucket: w.bucket, Event: &ev})
+	}
+	if w.lost {
+		w.ctx.reply(w.id, structs.Message{Type: "event", Bucket: w.bucket, Event: &structs.Event{Op: structs.EventLost}})
+	}
+}
+
+// watch starts a watch on msg.Bucket, or the active bucket, for keys starting
+// with the prefix in msg.Data.
+func (s *Server) watch(ctx *ClientContext, msg structs.Message) {
+	name := msg.Bucket
+	if name == "" {
+		if active, _ := ctx.Session.GetActiveBucket(); active != nil {
+			name = active.Name
+		}
+	}
+	if name == "" {
+		ctx.reply(msg.ID, structs.Message{Type: "error", Code: structs.CodeNoBucket, Message: "no bucket selected"})
+		return
+	}
+	b, err := ctx.Session.GetBucket(name)
+	auditCommand(ctx, nil, "watch "+name, 0, err)
+	if err != nil {
+		ctx.reply(msg.ID, structs.Message{Type: "error", Code: cli.ErrorCode(err), Message: err.Error()})
+		return
+	}
+	if _, ok := ctx.watches[name]; ok {
+		ctx.reply(msg.ID, structs.Message{Type: "error", Code: structs.CodeInvalid, Message: fmt.Sprintf("already watching bucket '%s'", name)})
+		return
+	}
+
+	var prefix []byte
+	if len(msg.Data) > 0 {
+		prefix = []byte(msg.Data[0])
+	}
+	w := s.watches.add(ctx, msg.ID, name, b.KvEngine, prefix)
+	if ctx.watches == nil {
+		ctx.watches = make(map[string]*watcher)
+	}
+	ctx.watches[name] = w
+
+	// events only go out after this reply
+	ctx.reply(msg.ID, structs.Message{Type: "success", Message: fmt.Sprintf("watching bucket '%s'", name)})
+	go w.run()
+}
+
+// unwatch ends the connection's watch on msg.Bucket, or the active bucket.
+func (s *Server) unwatch(ctx *ClientContext, msg structs.Message) {
+	name := msg.Bucket
+	if name == "" {
+		if active, _ := ctx.Session.GetActiveBucket(); active != nil {
+			name = active.Name
+		}
+	}
+	w, ok := ctx.watches[name]
+	if !ok {
+		ctx.reply(msg.ID, structs.Message{Type: "error", Code: structs.CodeInvalid, Message: fmt.Sprintf("not watching bucket '%s'", name)})
+		return
+	}
+	delete(ctx.watches, name)
+	s.watches.remove(w, true)
+	auditCommand(ctx, nil, "unwatch "+name, 0, nil)
+	ctx.reply(msg.ID, structs.Message{Type: "success", Message: fmt.Sprintf("stopped watching bucket '%s'", name)})
+}
+
+// unwatchAll ends the connection's watches when it goes away.
+func (s *Server) unwatchAll(ctx *ClientContext) {
+	for name, w := range ctx.watches {
+		delete(ctx.watches, name)
+		s.watches.remove(w, false)
+	}
+}
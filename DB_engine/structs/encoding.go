@@ -0,0 +1,72 @@
+package structs
+
+import (
+	"encoding/base64"
+	"fmt"
+	"unicode/utf8"
+)
+
+// EncodingBase64 marks a message whose Command, Message and Data are base64
+// encoded. JSON strings can only carry valid UTF-8, so messages holding
+// anything else are sent this way to arrive byte-exact.
+const EncodingBase64 = "base64"
+
+// EncodeBinary base64 encodes the message if it holds text JSON cannot carry.
+func (m *Message) EncodeBinary() {
+	if m.Encoding != "" || m.isText() {
+		return
+	}
+	m.Command = base64.StdEncoding.EncodeToString([]byte(m.Command))
+	m.Message = base64.StdEncoding.EncodeToString([]byte(m.Message))
+	data := make([]string, len(m.Data))
+	for i, d := range m.Data {
+		data[i] = base64.StdEncoding.EncodeToString([]byte(d))
+	}
+	m.Data = data
+	m.Encoding = EncodingBase64
+}
+
+// DecodeBinary undoes EncodeBinary.
+func (m *Message) DecodeBinary() error {
+	switch m.Encoding {
+	case "":
+		return nil
+	case EncodingBase64:
+	default:
+		return fmt.Errorf("unknown message encoding '%s'", m.Encoding)
+	}
+
+	decode := func(s string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid base64 in message: %v", err)
+		}
+		return string(b), nil
+	}
+	var err error
+	if m.Command, err = decode(m.Command); err != nil {
+		return err
+	}
+	if m.Message, err = decode(m.Message); err != nil {
+		return err
+	}
+	for i := range m.Data {
+		if m.Data[i], err = decode(m.Data[i]); err != nil {
+			return err
+		}
+	}
+	m.Encoding = ""
+	return nil
+}
+
+func (m *Message) isText() bool {
+	if !utf8.ValidString(m.Command) || !utf8.ValidString(m.Message) {
+		return false
+	}
+	for _, d := range m.Data {
+		if !utf8.ValidString(d) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package structs
+
+// A structured request is a message of type "request" carrying a Request. It
+// is answered by a message of type "result" carrying a Result, or of type
+// "error" with Code set. Keys and values are []byte, base64 in the JSON.
+
+// operations
+const (
+	OpPut    = "put"    // Key, Value, optional TTL, Flags, Cond and IfLSN -> LSN
+	OpGet    = "get"    // Key -> Value, Flags, LSN of the key's last write
+	OpDelete = "delete" // Key, optional Cond and IfLSN -> LSN
+	OpIncr   = "incr"   // Key, Delta -> Value, LSN; see kv.KVEngine.Incr
+	OpTouch  = "touch"  // Key, TTL (0 = never expire) -> LSN
+	OpRange  = "range"  // Key (start), End, both inclusive -> Pairs
+	OpScan   = "scan"   // Key (start, may be empty), Limit -> Pairs, Next
+	OpList   = "list"   // -> Buckets
+	OpUse    = "use"    // Bucket becomes the session's active bucket
+	OpExit   = "exit"   // leave the active bucket
+	OpCreate = "create" // Bucket, Options
+	OpDrop   = "drop"   // Bucket, moved to the trash
+)
+
+// administration
+const (
+	OpRename      = "rename"       // Bucket, Target (the new name)
+	OpClone       = "clone"        // Bucket (source), Target, optional AsOf -> LSN copied up to, Count
+	OpAlter       = "alter"        // Bucket, Options -> Options, all of the bucket's options afterwards
+	OpFreeze      = "freeze"       // Bucket, optional Chmod to write-protect its files as well
+	OpUnfreeze    = "unfreeze"     // Bucket
+	OpPin         = "pin"          // Bucket stays loaded
+	OpUnpin       = "unpin"        // Bucket is unloaded when idle again
+	OpUndrop      = "undrop"       // Bucket, the most recent trash entry of that name
+	OpTrash       = "trash"        // -> Trash
+	OpPurge       = "purge"        // optional Bucket -> Trash, the entries deleted
+	OpCatalog     = "catalog"      // -> Buckets re-registered from disk
+	OpBackup      = "backup"       // Path, optional Base, both in backup_dir -> Base, Buckets with LSN and Bytes
+	OpAuditTail   = "audit_tail"   // optional Limit (default 20) -> Lines, the last audit records
+	OpAuditStatus = "audit_status" // -> Log, Off
+	OpConfig      = "config"       // optional Setting -> Settings, every setting when empty
+)
+
+// conditions of a put or delete
+const (
+	CondExists = "exists" // only if the key holds a value
+	CondAbsent = "absent" // only if it does not, put only
+)
+
+// error codes
+const (
+	CodeInvalid      = "invalid_request"    // malformed request or arguments
+	CodeUnknownOp    = "unknown_op"         // Op is not one of the operations
+	CodeNotFound     = "not_found"          // no such key
+	CodeNoSuchBucket = "no_such_bucket"     // no bucket of that name
+	CodeExists       = "bucket_exists"      // create of an existing bucket
+	CodeNoBucket     = "no_bucket_selected" // data operation without Bucket and no active bucket
+	CodeReadOnly     = "read_only"          // write to a frozen bucket
+	CodeTooLarge     = "too_large"          // value over the bucket's max_value_size, or message over max_message_size
+	CodeCondition    = "condition_failed"   // Cond did not hold, nothing was written
+	CodeLimit        = "limit_exceeded"     // a connection limit or timeout was hit, the connection is closed
+	CodeUnavailable  = "bucket_unavailable" // the bucket is in the catalog but failed to open
+	CodeInternal     = "internal"           // anything else
+)
+
+// Request is one structured operation.
+type Request struct {
+	Op      string   `json:"op"`
+	Bucket  string   `json:"bucket,omitempty"` // data operations default to the active bucket
+	Key     []byte   `json:"key,omitempty"`
+	Value   []byte   `json:"value,omitempty"`
+	End     []byte   `json:"end,omitempty"`
+	TTL     int64    `json:"ttl_ms,omitempty"`  // put only, 0 = the bucket's default TTL
+	Cond    string   `json:"cond,omitempty"`    // put and delete, CondExists or CondAbsent
+	IfLSN   uint64   `json:"if_lsn,omitempty"`  // put and delete, only if the key's last write had this LSN
+	Delta   uint64   `json:"delta,omitempty"`   // incr only
+	Decr    bool     `json:"decr,omitempty"`    // incr only, subtract Delta instead
+	Flags   uint32   `json:"flags,omitempty"`   // put only, stored with the value and returned by get
+	Limit   int      `json:"limit,omitempty"`   // scan and audit_tail
+	Options []string `json:"options,omitempty"` // create and alter, key=value
+	Target  string   `json:"target,omitempty"`  // rename and clone, the bucket to create
+	AsOf    uint64   `json:"as_of,omitempty"`   // clone only, copy the bucket as it was at this LSN
+	Chmod   bool     `json:"chmod,omitempty"`   // freeze only
+	Path    string   `json:"path,omitempty"`    // backup only, the directory to write
+	Base    string   `json:"base,omitempty"`    // backup only, the backup an incremental one builds on
+	Setting string   `json:"setting,omitempty"` // config only
+}
+
+// Result is the outcome of a successful Request.
+type Result struct {
+	LSN      uint64       `json:"lsn,omitempty"`
+	Value    []byte       `json:"value,omitempty"`
+	Flags    uint32       `json:"flags,omitempty"` // get: the flags the value was put with
+	Pairs    []KVPair     `json:"pairs,omitempty"`
+	Next     []byte       `json:"next,omitempty"` // scan: the start of the next page, empty at the end
+	Buckets  []BucketInfo `json:"buckets,omitempty"`
+	Count    int          `json:"count,omitempty"`   // clone: keys copied
+	Options  []string     `json:"options,omitempty"` // alter: key=value, as create and alter take them
+	Trash    []TrashEntry `json:"trash,omitempty"`
+	Base     string       `json:"base,omitempty"`  // backup: the backup it builds on, empty for a full one
+	Lines    []string     `json:"lines,omitempty"` // audit_tail: one JSON record each
+	Log      string       `json:"log,omitempty"`   // audit_status: the audit log file
+	Off      []string     `json:"off,omitempty"`   // audit_status: what is not audited, as audit_off lists it
+	Settings []Setting    `json:"settings,omitempty"`
+}
+
+// KVPair is one key of a range result.
+type KVPair struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Event is a change to a watched bucket. A client sends a message of type
+// "watch" with Bucket (empty = the active one) and optionally a key prefix in
+// Data, and receives every later put and delete on matching keys as messages
+// of type "event" tagged with the watch message's ID, until it sends
+// "unwatch" for the bucket or disconnects.
+type Event struct {
+	Op    string `json:"op"` // OpPut, OpDelete or EventLost
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value,omitempty"` // put only
+	LSN   uint64 `json:"lsn,omitempty"`
+}
+
+// EventLost ends a watch whose client fell too far behind: events were
+// dropped, and the client has to read the bucket again and watch anew.
+const EventLost = "lost"
+
+// BucketInfo is one bucket of a list result.
+type BucketInfo struct {
+	Name     string `json:"name"`
+	Loaded   bool   `json:"loaded"`
+	Pinned   bool   `json:"pinned,omitempty"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+
+	Unavailable string `json:"unavailable,omitempty"` // why the bucket failed to open
+
+	LSN   uint64 `json:"lsn,omitempty"`   // backup: the last LSN backed up
+	Bytes int64  `json:"bytes,omitempty"` // backup: WAL bytes written
+}
+
+// TrashEntry is one dropped bucket of a trash or purge result. Times are Unix
+// milliseconds.
+type TrashEntry struct {
+	Name      string `json:"name"`
+	DroppedAt int64  `json:"dropped_at_ms"`
+	PurgeAt   int64  `json:"purge_at_ms,omitempty"` // when trash_retention deletes it, 0 = never
+}
+
+// Setting is one server setting of a config result.
+type Setting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"` // secrets are masked
+	Source string `json:"source"`
+}
@@ -12,13 +12,22 @@ type Communicators struct {
 
 type Message struct {
 	Type     string   `json:"type"`
+	ID       uint64   `json:"id,omitempty"`       // set by the client, echoed in every reply to the message
 	Bucket     string   `json:"bucket"`
 	Field    string   `json:"field,omitempty"`
 	Username string   `json:"username,omitempty"`
 	Password string   `json:"password,omitempty"`
+	Token    string   `json:"token,omitempty"`
 	Command  string   `json:"command,omitempty"`
 	Message  string   `json:"message,omitempty"`
 	Data     []string `json:"data,omitempty"`
+	Encoding string   `json:"encoding,omitempty"` // EncodingBase64 when Command, Message and Data are base64
+	Request  *Request `json:"request,omitempty"`  // type "request"
+	Result   *Result  `json:"result,omitempty"`   // type "result"
+	Code     string   `json:"code,omitempty"`     // error code of a failed request
+	Version      int      `json:"version,omitempty"`      // type "hello"
+	Capabilities []string `json:"capabilities,omitempty"` // type "hello", see the codec package
+	Event        *Event   `json:"event,omitempty"`        // type "event", Bucket names the watched bucket
 }
 
 
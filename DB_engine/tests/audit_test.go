@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"byted/DB_engine/core/audit"
+)
+
+func TestAuditRotationAndTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// tiny max size so every few records trigger a rotation
+	logger, err := audit.New(path, 512, 3)
+	if err != nil {
+		t.Fatal("Failed to open audit log:", err)
+	}
+	defer logger.Close()
+
+	for i := 1; i <= 20; i++ {
+		rec := audit.Record{User: "admin", Remote: "127.0.0.1:5000", Bucket: "users", Command: "put", Outcome: "ok", LSN: uint64(i)}
+		if err := logger.Log(rec); err != nil {
+			t.Fatal("Log failed:", err)
+		}
+	}
+
+	if _, err := os.Stat(path + ".2"); err != nil {
+		t.Fatal("expected rotated file audit.log.2:", err)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("expected only 3 audit files to be kept")
+	}
+
+	lines, err := logger.Tail(3)
+	if err != nil {
+		t.Fatal("Tail failed:", err)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d", len(lines))
+	}
+	if want := fmt.Sprintf(`"lsn":%d`, 20); !strings.Contains(lines[2], want) {
+		t.Fatalf("last record should be LSN 20, got %s", lines[2])
+	}
+
+	// switched off classes and buckets are not recorded, the switches are
+	logger.SetBucket("users", false)
+	if err := logger.SetClass(audit.ClassAdmin, false); err != nil {
+		t.Fatal(err)
+	}
+	logger.Log(audit.Record{User: "admin", Bucket: "users", Command: "put", Outcome: "ok", LSN: 21})
+	logger.Log(audit.Record{User: "admin", Bucket: "orders", Command: "freeze", Outcome: "ok"})
+	lines, _ = logger.Tail(3)
+	if !strings.Contains(lines[0], `"lsn":20`) ||
+		!strings.Contains(lines[1], `"bucket":"users","command":"audit off bucket"`) ||
+		!strings.Contains(lines[2], `"command":"audit off admin"`) {
+		t.Fatalf("unexpected records after switching auditing off:\n%s", strings.Join(lines, "\n"))
+	}
+	if off := logger.Off(); strings.Join(off, ",") != "admin,bucket:users" {
+		t.Fatalf("Off() = %q, want admin and bucket:users", off)
+	}
+}
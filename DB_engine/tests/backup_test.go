@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"byted/DB_engine/core/backup"
+	"byted/DB_engine/core/bucket"
+	"byted/DB_engine/core/wal"
+)
+
+func takeBackup(t *testing.T, bm *bucket.BucketManager, dest, base string) *backup.Manifest {
+	t.Helper()
+	catalog, sources, err := bm.BackupSources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := backup.Create(backup.Request{Dest: dest, Base: base, Buckets: sources, Catalog: catalog})
+	if err != nil {
+		t.Fatal("backup failed:", err)
+	}
+	return m
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "buckets")
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+	bm.CreateBucket("users", bm.Defaults)
+	users, _ := bm.GetBucket("users")
+	users.KvEngine.Put([]byte("alice"), []byte("1"))
+	users.KvEngine.Put([]byte("bob"), []byte("2"))
+
+	backups := t.TempDir()
+	full := filepath.Join(backups, "full")
+	m := takeBackup(t, bm, full, "")
+	if len(m.Buckets) != 1 || m.Buckets[0].LSN != 2 {
+		t.Fatalf("unexpected full backup manifest: %+v", m.Buckets)
+	}
+
+	users.KvEngine.Delete([]byte("alice"))
+	users.KvEngine.Put([]byte("carol"), []byte("3"))
+	bm.CreateBucket("orders", bm.Defaults)
+	orders, _ := bm.GetBucket("orders")
+	orders.KvEngine.Put([]byte("o1"), []byte("x"))
+
+	incr := filepath.Join(backups, "incr")
+	m = takeBackup(t, bm, incr, full)
+	for _, b := range m.Buckets {
+		switch b.Name {
+		case "users":
+			if b.Start == 0 || b.LSN != 4 {
+				t.Fatalf("users should only ship what was appended: %+v", b)
+			}
+		case "orders":
+			if b.Start != 0 {
+				t.Fatalf("a new bucket is copied in full: %+v", b)
+			}
+		}
+	}
+
+	// written after the backup, must not be restored
+	users.KvEngine.Put([]byte("dave"), []byte("4"))
+
+	if _, err := backup.Verify(incr); err != nil {
+		t.Fatal("Verify failed:", err)
+	}
+
+	dataDir := t.TempDir()
+	target := backup.Target{
+		BucketsDir: filepath.Join(dataDir, "buckets"),
+		MetaPath:   filepath.Join(dataDir, "buckets_meta.json"),
+		AuthFile:   filepath.Join(dataDir, "auth.json"),
+	}
+	if err := backup.Restore(incr, target); err != nil {
+		t.Fatal("Restore failed:", err)
+	}
+	restored, err := bucket.NewBucketManager(target.BucketsDir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	u, err := restored.GetBucket("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"alice": "", "bob": "2", "carol": "3", "dave": ""} {
+		got, _ := u.KvEngine.Get([]byte(key))
+		if string(got) != want {
+			t.Fatalf("restored %s = %q, want %q", key, got, want)
+		}
+	}
+	if _, err := restored.GetBucket("orders"); err != nil {
+		t.Fatal("bucket created between backups not restored:", err)
+	}
+
+	// a damaged base backup is caught before anything is touched
+	seg := filepath.Join(full, m.Buckets[0].File)
+	data, _ := os.ReadFile(filepath.Join(full, "wal", "users.wal"))
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(filepath.Join(full, "wal", "users.wal"), data, 0644)
+	if _, err := backup.Verify(incr); err == nil {
+		t.Fatalf("expected a checksum error (segment %s)", seg)
+	}
+	if err := backup.Restore(incr, target); err == nil {
+		t.Fatal("restore of a damaged backup succeeded")
+	}
+}
+
+func TestBackupTrimsPartialRecord(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "livewal.log")
+	w, err := wal.New(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.AppendPut([]byte("k1"), []byte("v1"))
+	w.AppendPut([]byte("k2"), []byte("v2"))
+	w.Close()
+	info, _ := os.Stat(walPath)
+
+	// a record caught half way through its write
+	f, _ := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write([]byte{30, 0, 0, 0, 3, 0, 0})
+	f.Close()
+
+	m, err := backup.Create(backup.Request{
+		Dest:    filepath.Join(t.TempDir(), "b"),
+		Buckets: []backup.Source{{Name: "live", WALPath: walPath, Size: -1}},
+		Catalog: []byte(`{"version": 1, "buckets": []}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Buckets[0]; got.End != info.Size() || got.LSN != 2 {
+		t.Fatalf("expected the copy to stop at %d bytes, LSN 2; got %d, LSN %d", info.Size(), got.End, got.LSN)
+	}
+}
@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"byted/DB_engine/core/auth"
+	"byted/DB_engine/structs"
+)
+
+// setupSession runs the server side of a login on a pipe and returns the
+// client side; the login's outcome arrives on done.
+func setupSession(t *testing.T) (*json.Encoder, *json.Decoder, chan bool) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { server.Close(); client.Close() })
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+
+	done := make(chan bool, 1)
+	go func() {
+		_, ok := auth.HandleAuthenticatedConnection(&structs.Communicators{Enc: json.NewEncoder(server), Dec: json.NewDecoder(server)})
+		done <- ok
+	}()
+	return json.NewEncoder(client), json.NewDecoder(client), done
+}
+
+func expectMessage(t *testing.T, dec *json.Decoder, msgType, field string) structs.Message {
+	t.Helper()
+	var msg structs.Message
+	if err := dec.Decode(&msg); err != nil {
+		t.Fatal("read failed:", err)
+	}
+	if msg.Type != msgType || msg.Field != field {
+		t.Fatalf("expected %s %s, got %+v", msgType, field, msg)
+	}
+	return msg
+}
+
+// presentToken logs in as admin up to the password prompt of the setup.
+func presentToken(t *testing.T, token string) (*json.Encoder, *json.Decoder, chan bool) {
+	t.Helper()
+	enc, dec, done := setupSession(t)
+	expectMessage(t, dec, "request", "username")
+	enc.Encode(structs.Message{Type: "auth", Username: "admin"})
+	expectMessage(t, dec, "request", "token")
+	enc.Encode(structs.Message{Type: "auth", Token: token})
+	return enc, dec, done
+}
+
+func TestSetupToken(t *testing.T) {
+	auth.SetAuthFile(filepath.Join(t.TempDir(), "auth.json"))
+	token, err := auth.Bootstrap("", "")
+	if err != nil || token == "" {
+		t.Fatalf("expected a setup token, got %q %v", token, err)
+	}
+	if !auth.SetupPending() {
+		t.Fatal("setup not pending before the first account")
+	}
+
+	// a wrong token ends the session
+	_, dec, done := presentToken(t, "not-the-token")
+	if msg := expectMessage(t, dec, "error", ""); !strings.Contains(msg.Message, "Invalid setup token") {
+		t.Fatalf("unexpected error: %+v", msg)
+	}
+	if <-done {
+		t.Fatal("login succeeded with a wrong token")
+	}
+
+	// two sessions present the right token, only the first to finish wins
+	first, firstDec, firstDone := presentToken(t, token)
+	expectMessage(t, firstDec, "info", "")
+	expectMessage(t, firstDec, "request", "password")
+	second, secondDec, secondDone := presentToken(t, token)
+	expectMessage(t, secondDec, "info", "")
+	expectMessage(t, secondDec, "request", "password")
+
+	first.Encode(structs.Message{Type: "auth", Password: "secret"})
+	expectMessage(t, firstDec, "success", "")
+	if !<-firstDone {
+		t.Fatal("setup with the token failed")
+	}
+	second.Encode(structs.Message{Type: "auth", Password: "other"})
+	if msg := expectMessage(t, secondDec, "error", ""); !strings.Contains(msg.Message, "already used") {
+		t.Fatalf("unexpected error: %+v", msg)
+	}
+	if <-secondDone {
+		t.Fatal("the setup token was used twice")
+	}
+
+	// the first account exists and logs in normally from now on
+	if auth.SetupPending() || auth.ValidateUser("admin", "secret") != nil {
+		t.Fatal("admin account was not created")
+	}
+	if token, _ := auth.Bootstrap("", ""); token != "" {
+		t.Fatal("a new setup token was issued after setup")
+	}
+}
@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"byted/DB_engine/core/bucket"
+	"byted/DB_engine/core/kv"
+)
+
+func TestBucketOptionsSurviveRestart(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "buckets")
+
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := bucket.ParseOptions(bm.Defaults, []string{"order=8", "compression=deflate", "max_value_size=64"})
+	if err != nil {
+		t.Fatal("ParseOptions failed:", err)
+	}
+	if err := bm.CreateBucket("docs", opts); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := bm.GetBucket("docs")
+
+	value := []byte(strings.Repeat("compressible ", 4))
+	if _, err := b.KvEngine.Put([]byte("k1"), value); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.KvEngine.Put([]byte("k2"), make([]byte, 65)); err == nil {
+		t.Fatal("expected max_value_size to reject a 65 byte value")
+	}
+	if _, err := bm.AlterBucket("docs", []string{"read_only=true"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.KvEngine.Delete([]byte("k1")); !errors.Is(err, kv.ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	bm.Close()
+
+	// everything comes back from the metadata, not from the defaults
+	bm, err = bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+	b, err = bm.GetBucket("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := opts
+	want.ReadOnly = true
+	if b.Options != want {
+		t.Fatalf("options not restored: got %+v, want %+v", b.Options, want)
+	}
+	if got, err := b.KvEngine.Get([]byte("k1")); err != nil || string(got) != string(value) {
+		t.Fatalf("compressed value did not round-trip: %q, %v", got, err)
+	}
+	if _, err := b.KvEngine.Put([]byte("k3"), []byte("v")); !errors.Is(err, kv.ErrReadOnly) {
+		t.Fatalf("read_only not restored, got %v", err)
+	}
+}
+
+func TestBucketDefaultTTL(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "buckets")
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+
+	opts, _ := bucket.ParseOptions(bm.Defaults, []string{"ttl=1h"})
+	if opts.DefaultTTL != 3600 {
+		t.Fatalf("expected ttl of 3600 seconds, got %d", opts.DefaultTTL)
+	}
+	bm.CreateBucket("sessions", opts)
+	b, _ := bm.GetBucket("sessions")
+
+	b.KvEngine.Put([]byte("long"), []byte("lived"))
+	b.KvEngine.PutWithTTL([]byte("short"), []byte("lived"), 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := b.KvEngine.Get([]byte("short")); err == nil {
+		t.Fatal("expected expired key to be gone")
+	}
+	if _, err := b.KvEngine.Get([]byte("long")); err != nil {
+		t.Fatal("key with default ttl expired too early:", err)
+	}
+	if pairs, _ := b.KvEngine.Range([]byte("a"), []byte("z")); len(pairs) != 1 {
+		t.Fatalf("expected range to skip the expired key, got %d pairs", len(pairs))
+	}
+
+	if _, err := bucket.ParseOptions(bm.Defaults, []string{"durability=maybe"}); err == nil {
+		t.Fatal("expected invalid durability to be rejected")
+	}
+
+	// a create with invalid options leaves nothing behind
+	invalid := bm.Defaults
+	invalid.Order = 2
+	if err := bm.CreateBucket("invalid", invalid); err == nil {
+		t.Fatal("expected order 2 to be rejected")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "invalid")); !os.IsNotExist(err) {
+		t.Fatal("failed create left the bucket directory behind:", err)
+	}
+}
+
+func TestFreezeBucket(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "buckets")
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm.CreateBucket("reference", bm.Defaults)
+	b, _ := bm.GetBucket("reference")
+	b.KvEngine.Put([]byte("pi"), []byte("3.14"))
+
+	if err := bm.FreezeBucket("reference", true); err != nil {
+		t.Fatal("FreezeBucket failed:", err)
+	}
+	if _, err := b.KvEngine.Put([]byte("pi"), []byte("3")); !errors.Is(err, kv.ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	if err := bm.DropBucket("reference"); err == nil {
+		t.Fatal("a frozen bucket must not be dropped")
+	}
+	info, _ := os.Stat(filepath.Join(dir, "reference"))
+	if info.Mode().Perm() != 0555 {
+		t.Fatalf("expected bucket dir mode 0555, got %o", info.Mode().Perm())
+	}
+	bm.Close()
+
+	// still frozen after a restart, and the write-protected WAL can be opened
+	bm, err = bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+	b, err = bm.GetBucket("reference")
+	if err != nil {
+		t.Fatal("frozen bucket not loaded:", err)
+	}
+	if !b.Options.ReadOnly || !b.Options.ReadOnlyOnDisk {
+		t.Fatalf("freeze flags not persisted: %+v", b.Options)
+	}
+
+	if err := bm.UnfreezeBucket("reference"); err != nil {
+		t.Fatal("UnfreezeBucket failed:", err)
+	}
+	if _, err := b.KvEngine.Put([]byte("e"), []byte("2.71")); err != nil {
+		t.Fatal("write after unfreeze failed:", err)
+	}
+	info, _ = os.Stat(filepath.Join(dir, "reference"))
+	if info.Mode().Perm() != 0755 {
+		t.Fatalf("expected bucket dir mode 0755 after unfreeze, got %o", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"byted/DB_engine/codec"
+	"byted/DB_engine/config"
+	"byted/DB_engine/structs"
+)
+
+func TestBinaryCodecRoundTrip(t *testing.T) {
+	messages := []structs.Message{
+		{Type: "command", ID: 7, Command: "put k \x00\xff", Data: []string{"a", "", "\xfe"}},
+		{Type: "hello", Version: codec.Version, Capabilities: []string{codec.CapBinary}},
+		{Type: "request", ID: 1 << 40, Request: &structs.Request{
+			Op: structs.OpPut, Bucket: "b", Key: []byte{0}, Value: []byte("v"), End: []byte("z"),
+			TTL: 1500, Cond: structs.CondAbsent, Limit: 10, Options: []string{"order=8", ""}, Flags: 1<<32 - 1,
+		}},
+		{Type: "result", Bucket: "b", Result: &structs.Result{
+			LSN:     42,
+			Flags:   5,
+			Value:   bytes.Repeat([]byte{0xff}, 300), // length needs a two byte varint
+			Pairs:   []structs.KVPair{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("b")}},
+			Buckets: []structs.BucketInfo{{Name: "b", Loaded: true, ReadOnly: true}},
+			Next:    []byte("c"),
+		}},
+		{Type: "request", Request: &structs.Request{
+			Op: structs.OpClone, Bucket: "b", Target: "c", AsOf: 12, Chmod: true, Path: "incr", Base: "full", Setting: "data_dir",
+		}},
+		{Type: "result", Result: &structs.Result{
+			Count:    3,
+			Options:  []string{"order=8", "protected=true"},
+			Trash:    []structs.TrashEntry{{Name: "old", DroppedAt: 1700000000000, PurgeAt: 1700086400000}, {Name: "older"}},
+			Base:     "full",
+			Buckets:  []structs.BucketInfo{{Name: "b", LSN: 7, Bytes: 1 << 20}},
+			Lines:    []string{`{"user":"admin"}`},
+			Log:      "audit.log",
+			Off:      []string{"read", "bucket:cache"},
+			Settings: []structs.Setting{{Name: "listen_addr", Value: ":4040", Source: "env"}, {Name: "tls_key"}},
+		}},
+		{Type: "error", Code: structs.CodeNotFound, Message: "key not found"},
+		{Type: "event", ID: 3, Bucket: "b", Event: &structs.Event{Op: structs.OpPut, Key: []byte{0, 1}, Value: []byte("v"), LSN: 9}},
+	}
+
+	var buf bytes.Buffer
+	enc := codec.NewBinaryEncoder(&buf)
+	for _, msg := range messages {
+		if err := enc.Encode(msg); err != nil {
+			t.Fatal("Encode failed:", err)
+		}
+	}
+
+	dec := codec.NewBinaryDecoder(&buf)
+	for i, want := range messages {
+		var got structs.Message
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("message %d: Decode failed: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("message %d:\n got  %+v\n want %+v", i, got, want)
+		}
+	}
+	var msg structs.Message
+	if err := dec.Decode(&msg); err != io.EOF {
+		t.Fatal("expected EOF after the last frame, got", err)
+	}
+
+	// a frame cut short is an error, not a partial message
+	buf.Reset()
+	enc.Encode(messages[2])
+	frame := buf.Bytes()
+	if err := codec.NewBinaryDecoder(bytes.NewReader(frame[:len(frame)-3])).Decode(&msg); err != io.ErrUnexpectedEOF {
+		t.Fatal("expected ErrUnexpectedEOF, got", err)
+	}
+}
+
+func TestBinaryHandshake(t *testing.T) {
+	srv, _, _ := startServer(t, nil)
+
+	// a client that asks for nothing stays on JSON
+	c := dialAndLogin(t, srv.Addr().String())
+	c.enc.Encode(structs.Message{Type: "hello", Version: codec.Version, Capabilities: []string{"compression"}})
+	if msg := c.expect("hello"); len(msg.Capabilities) != 0 {
+		t.Fatalf("unexpected capabilities: %+v", msg)
+	}
+	if msg := c.command("create kv"); msg.Type != "success" {
+		t.Fatalf("create failed: %+v", msg)
+	}
+
+	c = dialAndLogin(t, srv.Addr().String())
+	c.upgrade()
+	value := []byte{0, 0xff, '\n', '"'}
+	c.enc.Encode(structs.Message{Type: "request", ID: 1, Request: &structs.Request{Op: structs.OpPut, Bucket: "kv", Key: []byte("k"), Value: value}})
+	if msg := c.expect("result"); msg.ID != 1 || msg.Result.LSN != 1 {
+		t.Fatalf("put failed: %+v", msg)
+	}
+	if msg := c.command("use kv"); msg.Bucket != "kv" {
+		t.Fatalf("use failed: %+v", msg)
+	}
+	if msg := c.command("get k"); msg.Type != "success" || msg.Data[0] != "Value: "+string(value)+"\n" {
+		t.Fatalf("unexpected get reply: %+v", msg)
+	}
+}
+
+// benchmarkCodec encodes and decodes a put of a value of the given size.
+func benchmarkCodec(b *testing.B, size int, newEnc func(io.Writer) codec.Encoder, newDec func(io.Reader) codec.Decoder) {
+	value := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(value)
+	msg := structs.Message{Type: "request", ID: 1, Request: &structs.Request{Op: structs.OpPut, Bucket: "bench", Key: []byte("key"), Value: value}}
+
+	var buf bytes.Buffer
+	enc, dec := newEnc(&buf), newDec(&buf)
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	wire := 0
+	for i := 0; i < b.N; i++ {
+		if err := enc.Encode(msg); err != nil {
+			b.Fatal(err)
+		}
+		wire = buf.Len()
+		var got structs.Message
+		if err := dec.Decode(&got); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(wire), "wire-bytes/op")
+}
+
+func BenchmarkCodec(b *testing.B) {
+	jsonEnc := func(w io.Writer) codec.Encoder { return codec.NewJSONEncoder(json.NewEncoder(w)) }
+	jsonDec := func(r io.Reader) codec.Decoder { return codec.NewJSONDecoder(json.NewDecoder(r)) }
+	binEnc := func(w io.Writer) codec.Encoder { return codec.NewBinaryEncoder(w) }
+	binDec := func(r io.Reader) codec.Decoder { return codec.NewBinaryDecoder(r) }
+
+	for _, size := range []int{16, 4 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("json/%d", size), func(b *testing.B) { benchmarkCodec(b, size, jsonEnc, jsonDec) })
+		b.Run(fmt.Sprintf("binary/%d", size), func(b *testing.B) { benchmarkCodec(b, size, binEnc, binDec) })
+	}
+}
+
+// BenchmarkPipelinedPuts measures puts of 4 KiB values through a server, with
+// up to 64 of them in flight.
+func BenchmarkPipelinedPuts(b *testing.B) {
+	for _, binary := range []bool{false, true} {
+		name := "json"
+		if binary {
+			name = "binary"
+		}
+		b.Run(name, func(b *testing.B) {
+			srv, _, _ := startServer(b, func(cfg *config.Config) { cfg.Durability = "none" })
+			c := dialAndLogin(b, srv.Addr().String())
+			c.command("create bench")
+			if binary {
+				c.upgrade()
+			}
+
+			value := make([]byte, 4<<10)
+			rand.New(rand.NewSource(1)).Read(value)
+			c.conn.SetReadDeadline(time.Time{})
+			b.SetBytes(int64(len(value)))
+			b.ResetTimer()
+
+			const window = 64
+			done := make(chan error, 1)
+			go func() {
+				for i := 0; i < b.N; i++ {
+					var msg structs.Message
+					if err := c.dec.Decode(&msg); err != nil {
+						done <- err
+						return
+					}
+				}
+				done <- nil
+			}()
+			for i := 0; i < b.N; i++ {
+				req := structs.Request{Op: structs.OpPut, Bucket: "bench", Key: []byte(fmt.Sprintf("k%d", i%window)), Value: value}
+				if err := c.enc.Encode(structs.Message{Type: "request", ID: uint64(i + 1), Request: &req}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := <-done; err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
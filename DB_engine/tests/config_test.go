@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"byted/DB_engine/config"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bytedata.json")
+	file := `{"listen_addr": ":7000", "data_dir": "/srv/bytedata", "btree_order": 8, "idle_timeout": "5m"}`
+	if err := os.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// env beats the file, flags beat env
+	t.Setenv("BYTEDATA_LISTEN_ADDR", ":7001")
+	t.Setenv("BYTEDATA_BTREE_ORDER", "16")
+	cfg, err := config.Load([]string{"-config", path, "-btree-order", "32"})
+	if err != nil {
+		t.Fatal("Load failed:", err)
+	}
+
+	if cfg.ListenAddr != ":7001" {
+		t.Fatalf("listen_addr: expected env value :7001, got %s", cfg.ListenAddr)
+	}
+	if cfg.BTreeOrder != 32 {
+		t.Fatalf("btree_order: expected flag value 32, got %d", cfg.BTreeOrder)
+	}
+	if cfg.DataDir != "/srv/bytedata" || cfg.IdleTimeout.Duration != 5*time.Minute {
+		t.Fatalf("file values not applied: %+v", cfg)
+	}
+	if cfg.AuthFile != filepath.Join("/srv/bytedata", "auth.json") {
+		t.Fatalf("auth_file should default into data_dir, got %s", cfg.AuthFile)
+	}
+
+	for name, want := range map[string]string{"listen_addr": "env", "btree_order": "flag", "data_dir": "file", "durability": "default"} {
+		if _, src, _ := cfg.Get(name); src != want {
+			t.Fatalf("%s: expected source %s, got %s", name, want, src)
+		}
+	}
+}
+
+func TestConfigRejectsInvalidValues(t *testing.T) {
+	if _, err := config.Load([]string{"-config", filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Fatal("expected an error for a missing explicit config file")
+	}
+	if _, err := config.Load([]string{"-durability", "sometimes"}); err == nil {
+		t.Fatal("expected an error for an unknown durability mode")
+	}
+	if _, err := config.Load([]string{"-max-connections-per-ip", "-1"}); err == nil {
+		t.Fatal("expected an error for a negative connection limit")
+	}
+	if _, err := config.Load([]string{"-audit-off", "read,writes"}); err == nil {
+		t.Fatal("expected an error for an unknown audit class")
+	}
+
+	path := filepath.Join(t.TempDir(), "typo.json")
+	os.WriteFile(path, []byte(`{"listen_adr": ":1"}`), 0600)
+	if _, err := config.Load([]string{"-config", path}); err == nil {
+		t.Fatal("expected an error for an unknown key in the config file")
+	}
+}
@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"byted/DB_engine/config"
+)
+
+// httpClient sends API requests as admin, or with a bearer token once set.
+type httpClient struct {
+	t     *testing.T
+	base  string
+	token string
+}
+
+// do sends a request and returns the response with its body read.
+func (c *httpClient) do(method, path, body string, header ...string) (*http.Response, string) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.base+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal("NewRequest failed:", err)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else {
+		req.SetBasicAuth("admin", "secret")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.t.Fatal("read failed:", err)
+	}
+	return res, string(data)
+}
+
+// expect sends a request and checks the status.
+func (c *httpClient) expect(status int, method, path, body string, header ...string) (*http.Response, string) {
+	c.t.Helper()
+	res, data := c.do(method, path, body, header...)
+	if res.StatusCode != status {
+		c.t.Fatalf("%s %s: got %d (%s), want %d", method, path, res.StatusCode, data, status)
+	}
+	return res, data
+}
+
+func TestHTTPAPI(t *testing.T) {
+	srv, _, _ := startServer(t, func(cfg *config.Config) { cfg.HTTPAddr = "127.0.0.1:0" })
+	base := "http://" + srv.HTTPListener.Addr().String()
+	c := &httpClient{t: t, base: base}
+
+	// credentials are checked before anything else
+	if res, err := http.Get(base + "/buckets"); err != nil || res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %v %v", res, err)
+	}
+	c.token = "nope"
+	c.expect(http.StatusUnauthorized, "GET", "/buckets", "")
+	c.token = ""
+
+	c.expect(http.StatusCreated, "PUT", "/buckets/users", "")
+	c.expect(http.StatusConflict, "PUT", "/buckets/users", "")
+	if _, body := c.expect(http.StatusOK, "GET", "/buckets", ""); !strings.Contains(body, `"name":"users"`) {
+		t.Fatalf("bucket missing from the list: %s", body)
+	}
+
+	c.expect(http.StatusNotFound, "GET", "/buckets/users/keys/alice", "")
+	c.expect(http.StatusNotFound, "GET", "/buckets/missing/keys/alice", "")
+	res, _ := c.expect(http.StatusNoContent, "PUT", "/buckets/users/keys/alice", "v1\x00")
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("PUT did not return an ETag")
+	}
+	res, body := c.expect(http.StatusOK, "GET", "/buckets/users/keys/alice", "")
+	if body != "v1\x00" || res.Header.Get("ETag") != etag {
+		t.Fatalf("GET returned %q with ETag %s, want ETag %s", body, res.Header.Get("ETag"), etag)
+	}
+	c.expect(http.StatusNotModified, "GET", "/buckets/users/keys/alice", "", "If-None-Match", etag)
+
+	// conditional writes
+	c.expect(http.StatusPreconditionFailed, "PUT", "/buckets/users/keys/alice", "x", "If-None-Match", "*")
+	c.expect(http.StatusCreated, "PUT", "/buckets/users/keys/bob", "b", "If-None-Match", "*")
+	c.expect(http.StatusPreconditionFailed, "PUT", "/buckets/users/keys/carol", "c", "If-Match", "*")
+	res, _ = c.expect(http.StatusNoContent, "PUT", "/buckets/users/keys/alice", "v2", "If-Match", etag)
+	c.expect(http.StatusPreconditionFailed, "PUT", "/buckets/users/keys/alice", "v3", "If-Match", etag)
+	c.expect(http.StatusPreconditionFailed, "DELETE", "/buckets/users/keys/alice", "", "If-Match", etag)
+	c.expect(http.StatusNoContent, "DELETE", "/buckets/users/keys/alice", "", "If-Match", res.Header.Get("ETag"))
+	c.expect(http.StatusNotFound, "DELETE", "/buckets/users/keys/alice", "")
+	c.expect(http.StatusBadRequest, "PUT", "/buckets/users/keys/x?ttl=soon", "v")
+
+	// keys may contain slashes and need escaping
+	c.expect(http.StatusNoContent, "PUT", "/buckets/users/keys/dir/a%20b", "nested")
+	if _, body := c.expect(http.StatusOK, "GET", "/buckets/users/keys/dir/a%20b", ""); body != "nested" {
+		t.Fatalf("GET of a nested key returned %q", body)
+	}
+
+	// bearer tokens work like the credentials they were issued for
+	_, body = c.expect(http.StatusCreated, "POST", "/auth/token", "")
+	var token struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal([]byte(body), &token); err != nil || token.Token == "" {
+		t.Fatalf("unexpected token response %s: %v", body, err)
+	}
+	c.token = token.Token
+	for _, k := range []string{"k1", "k2", "k3", "k4", "k5"} {
+		c.expect(http.StatusNoContent, "PUT", "/buckets/users/keys/"+k, k)
+	}
+
+	// range pages follow the next link and stop at end
+	var keys []string
+	path := "/buckets/users/range?start=k1&end=k4&limit=2"
+	for pages := 0; path != ""; pages++ {
+		_, body := c.expect(http.StatusOK, "GET", path, "")
+		var page struct {
+			Pairs []struct {
+				Key   []byte `json:"key"`
+				Value []byte `json:"value"`
+			} `json:"pairs"`
+			Next string `json:"next"`
+		}
+		if err := json.Unmarshal([]byte(body), &page); err != nil {
+			t.Fatalf("bad range page %s: %v", body, err)
+		}
+		for _, p := range page.Pairs {
+			keys = append(keys, string(p.Key))
+		}
+		if path = page.Next; pages > 5 {
+			t.Fatal("range does not finish")
+		}
+	}
+	if strings.Join(keys, ",") != "k1,k2,k3,k4" {
+		t.Fatalf("range returned %v", keys)
+	}
+	c.expect(http.StatusBadRequest, "GET", "/buckets/users/range?limit=0", "")
+
+	c.expect(http.StatusNoContent, "DELETE", "/auth/token", "")
+	c.expect(http.StatusUnauthorized, "GET", "/buckets", "")
+	c.token = ""
+	c.expect(http.StatusNoContent, "DELETE", "/buckets/users", "")
+	c.expect(http.StatusNotFound, "DELETE", "/buckets/users", "")
+}
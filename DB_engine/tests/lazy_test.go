@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"byted/DB_engine/core/bucket"
+)
+
+func TestLazyLoadingAndIdleUnload(t *testing.T) {
+	_, dir := seedBuckets(t, "hot", "cold")
+
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cold, _ := bm.GetBucket("cold")
+	if cold.Loaded() {
+		t.Fatal("bucket loaded at startup without being used")
+	}
+	if v, err := cold.KvEngine.Get([]byte("k")); err != nil || string(v) != "cold" {
+		t.Fatalf("first access did not load the bucket: %q, %v", v, err)
+	}
+	if !cold.Loaded() {
+		t.Fatal("bucket not loaded after access")
+	}
+
+	if err := bm.PinBucket("hot", true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	unloaded := bm.UnloadIdle(10 * time.Millisecond)
+	if len(unloaded) != 1 || unloaded[0] != "cold" {
+		t.Fatalf("expected only cold to be unloaded, got %v", unloaded)
+	}
+
+	// an unloaded bucket keeps working, it loads again on the next write
+	if _, err := cold.KvEngine.Put([]byte("k2"), []byte("v2")); err != nil {
+		t.Fatal("write to an unloaded bucket failed:", err)
+	}
+	if v, _ := cold.KvEngine.Get([]byte("k")); string(v) != "cold" {
+		t.Fatal("data lost by unloading")
+	}
+	bm.Close()
+
+	// pinned buckets are loaded by startup recovery
+	bm, err = bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+	if summary := bm.RecoverBuckets(2, false, nil); summary.Buckets != 1 {
+		t.Fatalf("expected only the pinned bucket to be recovered, got %+v", summary)
+	}
+	hot, _ := bm.GetBucket("hot")
+	if !hot.Loaded() || !hot.Options.Pinned {
+		t.Fatal("pinned bucket not loaded at startup")
+	}
+	cold, _ = bm.GetBucket("cold")
+	if cold.Loaded() {
+		t.Fatal("unpinned bucket loaded at startup")
+	}
+	// the last LSN is read from the WAL without loading
+	if lsn, err := cold.KvEngine.LastLSN(); err != nil || lsn != 2 || cold.Loaded() {
+		t.Fatalf("expected LSN 2 without a load, got %d, %v, loaded %v", lsn, err, cold.Loaded())
+	}
+	if v, _ := cold.KvEngine.Get([]byte("k2")); string(v) != "v2" {
+		t.Fatal("write made while unloaded was lost")
+	}
+}
+
+func TestParallelRecovery(t *testing.T) {
+	names := []string{"b1", "b2", "b3", "b4", "b5", "b6"}
+	_, dir := seedBuckets(t, names...)
+
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+
+	var calls, lastDone int
+	summary := bm.RecoverBuckets(3, true, func(done, total int) {
+		calls++
+		lastDone = done
+		if total != len(names) {
+			t.Errorf("expected a total of %d, got %d", len(names), total)
+		}
+	})
+	if summary.Buckets != len(names) || summary.Records != len(names) || len(summary.Failed) != 0 {
+		t.Fatalf("unexpected recovery summary: %+v", summary)
+	}
+	if summary.Bytes == 0 {
+		t.Fatal("recovered bytes not counted")
+	}
+	if calls != len(names) || lastDone != len(names) {
+		t.Fatalf("progress reported %d times, last at %d", calls, lastDone)
+	}
+	for _, name := range names {
+		if b, _ := bm.GetBucket(name); !b.Loaded() {
+			t.Fatalf("bucket %s not loaded by recovery", name)
+		}
+	}
+}
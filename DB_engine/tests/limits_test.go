@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"byted/DB_engine/codec"
+	"byted/DB_engine/config"
+	"byted/DB_engine/structs"
+)
+
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("Dial failed:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, enc: codec.NewJSONEncoder(json.NewEncoder(conn)), dec: codec.NewJSONDecoder(json.NewDecoder(conn))}
+}
+
+// expectClosed checks that the server closed the connection.
+func (c *testClient) expectClosed() {
+	c.t.Helper()
+	var msg structs.Message
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := c.dec.Decode(&msg); !errors.Is(err, io.EOF) {
+		c.t.Fatalf("expected the connection to be closed, got %+v %v", msg, err)
+	}
+}
+
+// expectLimit reads the error sent before the server closes the connection.
+func (c *testClient) expectLimit(code, message string) {
+	c.t.Helper()
+	if msg := c.expect("error"); msg.Code != code || !strings.Contains(msg.Message, message) {
+		c.t.Fatalf("expected a %s error about %q, got %+v", code, message, msg)
+	}
+	c.expectClosed()
+}
+
+func TestMaxConnections(t *testing.T) {
+	srv, _, _ := startServer(t, func(cfg *config.Config) { cfg.MaxConnections = 1 })
+	addr := srv.Addr().String()
+
+	first := dialAndLogin(t, addr)
+	dial(t, addr).expectLimit(structs.CodeLimit, "too many connections")
+
+	// the slot is free again once the first client is gone
+	first.conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c := dial(t, addr)
+		if msg := c.read(); msg.Type == "request" {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("slot not released: %+v", msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMaxConnectionsPerIP(t *testing.T) {
+	srv, _, _ := startServer(t, func(cfg *config.Config) {
+		cfg.MaxConnsPerIP = 1
+		cfg.HTTPAddr = "127.0.0.1:0"
+	})
+	first := dialAndLogin(t, srv.Addr().String())
+	dial(t, srv.Addr().String()).expectLimit(structs.CodeLimit, "too many connections from 127.0.0.1")
+
+	// HTTP connections count against the same limit
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	url := "http://" + srv.HTTPListener.Addr().String() + "/buckets"
+	res, err := client.Get(url)
+	if err != nil {
+		t.Fatal("GET failed:", err)
+	}
+	var body struct{ Code, Message string }
+	json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable || body.Code != structs.CodeLimit {
+		t.Fatalf("expected 503 %s, got %d %+v", structs.CodeLimit, res.StatusCode, body)
+	}
+
+	first.conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := client.Get(url)
+		if err != nil {
+			t.Fatal("GET failed:", err)
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusUnauthorized {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("per-IP slot not released: %d", res.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	srv, _, _ := startServer(t, func(cfg *config.Config) { cfg.AuthTimeout = config.Duration{Duration: 300 * time.Millisecond} })
+
+	// asked for a username, never answers
+	c := dial(t, srv.Addr().String())
+	c.expect("request")
+	c.expectLimit(structs.CodeLimit, "authentication timed out after 300ms")
+
+	// logged in, then goes quiet; the idle timer starts after the login
+	srv, _, _ = startServer(t, func(cfg *config.Config) { cfg.IdleTimeout = config.Duration{Duration: 300 * time.Millisecond} })
+	c = dialAndLogin(t, srv.Addr().String())
+	if msg := c.command("list"); msg.Type != "success" {
+		t.Fatalf("list failed: %+v", msg)
+	}
+	c.expectLimit(structs.CodeLimit, "idle for more than 300ms")
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	srv, _, _ := startServer(t, func(cfg *config.Config) {
+		cfg.MaxMessageSize = 1024
+		cfg.HTTPAddr = "127.0.0.1:0"
+		cfg.RESPAddr = "127.0.0.1:0"
+	})
+	addr := srv.Addr().String()
+	big := strings.Repeat("x", 4096)
+
+	// before login
+	c := dial(t, addr)
+	c.expect("request")
+	c.enc.Encode(structs.Message{Type: "auth", Username: big})
+	c.expectLimit(structs.CodeTooLarge, "limit of 1024 bytes")
+
+	c = dialAndLogin(t, addr)
+	c.command("create limits")
+	put := func(value string) {
+		c.enc.Encode(structs.Message{Type: "request", Request: &structs.Request{Op: structs.OpPut, Bucket: "limits", Key: []byte("k"), Value: []byte(value)}})
+	}
+	put(big[:256])
+	c.expect("result")
+	put(big)
+	c.expectLimit(structs.CodeTooLarge, "limit of 1024 bytes")
+
+	// the binary framing is held to the same limit
+	c = dialAndLogin(t, addr)
+	c.upgrade()
+	put(big[:256])
+	c.expect("result")
+	put(big)
+	c.expectLimit(structs.CodeTooLarge, "limit of 1024 bytes")
+
+	h := &httpClient{t: t, base: "http://" + srv.HTTPListener.Addr().String()}
+	h.expect(http.StatusNoContent, "PUT", "/buckets/limits/keys/k", big[:256])
+	if _, body := h.expect(http.StatusRequestEntityTooLarge, "PUT", "/buckets/limits/keys/k", big); !strings.Contains(body, "limit of 1024 bytes") {
+		t.Fatalf("unexpected 413 body: %s", body)
+	}
+
+	r := dialRESP(t, srv.RESPListener.Addr().String())
+	r.expect("+OK", "AUTH", "admin", "secret")
+	if got := r.do("SET", "k", big); !strings.Contains(got.(string), "command over the limit of 1024 bytes") {
+		t.Fatalf("unexpected reply to an oversized SET: %v", got)
+	}
+}
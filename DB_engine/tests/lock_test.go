@@ -0,0 +1,44 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"byted/DB_engine/core/lock"
+)
+
+func TestDataDirLock(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := lock.Acquire(dir)
+	if err != nil {
+		t.Fatal("first Acquire failed:", err)
+	}
+
+	// a second instance fails fast and names the owner
+	if _, err := lock.Acquire(dir); !errors.Is(err, lock.ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	} else {
+		t.Log(err)
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatal("Release failed:", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bytedata.pid")); !os.IsNotExist(err) {
+		t.Fatal("pid file should be removed on release")
+	}
+
+	// a pid file without a lock holder is what a crashed process leaves behind
+	os.WriteFile(filepath.Join(dir, "bytedata.pid"), []byte("999999\n"), 0644)
+	second, err := lock.Acquire(dir)
+	if err != nil {
+		t.Fatal("Acquire over a stale lock failed:", err)
+	}
+	defer second.Release()
+	if second.StalePID != 999999 {
+		t.Fatalf("expected stale pid 999999, got %d", second.StalePID)
+	}
+}
@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"byted/DB_engine/config"
+	"byted/DB_engine/core/bucket"
+	"byted/DB_engine/core/kv"
+)
+
+// mcClient speaks the memcached text protocol line by line.
+type mcClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialMemcached(t *testing.T, addr string) *mcClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("Dial failed:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &mcClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *mcClient) send(s string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, s); err != nil {
+		c.t.Fatal("write failed:", err)
+	}
+}
+
+func (c *mcClient) line() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal("read failed:", err)
+	}
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+// expect sends a command and checks the reply lines.
+func (c *mcClient) expect(cmd string, want ...string) {
+	c.t.Helper()
+	c.send(cmd)
+	for _, w := range want {
+		if got := c.line(); got != w {
+			c.t.Fatalf("%q: got %q, want %q", cmd, got, w)
+		}
+	}
+}
+
+// gets returns the CAS value of key.
+func (c *mcClient) gets(key string) string {
+	c.t.Helper()
+	c.send("gets " + key + "\r\n")
+	fields := strings.Fields(c.line())
+	if len(fields) != 5 || fields[0] != "VALUE" {
+		c.t.Fatalf("gets %s: unexpected reply %v", key, fields)
+	}
+	c.line()
+	if end := c.line(); end != "END" {
+		c.t.Fatalf("gets %s: got %q, want END", key, end)
+	}
+	return fields[4]
+}
+
+func TestMemcachedListener(t *testing.T) {
+	srv, _, _ := startServer(t, func(cfg *config.Config) {
+		// the listener's bucket has to exist when the server starts
+		bm, err := bucket.NewBucketManager(cfg.BucketsDir(), bucket.Options{Order: cfg.BTreeOrder})
+		if err != nil {
+			t.Fatal("NewBucketManager failed:", err)
+		}
+		if err := bm.CreateBucket("cache", bm.Defaults); err != nil {
+			t.Fatal("CreateBucket failed:", err)
+		}
+		bm.Close()
+		cfg.Memcached = "127.0.0.1:0=cache"
+	})
+	if len(srv.Memcached) != 1 {
+		t.Fatalf("expected one memcached listener, got %d", len(srv.Memcached))
+	}
+	c := dialMemcached(t, srv.Memcached[0].Addr().String())
+
+	c.expect("get k\r\n", "END")
+	c.expect("set k 5 0 5\r\nhello\r\n", "STORED")
+	c.expect("get k missing\r\n", "VALUE k 5 5", "hello", "END")
+	c.expect("add k 0 0 1\r\nx\r\n", "NOT_STORED")
+	c.expect("replace missing 0 0 1\r\nx\r\n", "NOT_STORED")
+	c.expect("replace k 0 0 3\r\nbye\r\n", "STORED")
+
+	// cas succeeds with the version from gets, then fails with it
+	cas := c.gets("k")
+	c.expect("cas k 0 0 2 "+cas+"\r\nv2\r\n", "STORED")
+	c.expect("cas k 0 0 2 "+cas+"\r\nv3\r\n", "EXISTS")
+	c.expect("cas missing 0 0 2 1\r\nv3\r\n", "NOT_FOUND")
+	if next := c.gets("k"); next == cas {
+		t.Fatal("cas value did not change on a write")
+	}
+
+	c.expect("set n 0 0 2\r\n10\r\n", "STORED")
+	c.expect("incr n 5\r\n", "15")
+	c.expect("decr n 20\r\n", "0")
+	// deltas go up to 2^64-1, and an increment wraps around at 2^64
+	c.expect("incr n 18446744073709551615\r\n", "18446744073709551615")
+	c.expect("incr n 2\r\n", "1")
+	c.expect("decr n 18446744073709551615\r\n", "0")
+	c.expect("incr n 18446744073709551616\r\n", "CLIENT_ERROR invalid numeric delta argument")
+	c.expect("incr missing 1\r\n", "NOT_FOUND")
+	c.expect("incr k 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value")
+
+	c.expect("touch k 60\r\n", "TOUCHED")
+	c.expect("touch missing 60\r\n", "NOT_FOUND")
+	c.expect("set gone 0 -1 1\r\nx\r\n", "STORED")
+	c.expect("get gone\r\n", "END")
+
+	// flags are stored with the value, kept by touch and incr and replaced by a set
+	c.expect("set f 4294967295 0 3\r\nabc\r\n", "STORED")
+	c.expect("set num 42 0 1\r\n1\r\n", "STORED")
+	c.expect("touch f 60\r\n", "TOUCHED")
+	c.expect("incr num 1\r\n", "2")
+	c.expect("get f num\r\n", "VALUE f 4294967295 3", "abc", "VALUE num 42 1", "2", "END")
+	c.expect("set f 0 0 1\r\nx\r\n", "STORED")
+	c.expect("get f\r\n", "VALUE f 0 1", "x", "END")
+	c.expect("set f 4294967296 0 1\r\nx\r\n", "CLIENT_ERROR bad command line format")
+
+	c.expect("delete k\r\n", "DELETED")
+	c.expect("delete k\r\n", "NOT_FOUND")
+
+	// noreply commands send nothing back, so the next reply is the version
+	c.expect("set q 0 0 1 noreply\r\nq\r\ndelete q noreply\r\nversion\r\n", "VERSION 1.6.0")
+	c.expect("bogus\r\n", "ERROR")
+	c.expect("set k 0 0 notanumber\r\n", "CLIENT_ERROR bad command line format")
+
+	// pipelined: everything is sent before any reply is read
+	var b strings.Builder
+	for i := 0; i < 25; i++ {
+		v := strconv.Itoa(i)
+		b.WriteString("set p" + v + " 0 0 " + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	}
+	c.send(b.String())
+	for i := 0; i < 25; i++ {
+		if got := c.line(); got != "STORED" {
+			t.Fatalf("pipelined set %d: %q", i, got)
+		}
+	}
+	c.expect("quit\r\n")
+}
+
+func TestItemFlagsSurviveReopen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "buckets")
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, _ := bucket.ParseOptions(bm.Defaults, []string{"compression=deflate"})
+	bm.CreateBucket("plain", bm.Defaults)
+	bm.CreateBucket("packed", packed)
+	for _, name := range []string{"plain", "packed"} {
+		b, _ := bm.GetBucket(name)
+		if _, err := b.KvEngine.PutIf([]byte("k"), []byte(strings.Repeat("v", 100)), time.Hour, 7, kv.Cond{}); err != nil {
+			t.Fatal("PutIf failed:", err)
+		}
+	}
+	if _, _, err := bm.CloneBucket("plain", "copy", 0); err != nil {
+		t.Fatal("CloneBucket failed:", err)
+	}
+	bm.Close()
+
+	bm, err = bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+	for _, name := range []string{"plain", "packed", "copy"} {
+		b, err := bm.GetBucket(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		item, err := b.KvEngine.GetItem([]byte("k"))
+		if err != nil || item.Flags != 7 || len(item.Value) != 100 {
+			t.Fatalf("%s: expected the value with flags 7 after reopen, got %d bytes, flags %d, %v", name, len(item.Value), item.Flags, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"byted/DB_engine/constants"
+	"byted/DB_engine/core/bucket"
+)
+
+// seedBuckets creates buckets on disk and returns the data dir and buckets dir.
+func seedBuckets(t *testing.T, names ...string) (string, string) {
+	t.Helper()
+	dataDir := t.TempDir()
+	dir := filepath.Join(dataDir, "buckets")
+
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if err := bm.CreateBucket(name, bm.Defaults); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := bm.GetBucket(name)
+		b.KvEngine.Put([]byte("k"), []byte(name))
+	}
+	if err := bm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dataDir, dir
+}
+
+func TestMetadataMigratesFromV0(t *testing.T) {
+	dataDir, dir := seedBuckets(t, "a", "b")
+	metaPath := filepath.Join(dataDir, "buckets_meta.json")
+
+	// the pre-versioning format
+	old := `{"buckets": ["a", "b"], "options": {"b": {"order": 9, "durability": "none"}}, "active_bucket": ""}`
+	os.WriteFile(metaPath, []byte(old), 0644)
+
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal("load failed:", err)
+	}
+	defer bm.Close()
+
+	b, err := bm.GetBucket("b")
+	if err != nil || b.Options.Order != 9 || b.Options.Durability != "none" {
+		t.Fatalf("options lost in migration: %+v, %v", b, err)
+	}
+
+	var meta bucket.MetaData
+	data, _ := os.ReadFile(metaPath)
+	if err := json.Unmarshal(data, &meta); err != nil || meta.Version != bucket.MetaVersion || len(meta.Buckets) != 2 {
+		t.Fatalf("catalog not rewritten in the current format: %s", data)
+	}
+	if _, err := os.Stat(metaPath + ".bak"); err != nil {
+		t.Fatal("expected the old catalog to be kept:", err)
+	}
+}
+
+func TestMetadataRecovery(t *testing.T) {
+	dataDir, dir := seedBuckets(t, "users", "orders")
+	metaPath := filepath.Join(dataDir, "buckets_meta.json")
+
+	// a crash mid-write used to leave a truncated file
+	os.WriteFile(metaPath, []byte(`{"version": 1, "buck`), 0644)
+
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal("recovery failed:", err)
+	}
+	for _, name := range []string{"users", "orders"} {
+		b, err := bm.GetBucket(name)
+		if err != nil {
+			t.Fatalf("bucket %s not recovered: %v", name, err)
+		}
+		if v, _ := b.KvEngine.Get([]byte("k")); string(v) != name {
+			t.Fatalf("bucket %s lost its data", name)
+		}
+	}
+	bm.Close()
+
+	// lost entirely: rebuilt from the directories as well
+	os.Remove(metaPath)
+	bm, err = bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(bm.ListBuckets("")); got != 2 {
+		t.Fatalf("expected 2 buckets after rebuild, got %d", got)
+	}
+	bm.Close()
+
+	// a catalog from a newer build is refused rather than misread
+	os.WriteFile(metaPath, []byte(`{"version": 99, "buckets": []}`), 0644)
+	if _, err := bucket.NewBucketManager(dir, bucket.DefaultOptions()); err == nil {
+		t.Fatal("expected an error for a newer metadata version")
+	}
+}
+
+func TestMetadataKeepsUnavailableBuckets(t *testing.T) {
+	dataDir, dir := seedBuckets(t, "a")
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := bm.Defaults
+	opts.Order = 9
+	if err := bm.CreateBucket("b", opts); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := bm.GetBucket("b")
+	b.KvEngine.Put([]byte("k"), []byte("b"))
+	bm.Close()
+
+	// b's WAL goes missing, e.g. a volume that did not mount in time
+	walPath := filepath.Join(dir, "b", "b"+constants.WALFILENAME)
+	os.Rename(walPath, walPath+".away")
+
+	bm, err = bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bm.GetBucket("b"); !errors.Is(err, bucket.ErrUnavailable) {
+		t.Fatalf("expected b to be unavailable, got %v", err)
+	}
+	if err := bm.CreateBucket("b", bm.Defaults); !errors.Is(err, bucket.ErrBucketExists) {
+		t.Fatalf("expected the name b to stay taken, got %v", err)
+	}
+	if _, ok := bm.Unavailable()["b"]; !ok {
+		t.Fatal("b not reported as unavailable")
+	}
+	// a catalog write while b is unavailable keeps its entry
+	if err := bm.CreateBucket("c", bm.Defaults); err != nil {
+		t.Fatal(err)
+	}
+	bm.Close()
+
+	var meta bucket.MetaData
+	data, _ := os.ReadFile(filepath.Join(dataDir, "buckets_meta.json"))
+	json.Unmarshal(data, &meta)
+	if len(meta.Buckets) != 3 {
+		t.Fatalf("expected a, b and c in the catalog, got %s", data)
+	}
+
+	// back once the WAL is, with its options
+	os.Rename(walPath+".away", walPath)
+	bm, err = bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+	b, err = bm.GetBucket("b")
+	if err != nil || b.Options.Order != 9 {
+		t.Fatalf("b not restored with its options: %+v, %v", b, err)
+	}
+	if v, _ := b.KvEngine.Get([]byte("k")); string(v) != "b" {
+		t.Fatal("b lost its data")
+	}
+}
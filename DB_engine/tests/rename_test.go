@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"byted/DB_engine/core/bucket"
+)
+
+func TestRenameBucket(t *testing.T) {
+	dataDir, dir := seedBuckets(t, "old", "other")
+
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.RenameBucket("old", "other"); err == nil {
+		t.Fatal("expected rename onto an existing bucket to fail")
+	}
+	if err := bm.RenameBucket("old", "new"); err != nil {
+		t.Fatal("RenameBucket failed:", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new", "newwal.log")); err != nil {
+		t.Fatal("WAL not moved:", err)
+	}
+	bm.Close()
+
+	bm, err = bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bm.GetBucket("old"); err == nil {
+		t.Fatal("old name still present after restart")
+	}
+	b, err := bm.GetBucket("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := b.KvEngine.Get([]byte("k")); string(v) != "old" {
+		t.Fatalf("data did not follow the rename, got %q", v)
+	}
+	bm.Close()
+
+	// a crash after the catalog was written but before the files moved
+	metaPath := filepath.Join(dataDir, "buckets_meta.json")
+	meta := bucket.MetaData{Version: bucket.MetaVersion, Buckets: []bucket.BucketMeta{
+		{Name: "renamed", Options: bucket.DefaultOptions(), RenamedFrom: "new"},
+		{Name: "other", Options: bucket.DefaultOptions()},
+	}}
+	data, _ := json.Marshal(meta)
+	os.WriteFile(metaPath, data, 0644)
+
+	bm, err = bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+	b, err = bm.GetBucket("renamed")
+	if err != nil {
+		t.Fatal("interrupted rename not finished:", err)
+	}
+	if v, _ := b.KvEngine.Get([]byte("k")); string(v) != "old" {
+		t.Fatalf("data lost finishing the rename, got %q", v)
+	}
+}
+
+func TestCloneBucket(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "buckets")
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+
+	opts, _ := bucket.ParseOptions(bm.Defaults, []string{"compression=deflate"})
+	bm.CreateBucket("src", opts)
+	src, _ := bm.GetBucket("src")
+	src.KvEngine.Put([]byte("a"), []byte("1"))
+	lsn, _ := src.KvEngine.Put([]byte("b"), []byte("2"))
+	src.KvEngine.Delete([]byte("a"))
+	src.KvEngine.Put([]byte("c"), []byte("3"))
+
+	if _, count, err := bm.CloneBucket("src", "now", 0); err != nil || count != 2 {
+		t.Fatalf("clone of the current state: %d keys, %v", count, err)
+	}
+	if _, count, err := bm.CloneBucket("src", "then", lsn); err != nil || count != 2 {
+		t.Fatalf("clone as of LSN %d: %d keys, %v", lsn, count, err)
+	}
+	if _, _, err := bm.CloneBucket("src", "now", 0); err == nil {
+		t.Fatal("expected clone onto an existing bucket to fail")
+	}
+
+	then, _ := bm.GetBucket("then")
+	if v, err := then.KvEngine.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Fatalf("clone as of LSN %d should still have a=1, got %q, %v", lsn, v, err)
+	}
+	if _, err := then.KvEngine.Get([]byte("c")); err == nil {
+		t.Fatal("clone as of an LSN includes a later write")
+	}
+	if then.Options.Compression != "deflate" {
+		t.Fatal("clone did not inherit the source options")
+	}
+
+	// the copy is independent of the source
+	now, _ := bm.GetBucket("now")
+	now.KvEngine.Put([]byte("b"), []byte("changed"))
+	if v, _ := src.KvEngine.Get([]byte("b")); string(v) != "2" {
+		t.Fatalf("writing to the clone changed the source: %q", v)
+	}
+}
+
+func TestInvalidBucketNames(t *testing.T) {
+	_, dir := seedBuckets(t, "src")
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+
+	// ".clone-x" would be deleted as a stale clone, the rest leave BaseDir
+	for _, name := range []string{"", "../x", "a/b", `a\b`, "..", ".clone-x"} {
+		if err := bm.CreateBucket(name, bm.Defaults); !errors.Is(err, bucket.ErrInvalidName) {
+			t.Fatalf("create %q: expected an invalid name error, got %v", name, err)
+		}
+		if err := bm.RenameBucket("src", name); !errors.Is(err, bucket.ErrInvalidName) {
+			t.Fatalf("rename to %q: expected an invalid name error, got %v", name, err)
+		}
+		if _, _, err := bm.CloneBucket("src", name, 0); !errors.Is(err, bucket.ErrInvalidName) {
+			t.Fatalf("clone to %q: expected an invalid name error, got %v", name, err)
+		}
+		if err := bm.Undrop(name); !errors.Is(err, bucket.ErrInvalidName) {
+			t.Fatalf("undrop %q: expected an invalid name error, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "x")); !os.IsNotExist(err) {
+		t.Fatal("a bucket was created outside the buckets directory")
+	}
+	if _, err := bm.GetBucket("src"); err != nil {
+		t.Fatal("source bucket lost:", err)
+	}
+}
@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"byted/DB_engine/config"
+)
+
+// respClient is a minimal RESP client, enough to check what the server sends.
+type respClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRESP(t *testing.T, addr string) *respClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("Dial failed:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &respClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// send writes a command as an array of bulk strings without reading the reply.
+func (c *respClient) send(args ...string) {
+	c.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		c.t.Fatal("write failed:", err)
+	}
+}
+
+func (c *respClient) do(args ...string) any {
+	c.t.Helper()
+	c.send(args...)
+	return c.read()
+}
+
+// read parses a reply: simple strings as "+OK", errors as "-ERR ...",
+// integers as int64, bulk strings as string, nulls as nil, arrays as []any
+// and maps as map[string]any.
+func (c *respClient) read() any {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal("read failed:", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+', '-':
+		return line
+	case '_':
+		return nil
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			c.t.Fatal("read failed:", err)
+		}
+		return string(buf[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		items := make([]any, n)
+		for i := range items {
+			items[i] = c.read()
+		}
+		return items
+	case '%':
+		n, _ := strconv.Atoi(line[1:])
+		m := make(map[string]any, n)
+		for i := 0; i < n; i++ {
+			key, _ := c.read().(string)
+			m[key] = c.read()
+		}
+		return m
+	}
+	c.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func (c *respClient) expect(want any, args ...string) {
+	c.t.Helper()
+	if got := c.do(args...); fmt.Sprint(got) != fmt.Sprint(want) {
+		c.t.Fatalf("%s: got %#v, want %#v", strings.Join(args, " "), got, want)
+	}
+}
+
+func TestRESPListener(t *testing.T) {
+	srv, _, _ := startServer(t, func(cfg *config.Config) { cfg.RESPAddr = "127.0.0.1:0" })
+	dialAndLogin(t, srv.Addr().String()).command("create cache")
+
+	c := dialRESP(t, srv.RESPListener.Addr().String())
+	c.expect("+PONG", "PING")
+	c.expect("-NOAUTH Authentication required.", "GET", "k")
+	c.expect("-WRONGPASS invalid username-password pair or user is disabled.", "AUTH", "admin", "wrong")
+	c.expect("+OK", "AUTH", "admin", "secret")
+
+	if got := c.do("GET", "k"); !strings.HasPrefix(fmt.Sprint(got), "-ERR no bucket selected") {
+		t.Fatalf("expected an error without a bucket, got %#v", got)
+	}
+	if got := c.do("SELECT", "nope"); !strings.HasPrefix(fmt.Sprint(got), "-ERR") {
+		t.Fatalf("expected an error for a missing bucket, got %#v", got)
+	}
+	c.expect("+OK", "SELECT", "cache")
+
+	c.expect(nil, "GET", "k")
+	c.expect("+OK", "SET", "k", "v\r\n\x00")
+	c.expect("v\r\n\x00", "GET", "k")
+	c.expect(nil, "SET", "k", "other", "NX")
+	c.expect("+OK", "SET", "k", "v2", "XX")
+	c.expect(nil, "SET", "missing", "v", "XX")
+	c.expect("+OK", "SET", "new", "v", "NX", "EX", "60")
+	c.expect("-ERR syntax error", "SET", "k", "v", "NX", "XX")
+	c.expect("-ERR invalid expire time in 'set' command", "SET", "k", "v", "EX", "0")
+
+	c.expect("+OK", "SET", "short", "v", "PX", "50")
+	time.Sleep(100 * time.Millisecond)
+	c.expect(nil, "GET", "short")
+
+	c.expect(int64(2), "EXISTS", "k", "new", "missing")
+	c.expect(int64(1), "DEL", "new", "missing")
+	c.expect(int64(0), "EXISTS", "new")
+
+	// pipelined: everything is sent before any reply is read
+	for i := 0; i < 25; i++ {
+		c.send("SET", fmt.Sprintf("user:%02d", i), strconv.Itoa(i))
+	}
+	for i := 0; i < 25; i++ {
+		if got := c.read(); got != "+OK" {
+			t.Fatalf("pipelined SET %d: %#v", i, got)
+		}
+	}
+
+	var keys []string
+	cursor := "0"
+	for pages := 0; ; pages++ {
+		reply := c.do("SCAN", cursor, "MATCH", "user:*", "COUNT", "7").([]any)
+		for _, k := range reply[1].([]any) {
+			keys = append(keys, k.(string))
+		}
+		if cursor = reply[0].(string); cursor == "0" {
+			break
+		}
+		if pages > 10 {
+			t.Fatal("SCAN does not finish")
+		}
+	}
+	if len(keys) != 25 || !sort.StringsAreSorted(keys) {
+		t.Fatalf("SCAN returned %d keys: %v", len(keys), keys)
+	}
+	c.expect("-ERR invalid cursor", "SCAN", "12345")
+
+	if info := fmt.Sprint(c.do("INFO")); !strings.Contains(info, "cache:loaded=1,keys=26") {
+		t.Fatalf("unexpected INFO: %s", info)
+	}
+
+	// RESP3 after HELLO 3: maps and the null type
+	c2 := dialRESP(t, srv.RESPListener.Addr().String())
+	hello, ok := c2.do("HELLO", "3", "AUTH", "admin", "secret").(map[string]any)
+	if !ok || hello["proto"] != int64(3) || hello["server"] != "bytedata" {
+		t.Fatalf("unexpected HELLO reply: %#v", hello)
+	}
+	c2.expect("+OK", "SELECT", "cache")
+	c2.expect(nil, "GET", "missing")
+
+	// inline commands, as typed into telnet
+	io.WriteString(c2.conn, "PING hello\r\n")
+	if got := c2.read(); got != "hello" {
+		t.Fatalf("inline PING: %#v", got)
+	}
+	c2.expect("+OK", "QUIT")
+}
@@ -0,0 +1,511 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"byted/DB_engine/codec"
+	"byted/DB_engine/config"
+	"byted/DB_engine/core/auth"
+	"byted/DB_engine/core/backup"
+	"byted/DB_engine/core/bucket"
+	"byted/DB_engine/server"
+	"byted/DB_engine/structs"
+)
+
+// startServer runs a server on a random port with a fresh data directory and
+// an admin/secret account.
+func startServer(t testing.TB, configure func(cfg *config.Config)) (*server.Server, context.CancelFunc, chan error) {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.DataDir = t.TempDir()
+	cfg.AuthFile = filepath.Join(cfg.DataDir, "auth.json")
+	cfg.ShutdownTimeout = config.Duration{Duration: 2 * time.Second}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	auth.SetAuthFile(cfg.AuthFile)
+	if _, err := auth.Bootstrap("admin", "secret"); err != nil {
+		t.Fatal("Bootstrap failed:", err)
+	}
+
+	srv := server.NewServer(cfg)
+	if err := srv.Listen(); err != nil {
+		t.Fatal("Listen failed:", err)
+	}
+	<-srv.Ready()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return srv, cancel, done
+}
+
+type testClient struct {
+	t    testing.TB
+	conn net.Conn
+	enc  codec.Encoder
+	dec  codec.Decoder
+}
+
+// dialAndLogin connects and logs in as admin/secret.
+func dialAndLogin(t testing.TB, addr string) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("Dial failed:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &testClient{t: t, conn: conn, enc: codec.NewJSONEncoder(json.NewEncoder(conn)), dec: codec.NewJSONDecoder(json.NewDecoder(conn))}
+
+	c.expect("request")
+	c.enc.Encode(structs.Message{Type: "auth", Username: "admin"})
+	c.expect("request")
+	c.enc.Encode(structs.Message{Type: "auth", Username: "admin", Password: "secret"})
+	c.expect("success")
+	return c
+}
+
+// upgrade switches the connection to the binary framing.
+func (c *testClient) upgrade() {
+	c.t.Helper()
+	c.enc.Encode(structs.Message{Type: "hello", Version: codec.Version, Capabilities: []string{codec.CapBinary}})
+	if msg := c.expect("hello"); !codec.Has(msg.Capabilities, codec.CapBinary) {
+		c.t.Fatalf("server did not accept the binary framing: %+v", msg)
+	}
+	c.enc = codec.NewBinaryEncoder(c.conn)
+	c.dec = codec.NewBinaryDecoder(codec.Continue(c.dec, c.conn))
+}
+
+func (c *testClient) read() structs.Message {
+	c.t.Helper()
+	var msg structs.Message
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := c.dec.Decode(&msg); err != nil {
+		c.t.Fatal("read failed:", err)
+	}
+	return msg
+}
+
+func (c *testClient) expect(msgType string) structs.Message {
+	c.t.Helper()
+	msg := c.read()
+	if msg.Type != msgType {
+		c.t.Fatalf("expected %s message, got %+v", msgType, msg)
+	}
+	return msg
+}
+
+func (c *testClient) command(cmd string) structs.Message {
+	c.t.Helper()
+	c.enc.Encode(structs.Message{Type: "command", Command: cmd})
+	return c.read()
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	srv, cancel, done := startServer(t, nil)
+	cfg := srv.Config
+
+	c := dialAndLogin(t, srv.Addr().String())
+	for _, cmd := range []string{"create users", "use users", "put alice 42"} {
+		if msg := c.command(cmd); msg.Type != "success" {
+			t.Fatalf("%s failed: %+v", cmd, msg)
+		}
+	}
+
+	cancel()
+
+	// the client is told before the connection goes away
+	if msg := c.read(); msg.Type != "shutdown" {
+		t.Fatalf("expected shutdown notice, got %+v", msg)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal("Serve returned an error:", err)
+		}
+		done <- nil // for the cleanup
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	// no longer accepting
+	if conn, err := net.DialTimeout("tcp", srv.Addr().String(), time.Second); err == nil {
+		conn.Close()
+		t.Fatal("server still accepts connections after shutdown")
+	}
+
+	// WAL was flushed and metadata written: the data survives a reopen
+	bm, err := bucket.NewBucketManager(cfg.BucketsDir(), bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal("reopen failed:", err)
+	}
+	defer bm.Close()
+	b, err := bm.GetBucket("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := b.KvEngine.Get([]byte("alice")); err != nil || string(v) != "42" {
+		t.Fatalf("expected alice=42 after restart, got %q, %v", v, err)
+	}
+}
+
+func TestExportImportOverConnection(t *testing.T) {
+	srv, _, _ := startServer(t, func(cfg *config.Config) {
+		config.SetCurrent(cfg)
+		t.Cleanup(func() { config.SetCurrent(nil) })
+	})
+	c := dialAndLogin(t, srv.Addr().String())
+	if msg := c.command("create users"); msg.Type != "success" {
+		t.Fatalf("create failed: %+v", msg)
+	}
+
+	// client-side import: one message per batch
+	c.enc.Encode(structs.Message{Type: "import", Bucket: "users", Data: []string{
+		`{"key":"alice","value":"1"}`, `{"key":"AAE=","value":"/w==","encoding":"base64"}`,
+	}})
+	if msg := c.expect("success"); msg.Message != "LSN 1" {
+		t.Fatalf("unexpected import reply: %+v", msg)
+	}
+
+	c.enc.Encode(structs.Message{Type: "export", Bucket: "users"})
+	rows := c.expect("rows")
+	if len(rows.Data) != 2 || rows.Data[0] != `{"key":"AAE=","value":"/w==","encoding":"base64"}` {
+		t.Fatalf("unexpected rows: %v", rows.Data)
+	}
+	c.expect("success")
+
+	// server-side files stay inside export_dir
+	outside := filepath.Join(t.TempDir(), "users.csv")
+	for _, file := range []string{outside, "../users.csv", "a/../../users.csv"} {
+		if msg := c.command("export users " + file); msg.Type != "error" || !strings.Contains(msg.Message, "inside the export directory") {
+			t.Fatalf("export to %s: expected an error, got %+v", file, msg)
+		}
+		if msg := c.command("import users " + file); msg.Type != "error" {
+			t.Fatalf("import from %s: expected an error, got %+v", file, msg)
+		}
+	}
+
+	// server-side import reports progress after every batch
+	os.MkdirAll(srv.Config.ExportsDir(), 0755)
+	os.WriteFile(filepath.Join(srv.Config.ExportsDir(), "users.csv"), []byte("key,value\nbob,2\ncarol,3\n"), 0644)
+	c.enc.Encode(structs.Message{Type: "command", Command: "import users users.csv batch=1"})
+	c.expect("progress")
+	c.expect("progress")
+	if msg := c.expect("success"); len(msg.Data) != 1 || !strings.Contains(msg.Data[0], "Imported 2 key(s)") {
+		t.Fatalf("unexpected import summary: %+v", msg)
+	}
+}
+
+func TestBackupOverConnection(t *testing.T) {
+	srv, _, _ := startServer(t, func(cfg *config.Config) {
+		config.SetCurrent(cfg)
+		t.Cleanup(func() { config.SetCurrent(nil) })
+	})
+	c := dialAndLogin(t, srv.Addr().String())
+	c.command("create users")
+
+	// backups and their bases stay inside backup_dir
+	outside := filepath.Join(t.TempDir(), "x")
+	for _, cmd := range []string{"backup " + outside, "backup ../x", "backup full incremental " + outside, "backup full incremental ../x"} {
+		if msg := c.command(cmd); msg.Type != "error" || !strings.Contains(msg.Message, "inside the backup directory") {
+			t.Fatalf("%s: expected an error, got %+v", cmd, msg)
+		}
+	}
+	if _, err := os.Stat(outside); !os.IsNotExist(err) {
+		t.Fatal("a backup was written outside backup_dir:", err)
+	}
+
+	if msg := c.command("backup full"); msg.Type == "error" {
+		t.Fatalf("backup failed: %+v", msg)
+	}
+	if msg := c.command("backup incr incremental full"); msg.Type == "error" {
+		t.Fatalf("incremental backup failed: %+v", msg)
+	}
+	m, err := backup.Verify(filepath.Join(srv.Config.BackupsDir(), "incr"))
+	if err != nil {
+		t.Fatal("verify failed:", err)
+	}
+	if m.Base != filepath.Join(srv.Config.BackupsDir(), "full") {
+		t.Fatalf("unexpected base %q", m.Base)
+	}
+}
+
+func TestBinaryValuesRoundTrip(t *testing.T) {
+	srv, _, _ := startServer(t, nil)
+	c := dialAndLogin(t, srv.Addr().String())
+	for _, cmd := range []string{"create bin", "use bin", "put k hex:00ff80", "put b b64:AP8=", `put q "hex:00"`, `put "spaced key" "a  b"`} {
+		if msg := c.command(cmd); msg.Type != "success" {
+			t.Fatalf("%s failed: %+v", cmd, msg)
+		}
+	}
+
+	msg := c.command("get k")
+	if msg.Encoding != structs.EncodingBase64 {
+		t.Fatalf("expected a base64 encoded reply, got %+v", msg)
+	}
+	if err := msg.DecodeBinary(); err != nil || msg.Data[0] != "Value: \x00\xff\x80\n" {
+		t.Fatalf("value did not round-trip: %q, %v", msg.Data, err)
+	}
+	if msg := c.command(`get "spaced key"`); msg.Data[0] != "Value: a  b\n" {
+		t.Fatalf("whitespace not preserved: %q", msg.Data)
+	}
+	if msg := c.command("get hex:62"); msg.DecodeBinary() != nil || msg.Data[0] != "Value: \x00\xff\n" {
+		t.Fatalf("b64 value or hex key not decoded: %q", msg.Data)
+	}
+	if msg := c.command("get q"); msg.Data[0] != "Value: hex:00\n" {
+		t.Fatalf("quoted literal was decoded: %q", msg.Data)
+	}
+	if msg := c.command("put k hex:0g"); msg.Type != "error" {
+		t.Fatalf("expected an invalid hex literal to fail, got %+v", msg)
+	}
+
+	// a value is one argument, unquoted words are not joined
+	for _, cmd := range []string{"put k a    b", "put k b64:AP8= b64:AP8="} {
+		if msg := c.command(cmd); msg.Type != "error" || !strings.Contains(msg.Message, "quote") {
+			t.Fatalf("%s should have asked for quotes, got %+v", cmd, msg)
+		}
+	}
+	if msg := c.command("get k"); msg.DecodeBinary() != nil || msg.Data[0] != "Value: \x00\xff\x80\n" {
+		t.Fatalf("refused put changed the value: %q", msg.Data)
+	}
+
+	// only keys and values are literals, a bucket name is taken as written
+	c.command("exit")
+	if msg := c.command("create hex:41"); msg.Type != "success" {
+		t.Fatalf("create failed: %+v", msg)
+	}
+	if msg := c.command("use hex:41"); msg.Type != "success" {
+		t.Fatalf("bucket not created as hex:41: %+v", msg)
+	}
+
+	// a command with raw bytes is sent encoded as well
+	cmd := structs.Message{Type: "command", Command: "put raw \xfe\xfd"}
+	cmd.EncodeBinary()
+	c.enc.Encode(cmd)
+	c.expect("success")
+	msg = c.command("get raw")
+	msg.DecodeBinary()
+	if msg.Data[0] != "Value: \xfe\xfd\n" {
+		t.Fatalf("raw bytes did not round-trip: %q", msg.Data)
+	}
+}
+
+func TestStructuredRequests(t *testing.T) {
+	srv, _, _ := startServer(t, nil)
+	c := dialAndLogin(t, srv.Addr().String())
+
+	request := func(req structs.Request) structs.Message {
+		t.Helper()
+		c.enc.Encode(structs.Message{Type: "request", Request: &req})
+		return c.read()
+	}
+
+	if msg := request(structs.Request{Op: structs.OpCreate, Bucket: "kv", Options: []string{"order=8"}}); msg.Type != "result" {
+		t.Fatalf("create failed: %+v", msg)
+	}
+	if msg := request(structs.Request{Op: structs.OpCreate, Bucket: "kv"}); msg.Code != structs.CodeExists {
+		t.Fatalf("expected %s, got %+v", structs.CodeExists, msg)
+	}
+	if msg := request(structs.Request{Op: structs.OpGet, Key: []byte("k")}); msg.Code != structs.CodeNoBucket {
+		t.Fatalf("expected %s, got %+v", structs.CodeNoBucket, msg)
+	}
+
+	// data operations can name their bucket instead of using one
+	msg := request(structs.Request{Op: structs.OpPut, Bucket: "kv", Key: []byte("a"), Value: []byte{0, 0xff}})
+	if msg.Type != "result" || msg.Result.LSN != 1 {
+		t.Fatalf("put failed: %+v", msg)
+	}
+	request(structs.Request{Op: structs.OpPut, Bucket: "kv", Key: []byte("b"), Value: []byte("2")})
+
+	if msg := request(structs.Request{Op: structs.OpUse, Bucket: "kv"}); msg.Bucket != "kv" {
+		t.Fatalf("use did not switch buckets: %+v", msg)
+	}
+	if msg := request(structs.Request{Op: structs.OpGet, Key: []byte("a")}); !bytes.Equal(msg.Result.Value, []byte{0, 0xff}) {
+		t.Fatalf("unexpected value: %+v", msg)
+	}
+	if msg := request(structs.Request{Op: structs.OpGet, Key: []byte("missing")}); msg.Code != structs.CodeNotFound {
+		t.Fatalf("expected %s, got %+v", structs.CodeNotFound, msg)
+	}
+	msg = request(structs.Request{Op: structs.OpRange, Key: []byte("a"), End: []byte("z")})
+	if len(msg.Result.Pairs) != 2 || string(msg.Result.Pairs[1].Key) != "b" {
+		t.Fatalf("unexpected range result: %+v", msg.Result)
+	}
+	if msg := request(structs.Request{Op: structs.OpList}); len(msg.Result.Buckets) != 1 || !msg.Result.Buckets[0].Loaded {
+		t.Fatalf("unexpected list result: %+v", msg.Result)
+	}
+	if msg := request(structs.Request{Op: structs.OpPut, Key: []byte("a"), Value: []byte("x"), Cond: structs.CondAbsent}); msg.Code != structs.CodeCondition {
+		t.Fatalf("expected %s, got %+v", structs.CodeCondition, msg)
+	}
+	if msg := request(structs.Request{Op: structs.OpDelete, Key: []byte("missing"), Cond: structs.CondExists}); msg.Code != structs.CodeCondition {
+		t.Fatalf("expected %s, got %+v", structs.CodeCondition, msg)
+	}
+	msg = request(structs.Request{Op: structs.OpScan, Limit: 1})
+	if len(msg.Result.Pairs) != 1 || string(msg.Result.Next) != "b" {
+		t.Fatalf("unexpected scan result: %+v", msg.Result)
+	}
+	msg = request(structs.Request{Op: structs.OpScan, Key: msg.Result.Next, Limit: 1})
+	if len(msg.Result.Pairs) != 1 || msg.Result.Next != nil {
+		t.Fatalf("unexpected last scan page: %+v", msg.Result)
+	}
+	if msg := request(structs.Request{Op: "frobnicate"}); msg.Code != structs.CodeUnknownOp {
+		t.Fatalf("expected %s, got %+v", structs.CodeUnknownOp, msg)
+	}
+
+	request(structs.Request{Op: structs.OpExit})
+	c.command("freeze kv")
+	if msg := request(structs.Request{Op: structs.OpDelete, Bucket: "kv", Key: []byte("a")}); msg.Code != structs.CodeReadOnly {
+		t.Fatalf("expected %s, got %+v", structs.CodeReadOnly, msg)
+	}
+}
+
+func TestAdminRequests(t *testing.T) {
+	srv, _, _ := startServer(t, func(cfg *config.Config) {
+		config.SetCurrent(cfg)
+		t.Cleanup(func() { config.SetCurrent(nil) })
+	})
+	c := dialAndLogin(t, srv.Addr().String())
+
+	request := func(req structs.Request) *structs.Result {
+		t.Helper()
+		c.enc.Encode(structs.Message{Type: "request", Request: &req})
+		msg := c.read()
+		if msg.Type != "result" {
+			t.Fatalf("%s failed: %+v", req.Op, msg)
+		}
+		return msg.Result
+	}
+	requestCode := func(req structs.Request) string {
+		t.Helper()
+		c.enc.Encode(structs.Message{Type: "request", Request: &req})
+		return c.read().Code
+	}
+
+	request(structs.Request{Op: structs.OpCreate, Bucket: "kv"})
+	first := request(structs.Request{Op: structs.OpPut, Bucket: "kv", Key: []byte("a"), Value: []byte("1")}).LSN
+	request(structs.Request{Op: structs.OpPut, Bucket: "kv", Key: []byte("b"), Value: []byte("2")})
+
+	request(structs.Request{Op: structs.OpRename, Bucket: "kv", Target: "store"})
+	if code := requestCode(structs.Request{Op: structs.OpGet, Bucket: "kv", Key: []byte("a")}); code != structs.CodeNoSuchBucket {
+		t.Fatalf("old name still there: %s", code)
+	}
+	if res := request(structs.Request{Op: structs.OpClone, Bucket: "store", Target: "copy", AsOf: first}); res.Count != 1 || res.LSN != first {
+		t.Fatalf("clone as of LSN %d: %+v", first, res)
+	}
+	if code := requestCode(structs.Request{Op: structs.OpRename, Bucket: "store"}); code != structs.CodeInvalid {
+		t.Fatalf("rename without a target: %s", code)
+	}
+
+	res := request(structs.Request{Op: structs.OpAlter, Bucket: "store", Options: []string{"order=8", "protected=true"}})
+	if !slices.Contains(res.Options, "order=8") || !slices.Contains(res.Options, "protected=true") {
+		t.Fatalf("alter did not return the new options: %q", res.Options)
+	}
+
+	request(structs.Request{Op: structs.OpFreeze, Bucket: "copy"})
+	if code := requestCode(structs.Request{Op: structs.OpPut, Bucket: "copy", Key: []byte("c"), Value: []byte("3")}); code != structs.CodeReadOnly {
+		t.Fatalf("put to a frozen bucket: %s", code)
+	}
+	request(structs.Request{Op: structs.OpUnfreeze, Bucket: "copy"})
+	request(structs.Request{Op: structs.OpPin, Bucket: "copy"})
+	if list := request(structs.Request{Op: structs.OpList}); !list.Buckets[0].Pinned || list.Buckets[0].ReadOnly {
+		t.Fatalf("copy should be pinned and writable: %+v", list.Buckets)
+	}
+	request(structs.Request{Op: structs.OpUnpin, Bucket: "copy"})
+
+	request(structs.Request{Op: structs.OpDrop, Bucket: "copy"})
+	if trash := request(structs.Request{Op: structs.OpTrash}).Trash; len(trash) != 1 || trash[0].Name != "copy" || trash[0].DroppedAt == 0 {
+		t.Fatalf("unexpected trash: %+v", trash)
+	}
+	request(structs.Request{Op: structs.OpUndrop, Bucket: "copy"})
+	request(structs.Request{Op: structs.OpDrop, Bucket: "copy"})
+	if purged := request(structs.Request{Op: structs.OpPurge, Bucket: "copy"}).Trash; len(purged) != 1 {
+		t.Fatalf("expected one bucket purged, got %+v", purged)
+	}
+	if res := request(structs.Request{Op: structs.OpCatalog}); len(res.Buckets) != 0 {
+		t.Fatalf("nothing to rebuild, got %+v", res.Buckets)
+	}
+
+	res = request(structs.Request{Op: structs.OpBackup, Path: "full"})
+	if len(res.Buckets) != 1 || res.Buckets[0].Name != "store" || res.Buckets[0].LSN == 0 || res.Buckets[0].Bytes == 0 {
+		t.Fatalf("unexpected backup result: %+v", res)
+	}
+	if res := request(structs.Request{Op: structs.OpBackup, Path: "incr", Base: "full"}); res.Base != "full" {
+		t.Fatalf("incremental backup not based on full: %+v", res)
+	}
+	if code := requestCode(structs.Request{Op: structs.OpBackup, Path: "../x"}); code != structs.CodeInvalid {
+		t.Fatalf("backup outside backup_dir: %s", code)
+	}
+
+	if settings := request(structs.Request{Op: structs.OpConfig, Setting: "data_dir"}).Settings; len(settings) != 1 || settings[0].Value != srv.Config.DataDir {
+		t.Fatalf("unexpected data_dir setting: %+v", settings)
+	}
+	if code := requestCode(structs.Request{Op: structs.OpConfig, Setting: "nope"}); code != structs.CodeInvalid {
+		t.Fatalf("unknown setting: %s", code)
+	}
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	srv, _, _ := startServer(t, nil)
+	c := dialAndLogin(t, srv.Addr().String())
+
+	c.command("create a")
+	c.command("create b")
+
+	// send everything before reading any reply
+	const perBucket = 200
+	var id uint64
+	for i := 0; i < perBucket; i++ {
+		for _, name := range []string{"a", "b"} {
+			id++
+			req := structs.Request{Op: structs.OpPut, Bucket: name, Key: []byte(fmt.Sprintf("k%03d", i)), Value: []byte(name)}
+			c.enc.Encode(structs.Message{Type: "request", ID: id, Request: &req})
+		}
+	}
+	// not tagged, so it runs only after all of the puts
+	c.enc.Encode(structs.Message{Type: "command", Command: "use a"})
+	id++
+	c.enc.Encode(structs.Message{Type: "command", ID: id, Command: "get k199"})
+
+	seen := make(map[uint64]bool)
+	lastLSN := map[string]uint64{}
+	for i := 0; i < 2*perBucket; i++ {
+		msg := c.expect("result")
+		if msg.ID == 0 || msg.ID > 2*perBucket || seen[msg.ID] {
+			t.Fatalf("unexpected reply ID %d", msg.ID)
+		}
+		seen[msg.ID] = true
+
+		// puts on one bucket complete in the order they were sent
+		name := "a"
+		if msg.ID%2 == 0 {
+			name = "b"
+		}
+		if msg.Result.LSN != lastLSN[name]+1 {
+			t.Fatalf("bucket %s: LSN %d after %d", name, msg.Result.LSN, lastLSN[name])
+		}
+		lastLSN[name] = msg.Result.LSN
+	}
+	if msg := c.expect("success"); msg.ID != 0 || msg.Bucket != "a" {
+		t.Fatalf("unexpected reply to use: %+v", msg)
+	}
+	if msg := c.expect("success"); msg.ID != id || !strings.Contains(msg.Data[0], "Value: a") {
+		t.Fatalf("unexpected reply to get: %+v", msg)
+	}
+}
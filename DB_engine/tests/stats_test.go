@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"byted/DB_engine/core/btree"
+	"byted/DB_engine/core/kv"
+)
+
+func TestTreeStats(t *testing.T) {
+	tree := btree.New(4)
+	if s := tree.Stats(); s.Height != 1 || s.Nodes != 1 || s.Keys != 0 {
+		t.Fatalf("unexpected stats for an empty tree: %+v", s)
+	}
+	for i := 0; i < 100; i++ {
+		tree.Insert(fmt.Sprintf("key%03d", i), i)
+	}
+	s := tree.Stats()
+	if s.Keys != 100 || s.Height < 3 || s.Leaves >= s.Nodes {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if s.FillFactor <= 0.25 || s.FillFactor > 1 {
+		t.Fatalf("fill factor %.2f out of range", s.FillFactor)
+	}
+}
+
+func TestEngineStats(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "wal.log")
+	engine, err := kv.NewKVEngine(walPath, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.Put([]byte("a"), []byte("first"))
+	engine.Put([]byte("a"), []byte("second")) // the first put is now garbage
+	engine.Put([]byte("b"), []byte("bb"))
+	engine.Delete([]byte("b"))
+	engine.ApplyBatch([]kv.BatchOp{{Key: []byte("c"), Value: []byte("ccc")}, {Key: []byte("d"), Value: []byte("dddd")}})
+	engine.Get([]byte("a"))
+
+	s := engine.Stats()
+	if !s.Loaded || s.Keys != 3 || s.KeyBytes != 3 || s.ValueBytes != 13 || s.LastLSN != 5 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if s.Puts != 5 || s.Deletes != 1 || s.Gets != 1 {
+		t.Fatalf("unexpected counters: %+v", s)
+	}
+	if garbage := s.GarbageRatio(); garbage <= 0 || garbage >= 1 {
+		t.Fatalf("garbage ratio %.2f out of range", garbage)
+	}
+
+	// sizes come out the same when rebuilt from the WAL
+	engine.Unload()
+	if u := engine.Stats(); u.Loaded || u.LastLSN != 5 || u.WALBytes != s.WALBytes || u.Puts != 5 {
+		t.Fatalf("unexpected stats while unloaded: %+v", u)
+	}
+	engine.Load()
+	if r := engine.Stats(); r.LiveBytes != s.LiveBytes || r.Keys != s.Keys {
+		t.Fatalf("live bytes %d after reload, %d before", r.LiveBytes, s.LiveBytes)
+	}
+	engine.Close()
+}
@@ -0,0 +1,42 @@
+package tests
+
+import (
+	"reflect"
+	"testing"
+
+	"byted/DB_engine/cmd/cli"
+)
+
+func TestTokenize(t *testing.T) {
+	cases := []struct {
+		input string
+		want  []string
+	}{
+		{"put  key   value", []string{"put", "key", "value"}},
+		{`put "my key" 'two  spaces'`, []string{"put", "my key", "two  spaces"}},
+		{`put k "line\nbreak\t\"q\"\x41"`, []string{"put", "k", "line\nbreak\t\"q\"A"}},
+		{`put k 'no\nescape'`, []string{"put", "k", `no\nescape`}},
+		{`put k ""`, []string{"put", "k", ""}},
+		{`put a\ b c`, []string{"put", "a b", "c"}},
+		// unquoted whitespace separates, so put takes this as two values
+		{"put k a    b", []string{"put", "k", "a", "b"}},
+		// literals are decoded by the commands taking keys and values
+		{"put k hex:00ff80", []string{"put", "k", "hex:00ff80"}},
+		{`put pre"fix 'x'"`, []string{"put", "prefix 'x'"}},
+	}
+	for _, c := range cases {
+		got, err := cli.Tokenize(c.input)
+		if err != nil {
+			t.Fatalf("Tokenize(%q) failed: %v", c.input, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("Tokenize(%q) = %q, want %q", c.input, got, c.want)
+		}
+	}
+
+	for _, bad := range []string{`put "open`, `put 'open`, `put k \q`, `put k \x4`, `put k \`} {
+		if _, err := cli.Tokenize(bad); err == nil {
+			t.Fatalf("Tokenize(%q) should have failed", bad)
+		}
+	}
+}
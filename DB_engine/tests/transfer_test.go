@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"byted/DB_engine/core/bucket"
+	"byted/DB_engine/core/kv"
+	"byted/DB_engine/core/transfer"
+	"byted/DB_engine/core/wal"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	bm, err := bucket.NewBucketManager(filepath.Join(t.TempDir(), "buckets"), bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+	bm.CreateBucket("src", bm.Defaults)
+	src, _ := bm.GetBucket("src")
+
+	want := map[string]string{
+		"plain":          "hello world",
+		"csv":            "a,\"quoted\"\nmultiline",
+		"crlf":           "line\r\nbreak",
+		"\x00\xffbinary": "\xfe\x00\x01",
+	}
+	for k, v := range want {
+		src.KvEngine.Put([]byte(k), []byte(v))
+	}
+	src.KvEngine.PutWithTTL([]byte("ttl"), []byte("soon gone"), time.Hour)
+
+	for _, format := range []string{transfer.FormatJSONL, transfer.FormatCSV} {
+		var buf bytes.Buffer
+		w, _ := transfer.NewWriter(&buf, format)
+		count, _, err := src.Export(nil, nil, w.Write)
+		if err != nil || w.Flush() != nil {
+			t.Fatalf("%s export failed: %v", format, err)
+		}
+		if count != len(want)+1 {
+			t.Fatalf("%s: exported %d keys, want %d", format, count, len(want)+1)
+		}
+
+		name := "dst-" + format
+		bm.CreateBucket(name, bm.Defaults)
+		dst, _ := bm.GetBucket(name)
+		r, _ := transfer.NewReader(&buf, format)
+		p, err := transfer.Import(r, 2, dst.ImportBatch, nil)
+		if err != nil {
+			t.Fatalf("%s import failed: %v", format, err)
+		}
+		if p.Records != len(want)+1 || p.Batches != 3 {
+			t.Fatalf("%s: imported %d keys in %d batches", format, p.Records, p.Batches)
+		}
+		for k, v := range want {
+			got, err := dst.KvEngine.Get([]byte(k))
+			if err != nil || string(got) != v {
+				t.Fatalf("%s: key %q = %q (%v), want %q", format, k, got, err, v)
+			}
+		}
+		entries, _, _ := dst.KvEngine.Snapshot(0)
+		for _, e := range entries {
+			if string(e.Key) == "ttl" && e.ExpireAt == 0 {
+				t.Fatalf("%s: TTL lost on import", format)
+			}
+		}
+	}
+
+	// range export is inclusive at both ends
+	var buf bytes.Buffer
+	w, _ := transfer.NewWriter(&buf, transfer.FormatJSONL)
+	count, _, _ := src.Export([]byte("crlf"), []byte("plain"), w.Write)
+	if count != 3 {
+		t.Fatalf("range export wrote %d keys, want 3", count)
+	}
+}
+
+func TestBatchIsAtomic(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "wal.log")
+	engine, err := kv.NewKVEngine(walPath, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.Put([]byte("keep"), []byte("1"))
+	engine.SetMaxValueSize(4)
+	if _, err := engine.ApplyBatch([]kv.BatchOp{
+		{Key: []byte("a"), Value: []byte("ok")},
+		{Key: []byte("b"), Value: []byte("too long")},
+	}); err == nil {
+		t.Fatal("expected the oversized value to reject the batch")
+	}
+	if _, err := engine.Get([]byte("a")); err == nil {
+		t.Fatal("part of a rejected batch was applied")
+	}
+	lsn, err := engine.ApplyBatch([]kv.BatchOp{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("2")},
+		{Key: []byte("keep"), Delete: true},
+	})
+	if err != nil || lsn != 2 {
+		t.Fatalf("ApplyBatch = %d, %v", lsn, err)
+	}
+	engine.Close()
+
+	engine, err = kv.NewKVEngine(walPath, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := engine.Get([]byte("b")); string(v) != "2" {
+		t.Fatal("batch not replayed")
+	}
+	if _, err := engine.Get([]byte("keep")); err == nil {
+		t.Fatal("delete in batch not replayed")
+	}
+	engine.Close()
+
+	// a batch cut short by a crash is one torn record, none of it is complete
+	info, _ := os.Stat(walPath)
+	os.Truncate(walPath, info.Size()-3)
+	f, _ := os.Open(walPath)
+	defer f.Close()
+	var lsns []uint64
+	wal.ScanRecords(f, func(lsn uint64, offset int64) { lsns = append(lsns, lsn) })
+	if len(lsns) != 1 || lsns[0] != 1 {
+		t.Fatalf("expected only the record before the batch to survive, got LSNs %v", lsns)
+	}
+}
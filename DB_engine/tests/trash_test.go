@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"byted/DB_engine/constants"
+	"byted/DB_engine/core/bucket"
+)
+
+func TestDropMovesToTrash(t *testing.T) {
+	_, dir := seedBuckets(t, "orders")
+
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.DropBucket("orders"); err != nil {
+		t.Fatal("DropBucket failed:", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "orders")); !os.IsNotExist(err) {
+		t.Fatal("bucket directory still in place after drop")
+	}
+	entries, err := bm.ListTrash()
+	if err != nil || len(entries) != 1 || entries[0].Name != "orders" {
+		t.Fatalf("expected orders in the trash, got %+v, %v", entries, err)
+	}
+	bm.Close()
+
+	// the trash survives a restart and the bucket comes back with its data
+	bm, err = bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+	if _, err := bm.GetBucket("orders"); err == nil {
+		t.Fatal("dropped bucket loaded again after restart")
+	}
+	if err := bm.Undrop("orders"); err != nil {
+		t.Fatal("Undrop failed:", err)
+	}
+	b, err := bm.GetBucket("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := b.KvEngine.Get([]byte("k")); string(v) != "orders" {
+		t.Fatalf("data lost in the trash, got %q", v)
+	}
+	if entries, _ := bm.ListTrash(); len(entries) != 0 {
+		t.Fatalf("restored bucket still listed in the trash: %+v", entries)
+	}
+
+	// purge only takes entries past the retention
+	bm.DropBucket("orders")
+	if purged, _ := bm.PurgeTrash("", time.Hour); len(purged) != 0 {
+		t.Fatal("purged an entry still within the retention")
+	}
+	if purged, _ := bm.PurgeTrash("orders", 0); len(purged) != 1 {
+		t.Fatal("expected the entry to be purged")
+	}
+	if err := bm.Undrop("orders"); err == nil {
+		t.Fatal("undrop succeeded after purge")
+	}
+}
+
+func TestProtectedBucket(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "buckets")
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+
+	opts, _ := bucket.ParseOptions(bm.Defaults, []string{"protected=true"})
+	bm.CreateBucket("prod", opts)
+	if err := bm.DropBucket("prod"); err == nil {
+		t.Fatal("dropped a protected bucket")
+	}
+	if _, err := bm.AlterBucket("prod", []string{"protected=false"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.DropBucket("prod"); err != nil {
+		t.Fatal("drop after clearing protection failed:", err)
+	}
+}
+
+func TestUndropFailureKeepsTrashEntry(t *testing.T) {
+	_, dir := seedBuckets(t, "orders")
+	bm, err := bucket.NewBucketManager(dir, bucket.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+	bm.DropBucket("orders")
+
+	// options the bucket cannot be opened with
+	entries, _ := bm.ListTrash()
+	dirs, _ := os.ReadDir(bm.TrashDir())
+	infoPath := filepath.Join(bm.TrashDir(), dirs[0].Name(), constants.TRASHINFOFILE)
+	entry := entries[0]
+	entry.Options.Compression = "zstd"
+	data, _ := json.Marshal(entry)
+	os.WriteFile(infoPath, data, 0644)
+
+	if err := bm.Undrop("orders"); err == nil {
+		t.Fatal("expected undrop to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "orders")); !os.IsNotExist(err) {
+		t.Fatal("failed undrop left the bucket directory behind")
+	}
+	if entries, _ := bm.ListTrash(); len(entries) != 1 || entries[0].Name != "orders" {
+		t.Fatalf("entry not back in the trash: %+v", entries)
+	}
+}
@@ -0,0 +1,76 @@
+//go:build linux
+
+package tests
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"byted/DB_engine/codec"
+	"byted/DB_engine/config"
+	"byted/DB_engine/structs"
+)
+
+func dialUnix(t *testing.T, path string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal("Dial failed:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, enc: codec.NewJSONEncoder(json.NewEncoder(conn)), dec: codec.NewJSONDecoder(json.NewDecoder(conn))}
+}
+
+func TestUnixSocketPeerAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bytedata.sock")
+
+	// a socket file left behind by a crashed server is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal("Listen failed:", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	srv, _, _ := startServer(t, func(cfg *config.Config) {
+		cfg.UnixSocket = path
+		cfg.UnixPeerUsers = strconv.Itoa(os.Getuid()) + "=admin"
+	})
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode().Perm() != 0660 {
+		t.Fatalf("unexpected socket file: %v %v", fi, err)
+	}
+
+	// our uid is mapped, so there is no username or password exchange
+	c := dialUnix(t, path)
+	c.expect("success")
+	if msg := c.command("create local"); msg.Type != "success" {
+		t.Fatalf("create over the socket failed: %+v", msg)
+	}
+
+	// TCP clients still log in with a password
+	tcp := dialAndLogin(t, srv.Addr().String())
+	if msg := tcp.command("list"); msg.Type != "success" {
+		t.Fatalf("list over TCP failed: %+v", msg)
+	}
+}
+
+func TestUnixSocketPassword(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bytedata.sock")
+	startServer(t, func(cfg *config.Config) {
+		cfg.UnixSocket = path
+		// someone else's uid is mapped, ours has to log in
+		cfg.UnixPeerUsers = strconv.Itoa(os.Getuid()+1) + "=admin"
+	})
+
+	c := dialUnix(t, path)
+	c.expect("request")
+	c.enc.Encode(structs.Message{Type: "auth", Username: "admin"})
+	c.expect("request")
+	c.enc.Encode(structs.Message{Type: "auth", Username: "admin", Password: "secret"})
+	c.expect("success")
+}
@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"byted/DB_engine/codec"
+	"byted/DB_engine/config"
+	"byted/DB_engine/structs"
+)
+
+// wsConn is the client side of a WebSocket, enough for the tests: writes go
+// out as masked text frames, reads return the payloads of data frames.
+type wsConn struct {
+	net.Conn
+	r         *bufio.Reader
+	remaining int
+	pong      string // payload of the last pong
+}
+
+func dialWebSocket(t *testing.T, addr string) *wsConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("Dial failed:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", addr, key)
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal("handshake failed:", err)
+	}
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	if res.StatusCode != http.StatusSwitchingProtocols || res.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected handshake response: %d %v", res.StatusCode, res.Header)
+	}
+	return &wsConn{Conn: conn, r: r}
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 0x80|126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 0x80|127), uint64(n))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i&3])
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(0x1, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		var head [2]byte
+		if _, err := io.ReadFull(c.r, head[:]); err != nil {
+			return 0, err
+		}
+		opcode, n := head[0]&0x0f, int(head[1]&0x7f)
+		switch n {
+		case 126:
+			var ext [2]byte
+			io.ReadFull(c.r, ext[:])
+			n = int(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			io.ReadFull(c.r, ext[:])
+			n = int(binary.BigEndian.Uint64(ext[:]))
+		}
+		switch opcode {
+		case 0x8:
+			return 0, io.EOF
+		case 0xa:
+			payload := make([]byte, n)
+			io.ReadFull(c.r, payload)
+			c.pong = string(payload)
+		default:
+			c.remaining = n
+		}
+	}
+	if len(p) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= n
+	return n, err
+}
+
+func TestWebSocketWatch(t *testing.T) {
+	srv, _, _ := startServer(t, func(cfg *config.Config) { cfg.HTTPAddr = "127.0.0.1:0" })
+	addr := srv.HTTPListener.Addr().String()
+
+	if res, err := http.Get("http://" + addr + "/ws"); err != nil || res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a plain GET of /ws, got %v %v", res, err)
+	}
+
+	// the same login as over TCP
+	ws := dialWebSocket(t, addr)
+	c := &testClient{t: t, conn: ws, enc: codec.NewJSONEncoder(json.NewEncoder(ws)), dec: codec.NewJSONDecoder(json.NewDecoder(ws))}
+	c.expect("request")
+	c.enc.Encode(structs.Message{Type: "auth", Username: "admin"})
+	c.expect("request")
+	c.enc.Encode(structs.Message{Type: "auth", Username: "admin", Password: "secret"})
+	c.expect("success")
+
+	// WebSocket messages stay JSON
+	c.enc.Encode(structs.Message{Type: "hello", Version: codec.Version, Capabilities: []string{codec.CapBinary}})
+	if msg := c.expect("hello"); len(msg.Capabilities) != 0 {
+		t.Fatalf("binary framing accepted over a WebSocket: %+v", msg)
+	}
+
+	if msg := c.command("create feed"); msg.Type != "success" {
+		t.Fatalf("create failed: %+v", msg)
+	}
+	c.enc.Encode(structs.Message{Type: "watch", ID: 7, Bucket: "missing"})
+	if msg := c.expect("error"); msg.Code != structs.CodeNoSuchBucket {
+		t.Fatalf("unexpected reply to watching a missing bucket: %+v", msg)
+	}
+	c.enc.Encode(structs.Message{Type: "watch", ID: 7, Bucket: "feed", Data: []string{"user:"}})
+	if msg := c.expect("success"); msg.ID != 7 {
+		t.Fatalf("watch reply without its id: %+v", msg)
+	}
+
+	// changes made by another client are pushed, filtered by the prefix
+	other := dialAndLogin(t, srv.Addr().String())
+	for _, req := range []structs.Request{
+		{Op: structs.OpPut, Bucket: "feed", Key: []byte("user:1"), Value: []byte("a")},
+		{Op: structs.OpPut, Bucket: "feed", Key: []byte("order:1"), Value: []byte("b")},
+		{Op: structs.OpDelete, Bucket: "feed", Key: []byte("user:1")},
+	} {
+		other.enc.Encode(structs.Message{Type: "request", Request: &req})
+		other.expect("result")
+	}
+
+	ev := c.expect("event")
+	if ev.ID != 7 || ev.Bucket != "feed" || ev.Event.Op != structs.OpPut || string(ev.Event.Key) != "user:1" ||
+		string(ev.Event.Value) != "a" || ev.Event.LSN == 0 {
+		t.Fatalf("unexpected put event: %+v %+v", ev, ev.Event)
+	}
+	put := ev.Event.LSN
+	ev = c.expect("event")
+	if ev.Event.Op != structs.OpDelete || string(ev.Event.Key) != "user:1" || ev.Event.LSN <= put {
+		t.Fatalf("unexpected delete event: %+v", ev.Event)
+	}
+
+	// pings are answered while messages flow
+	ws.writeFrame(0x9, []byte("ping"))
+	c.enc.Encode(structs.Message{Type: "unwatch", Bucket: "feed"})
+	c.expect("success")
+	if ws.pong != "ping" {
+		t.Fatalf("expected a pong, got %q", ws.pong)
+	}
+	c.enc.Encode(structs.Message{Type: "unwatch", Bucket: "feed"})
+	c.expect("error")
+
+	// nothing arrives after unwatch: the next message is the reply
+	other.enc.Encode(structs.Message{Type: "request", Request: &structs.Request{Op: structs.OpPut, Bucket: "feed", Key: []byte("user:2"), Value: []byte("c")}})
+	other.expect("result")
+	if msg := c.command("list"); msg.Type != "success" {
+		t.Fatalf("expected the reply to list, got %+v", msg)
+	}
+}
@@ -1,132 +1,90 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"net"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"byted/DB_engine/constants"
+	"byted/DB_engine/config"
+	"byted/DB_engine/core/audit"
 	"byted/DB_engine/core/auth"
-	"byted/DB_engine/core/bucket"
-	"byted/DB_engine/structs"
-	"byted/DB_engine/cmd/cli"
+	"byted/DB_engine/server"
 )
 
-type Server struct {
-	ListenAddr string
-
-	Listener net.Listener
-}
-type ClientContext struct {
-	BucketManager *bucket.BucketManager
-}
+// exit codes
+const (
+	exitOK        = 0
+	exitError     = 1 // failed to start, or shutdown was not clean
+	exitBadConfig = 2
+)
 
-func (s *Server) Start() error {
-	ln, err := net.Listen("tcp", s.ListenAddr)
+func main() {
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
-		return fmt.Errorf("failed to start server: %v", err)
+		fmt.Println("Invalid configuration:", err)
+		os.Exit(exitBadConfig)
 	}
-	s.Listener = ln
-	defer s.Listener.Close()
-	fmt.Printf("Server listening on %s\n", s.ListenAddr)
-
-	s.acceptLoop()
-	return nil
-}
-
-func NewServer(listenAddr string) *Server {
-	return &Server{
-		ListenAddr: listenAddr,
+	config.SetCurrent(cfg)
+	auth.SetAuthFile(cfg.AuthFile)
+
+	// lock the data directory before anything in it is touched
+	srv := server.NewServer(cfg)
+	if err := srv.Listen(); err != nil {
+		fmt.Println(err)
+		os.Exit(exitError)
 	}
-}
-func communicators(conn net.Conn) *structs.Communicators {
-	enc := json.NewEncoder(conn)
-	dec := json.NewDecoder(conn)
-
-	return &structs.Communicators{Enc: enc, Dec: dec}
-}
-
-func (s *Server) acceptLoop() {
 
-	for {
-		conn, err := s.Listener.Accept()
-		if err != nil {
-			fmt.Fprintf(conn, "failed to accept connection: %v\n", err)
-			continue
-		}
-
-		comm := communicators(conn)
-		bm, _ := bucket.NewBucketManager(constants.DBBUCKETSPATH)
-
-		ctx := &ClientContext{
-			BucketManager: bm,
-		}
+	if err := audit.Init(cfg.AuditFile(), cfg.AuditMaxSize, cfg.AuditMaxFiles); err != nil {
+		fmt.Println("Audit log unavailable:", err)
+		srv.Shutdown()
+		os.Exit(exitError)
+	}
+	if err := applyAuditOff(cfg); err != nil {
+		fmt.Println("Audit log unavailable:", err)
+		srv.Shutdown()
+		os.Exit(exitError)
+	}
 
-		if !auth.HandleAuthenticatedConnection(comm) {
-			conn.Close()
-		}
+	token, err := auth.Bootstrap(cfg.AdminUser, cfg.AdminPassword)
+	if err != nil {
+		fmt.Println("Bootstrap failed:", err)
+		srv.Shutdown()
+		os.Exit(exitError)
+	}
+	if token != "" {
+		fmt.Println("No account configured yet, remote sessions are refused until setup is done.")
+		fmt.Printf("One-time setup token: %s\n", token)
+	}
 
-		go s.readLoop(comm, ctx, conn)
+	// SIGINT / SIGTERM start an orderly shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	if err := srv.Serve(ctx); err != nil {
+		fmt.Println(err)
+		stop()
+		os.Exit(exitError)
 	}
+	os.Exit(exitOK)
 }
 
-func (s *Server) readLoop(comm *structs.Communicators, ctx *ClientContext, conn net.Conn) {
-	for {
-		var msg structs.Message
-
-		// Read message from client
-		if err := comm.Dec.Decode(&msg); err != nil {
-			fmt.Println("Client disconnected:", err)
-			return
+// applyAuditOff switches off auditing of what audit_off lists.
+func applyAuditOff(cfg *config.Config) error {
+	classes, buckets, err := cfg.AuditExclusions()
+	if err != nil {
+		return err
+	}
+	for _, class := range classes {
+		if err := audit.Default().SetClass(class, false); err != nil {
+			return err
 		}
-		ActiveBucket, _ := ctx.BucketManager.GetActiveBucket()
-
-		switch msg.Type {
-		case "command":
-			// Execute the command
-			var data []string
-			var err error
-
-			if ActiveBucket == nil {
-				data, err = cli.ExecuteGlobalCommmand(msg.Command, ctx.BucketManager, conn)
-			} else {
-				data, err = cli.ExecuteCommand(msg.Command, ActiveBucket, ctx.BucketManager)
-			}
-			ActiveBucket, _ = ctx.BucketManager.GetActiveBucket()
-			var currentBkt string
-			if ActiveBucket == nil {
-				currentBkt = ""
-			} else {
-				currentBkt = ActiveBucket.Name
-			}
-			if err != nil {
-				// Send error back to client
-				comm.Enc.Encode(structs.Message{
-					Type:    "error",
-					Message: err.Error(),
-					Bucket:  currentBkt,
-				})
-				continue
-			} else {
-				comm.Enc.Encode(structs.Message{
-					Type:   "success",
-					Data:   data,
-					Bucket: currentBkt,
-				})
-			}
-
-		default:
-			// Unknown message type
-			comm.Enc.Encode(structs.Message{
-				Type:    "error",
-				Message: "Unknown message type: " + msg.Type,
-			})
+	}
+	for _, bucket := range buckets {
+		if err := audit.Default().SetBucket(bucket, false); err != nil {
+			return err
 		}
 	}
-}
-
-func main() {
-	server := NewServer(":8080")
-	server.Start()
+	return nil
 }
@@ -2,9 +2,9 @@ module byted
 
 go 1.24.4
 
-require golang.org/x/crypto v0.42.0
-
 require (
-	golang.org/x/sys v0.36.0 // indirect
-	golang.org/x/term v0.35.0 // indirect
+	golang.org/x/crypto v0.42.0
+	golang.org/x/term v0.35.0
 )
+
+require golang.org/x/sys v0.36.0 // indirect